@@ -24,6 +24,14 @@ struct {
     __uint(max_entries, 512 * 1024); // 512 KB
 } pipe SEC(".maps");
 
+// Counts the packets that were dropped because the ring buffer was full
+struct {
+    __uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+    __uint(max_entries, 1);
+    __type(key, __u32);
+    __type(value, __u64);
+} drops SEC(".maps");
+
 struct packet_t {
     struct in6_addr src_ip;
     struct in6_addr dst_ip;
@@ -147,6 +155,14 @@ int flat(struct __sk_buff* skb) {
     struct packet_t* pkt = NULL;
     pkt = bpf_ringbuf_reserve(&pipe, sizeof(struct packet_t), 0);
     if (!pkt) {
+        __u32 key = 0;
+        __u64* dropped = bpf_map_lookup_elem(&drops, &key);
+
+        // Every CPU has its own counter, so no atomic increment is needed
+        if (dropped) {
+            *dropped += 1;
+        }
+
         return TC_ACT_OK;
     }
 
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pouriyajamshidi/flat/internal/control"
+)
+
+const ctlUsage = `Usage: flat ctl [-s socket] [-json] command
+
+Commands:
+  status                          show the interface, filters and counters
+  filters                         list the IP and port filters
+  filter add|remove ip ADDRESS    track or stop tracking an IP address
+  filter add|remove port PORT     track or stop tracking a port
+  verbosity normal|quiet          print every result or none of them
+  flows                           dump the pending flows
+  reset-stats                     discard the per destination statistics
+`
+
+// parseCtlRequest builds the control request of the flat ctl arguments
+func parseCtlRequest(args []string) (control.Request, error) {
+	if len(args) == 0 {
+		return control.Request{}, fmt.Errorf("missing command")
+	}
+
+	request := control.Request{Command: args[0]}
+
+	switch args[0] {
+	case control.Status, control.Filters, control.Flows, control.ResetStats:
+		if len(args) != 1 {
+			return control.Request{}, fmt.Errorf("%v takes no arguments", args[0])
+		}
+
+	case control.Verbosity:
+		if len(args) != 2 {
+			return control.Request{}, fmt.Errorf("verbosity takes %v or %v", control.Normal, control.Quiet)
+		}
+
+		request.Verbosity = args[1]
+
+	case "filter":
+		if len(args) != 4 || (args[1] != "add" && args[1] != "remove") {
+			return control.Request{}, fmt.Errorf("expected filter add|remove ip|port VALUE")
+		}
+
+		request.Command = control.AddFilter
+
+		if args[1] == "remove" {
+			request.Command = control.RemoveFilter
+		}
+
+		switch args[2] {
+		case "ip":
+			ip, err := netip.ParseAddr(args[3])
+			if err != nil {
+				return control.Request{}, fmt.Errorf("parsing IP address: %w", err)
+			}
+
+			request.IP = ip
+		case "port":
+			port, err := strconv.ParseUint(args[3], 10, 16)
+			if err != nil || port == 0 {
+				return control.Request{}, fmt.Errorf("could not parse port %v", args[3])
+			}
+
+			request.Port = uint16(port)
+		default:
+			return control.Request{}, fmt.Errorf("can only filter on ip or port, not %v", args[2])
+		}
+
+	default:
+		return control.Request{}, fmt.Errorf("unknown command %v", args[0])
+	}
+
+	return request, nil
+}
+
+func printFilters(w io.Writer, filters *control.FilterSet) {
+	if len(filters.IPs) == 0 && len(filters.Ports) == 0 {
+		fmt.Fprintln(w, "No IP or port filters, every flow is tracked")
+		return
+	}
+
+	for _, ip := range filters.IPs {
+		fmt.Fprintf(w, "ip\t%v\n", ip)
+	}
+
+	for _, port := range filters.Ports {
+		fmt.Fprintf(w, "port\t%d\n", port)
+	}
+}
+
+func printResponse(request control.Request, response control.Response) error {
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	defer table.Flush()
+
+	switch {
+	case response.State != nil && request.Command == control.Status:
+		state := response.State
+
+		fmt.Fprintf(table, "interface:\t%v (index %d)\n", state.Interface, state.Index)
+		fmt.Fprintf(table, "attached:\t%v (%v ago)\n", state.AttachedAt.Format(time.RFC3339), time.Since(state.AttachedAt).Round(time.Second))
+		fmt.Fprintf(table, "verbosity:\t%v\n", state.Verbosity)
+		fmt.Fprintf(table, "ip filters:\t%v\n", state.Filters.IPs)
+		fmt.Fprintf(table, "port filters:\t%v\n", state.Filters.Ports)
+		fmt.Fprintf(table, "flow table:\t%+v\n", state.FlowTable)
+		fmt.Fprintf(table, "ring buffer:\t%d of %d bytes pending\n", state.RingbufPending, state.RingbufSize)
+
+		for _, name := range slices.Sorted(maps.Keys(state.Dropped)) {
+			fmt.Fprintf(table, "dropped by %v:\t%d\n", name, state.Dropped[name])
+		}
+
+	case response.State != nil:
+		fmt.Fprintf(table, "verbosity:\t%v\n", response.State.Verbosity)
+
+	case response.Filters != nil:
+		printFilters(table, response.Filters)
+
+	case request.Command == control.Flows:
+		fmt.Fprintln(table, "protocol\tsource\tdestination\tpending\tprocess\t")
+
+		for _, flow := range response.Flows {
+			process := ""
+
+			if flow.PID != 0 {
+				process = fmt.Sprintf("%d (%v)", flow.PID, flow.Comm)
+			}
+
+			src, err := netip.ParseAddr(flow.SrcIP)
+			if err != nil {
+				return fmt.Errorf("parsing source address: %w", err)
+			}
+
+			dst, err := netip.ParseAddr(flow.DstIP)
+			if err != nil {
+				return fmt.Errorf("parsing destination address: %w", err)
+			}
+
+			fmt.Fprintf(table, "%v\t%v\t%v\t%v\t%v\t\n",
+				flow.Protocol,
+				netip.AddrPortFrom(src, flow.SrcPort),
+				netip.AddrPortFrom(dst, flow.DstPort),
+				time.Duration(flow.PendingNs).Round(time.Millisecond),
+				process,
+			)
+		}
+
+	case request.Command == control.ResetStats:
+		fmt.Fprintln(table, "Reset the latency statistics")
+	}
+
+	return nil
+}
+
+// runCtl sends a command to a running instance and prints its answer
+func runCtl(args []string) {
+	flags := flag.NewFlagSet("ctl", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), ctlUsage)
+		flags.PrintDefaults()
+	}
+
+	socketFlag := flags.String("s", control.DefaultPath, "Control socket of the running instance")
+	jsonFlag := flags.Bool("json", false, "Print the answer as JSON")
+
+	flags.Parse(args)
+
+	request, err := parseCtlRequest(flags.Args())
+	if err != nil {
+		log.Printf("Could not parse the command: %v", err)
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	response, err := control.Call(*socketFlag, request)
+	if err != nil {
+		log.Printf("Failed sending %v to %v: %v", request.Command, *socketFlag, err)
+		os.Exit(1)
+	}
+
+	if *jsonFlag {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(response)
+		return
+	}
+
+	if err := printResponse(request, response); err != nil {
+		log.Printf("Could not print the answer of %v: %v", request.Command, err)
+		os.Exit(1)
+	}
+}
@@ -5,13 +5,25 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"math"
 	"net"
 	"net/netip"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
+	"github.com/pouriyajamshidi/flat/internal/baseline"
+	"github.com/pouriyajamshidi/flat/internal/control"
+	"github.com/pouriyajamshidi/flat/internal/flowtable"
+	"github.com/pouriyajamshidi/flat/internal/history"
+	"github.com/pouriyajamshidi/flat/internal/ipfix"
+	"github.com/pouriyajamshidi/flat/internal/metrics"
+	"github.com/pouriyajamshidi/flat/internal/otlp"
 	"github.com/pouriyajamshidi/flat/internal/probe"
+	"github.com/pouriyajamshidi/flat/internal/report"
+	"github.com/pouriyajamshidi/flat/internal/stats"
 	"github.com/pouriyajamshidi/flat/internal/types"
 	"github.com/vishvananda/netlink"
 )
@@ -48,6 +60,59 @@ func getUserInput() types.UserInput {
 	ifaceFlag := flag.String("i", "eth0", "interface to attach the probe to")
 	ipFlag := flag.String("ip", "", "IP address to track (optional)")
 	portFlag := flag.Uint("port", 0, "Port number to track (optional)")
+	maxFlowsFlag := flag.Int("max-flows", flowtable.DefaultConfig().MaxEntries, "Maximum number of pending flows to track")
+	tcpTimeoutFlag := flag.Duration("tcp-timeout", flowtable.DefaultConfig().TCPTimeout, "How long to wait for a SYN/ACK")
+	udpTimeoutFlag := flag.Duration("udp-timeout", flowtable.DefaultConfig().UDPTimeout, "How long to wait for a UDP reply")
+	intervalFlag := flag.Duration("interval", 0, "Print a summary table every interval, e.g. 10s (optional)")
+	summaryOnlyFlag := flag.Bool("summary-only", false, "Only print the interval summaries, not every measurement (optional)")
+	groupByFlag := flag.String("group-by", "destination", "Aggregate summaries by "+strings.Join(report.GroupBy, ", "))
+	tuiFlag := flag.Bool("tui", false, "Show a live, sortable full-screen flow table (optional)")
+	outputFlag := flag.String("output", "text", "Print every result as text or json (one object per line)")
+	jsonFileFlag := flag.String("json-file", "", "Also append every result to a JSON Lines file, whatever -output is (optional)")
+	statsFlag := flag.Bool("stats", false, "Print per destination latency statistics on exit (optional)")
+	statsWindowFlag := flag.Duration("stats-window", stats.DefaultConfig().Window, "How far back the recent per destination statistics go")
+	statsMaxDestinationsFlag := flag.Int("stats-max-destinations", stats.DefaultConfig().MaxKeys, "Maximum number of destinations to keep statistics for, the least recently seen is dropped")
+	processFlag := flag.Bool("pid", false, "Attribute flows to local processes (optional)")
+	kubeSnapshotFlag := flag.String("k8s-snapshot", "", "Kubernetes pods/services/endpoints JSON list to enrich IPs with (optional)")
+	kubeRefreshFlag := flag.Duration("k8s-refresh", time.Second*30, "How often to reload the Kubernetes snapshot")
+	kubePodFlag := flag.String("k8s-pod", "", "Kubernetes pod to track as namespace/name (optional)")
+	resolveFlag := flag.Bool("resolve", false, "Resolve IP addresses to hostnames using reverse DNS (optional)")
+	resolveServerFlag := flag.String("resolve-server", "", "DNS server to send reverse lookups to as host:port (optional)")
+	resolveTTLFlag := flag.Duration("resolve-ttl", time.Minute*5, "How long to cache resolved hostnames")
+	dnsSnoopFlag := flag.Bool("dns-snoop", false, "Label IP addresses with the names learned from DNS responses (optional)")
+	geoIPFlag := flag.String("geoip-db", "", "MaxMind City or Country .mmdb database to enrich IPs with (optional)")
+	asnDBFlag := flag.String("asn-db", "", "MaxMind ASN .mmdb database to enrich IPs with (optional)")
+	countryFlag := flag.String("country", "", "ISO country code to track, requires -geoip-db (optional)")
+	asnFlag := flag.Uint("asn", 0, "Autonomous system number to track, requires -asn-db (optional)")
+	kubeServiceFlag := flag.String("k8s-service", "", "Kubernetes service to track as namespace/name (optional)")
+	alertsFlag := flag.String("alerts", "", "JSON file of latency alert rules and actions (optional)")
+	metricsAddrFlag := flag.String("metrics-addr", "", "Address to serve Prometheus metrics on, e.g. :9800 (optional)")
+	metricsDestinationsFlag := flag.String("metrics-destinations", "", "Comma separated IPs or prefixes that get their own destination label (optional)")
+	metricsMaxDestinationsFlag := flag.Int("metrics-max-destinations", metrics.DefaultMaxDestinations, "Maximum number of destination labels, the rest are labelled other")
+	otlpEndpointFlag := flag.String("otlp-endpoint", "", "OTLP/HTTP collector to export metrics to, e.g. http://localhost:4318 (optional)")
+	otlpIntervalFlag := flag.Duration("otlp-interval", time.Second*10, "How often to export to the OTLP collector")
+	otlpSpansFlag := flag.Bool("otlp-spans", false, "Also export every TCP handshake and DNS transaction as a span (optional)")
+	apiAddrFlag := flag.String("api-addr", "", "Address to serve the HTTP API on, e.g. localhost:9810 or unix:/run/flat.sock (optional)")
+	controlFlag := flag.String("control", "", "Unix socket to accept flat ctl commands on, e.g. "+control.DefaultPath+" (optional)")
+	apiTokenFileFlag := flag.String("api-token-file", "", "File holding the bearer token the HTTP API requires (optional)")
+	statsdAddrFlag := flag.String("statsd-addr", "", "StatsD agent to send metrics to as host:port, e.g. 127.0.0.1:8125 (optional)")
+	statsdPrefixFlag := flag.String("statsd-prefix", "flat.", "Prefix of the metrics sent to StatsD")
+	ipfixAddrFlag := flag.String("ipfix-addr", "", "IPFIX collector to export a record per measured flow to as host:port, e.g. 127.0.0.1:4739 (optional)")
+	ipfixTransportFlag := flag.String("ipfix-transport", "udp", "Transport to reach the IPFIX collector over, udp or tcp")
+	ipfixTemplateRefreshFlag := flag.Duration("ipfix-template-refresh", ipfix.DefaultTemplateRefresh, "How often to resend the IPFIX templates over UDP")
+	ipfixEnterpriseFlag := flag.Uint("ipfix-enterprise", 0, "Private enterprise number to export the nanosecond latency element under (optional)")
+	historyFlag := flag.String("history", "", "SQLite file to keep per interval latency aggregates in, for flat query (optional)")
+	historyIntervalFlag := flag.Duration("history-interval", history.DefaultInterval, "How long each stored aggregate covers")
+	historySamplesFlag := flag.Bool("history-samples", false, "Also store every measured latency in the history file (optional)")
+	historyRetentionFlag := flag.Duration("history-retention", history.DefaultRetention, "How long to keep the stored latencies for, aggregates are kept forever")
+	pcapFlag := flag.String("pcap", "", "Write the packets of every result to a pcapng file (optional)")
+	pcapRotateSizeFlag := flag.Int64("pcap-rotate-size", 0, "Rotate the pcapng file once it exceeds this many megabytes (optional)")
+	pcapRotateIntervalFlag := flag.Duration("pcap-rotate-interval", 0, "Rotate the pcapng file at this interval, e.g. 1h (optional)")
+	anomalyFlag := flag.Bool("anomaly", false, "Flag latencies that deviate from each destination's learned baseline (optional)")
+	anomalySensitivityFlag := flag.Float64("anomaly-sensitivity", baseline.DefaultConfig().Sensitivity, "How many standard deviations away from the baseline a latency is flagged at")
+	anomalyWarmUpFlag := flag.Uint64("anomaly-warmup", baseline.DefaultConfig().WarmUp, "How many measurements a destination needs before it is scored")
+	baselineFileFlag := flag.String("baseline-file", "", "File to persist the learned baselines to across restarts (optional)")
+	recordFlag := flag.String("record", "", "Record every raw eBPF event to a file for flat replay (optional)")
 
 	flag.Parse()
 
@@ -62,15 +127,34 @@ func getUserInput() types.UserInput {
 
 	userInput.Interface = iface
 
+	if *maxFlowsFlag < 1 {
+		log.Printf("Could not use %d as the maximum number of flows", *maxFlowsFlag)
+		os.Exit(1)
+	}
+
+	if *tcpTimeoutFlag <= 0 || *udpTimeoutFlag <= 0 {
+		log.Printf("Could not use the TCP timeout %v and UDP timeout %v, both must be positive", *tcpTimeoutFlag, *udpTimeoutFlag)
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	userInput.FlowTable = flowtable.DefaultConfig()
+	userInput.FlowTable.MaxEntries = *maxFlowsFlag
+	userInput.FlowTable.TCPTimeout = *tcpTimeoutFlag
+	userInput.FlowTable.UDPTimeout = *udpTimeoutFlag
+	userInput.FlowTable.PruneInterval = max(min(userInput.FlowTable.PruneInterval, *tcpTimeoutFlag, *udpTimeoutFlag), flowtable.MinPruneInterval)
+
 	if *ipFlag != "" {
-		userInput.IP, err = netip.ParseAddr(*ipFlag)
+		ip, err := netip.ParseAddr(*ipFlag)
 
 		if err != nil {
 			log.Printf("Could not parse IP address %v: %v", *ipFlag, err)
 			os.Exit(1)
 		}
 
-		log.Printf("Filtering results on IP %v", userInput.IP)
+		userInput.IPs = []netip.Addr{ip.Unmap()}
+
+		log.Printf("Filtering results on IP %v", ip)
 	}
 
 	if *portFlag != 0 {
@@ -79,20 +163,459 @@ func getUserInput() types.UserInput {
 			os.Exit(1)
 		}
 
-		userInput.Port = uint16(*portFlag)
+		userInput.Ports = []uint16{uint16(*portFlag)}
+
+		log.Printf("Filtering results on port %d", *portFlag)
+	}
+
+	if *kubeSnapshotFlag != "" {
+		if *kubeRefreshFlag <= 0 {
+			log.Printf("Could not use %v as the Kubernetes snapshot refresh interval", *kubeRefreshFlag)
+			flag.Usage()
+			os.Exit(1)
+		}
+
+		userInput.KubeSnapshot = *kubeSnapshotFlag
+		userInput.KubeRefresh = *kubeRefreshFlag
+
+		log.Printf("Enriching results with Kubernetes metadata from %v", userInput.KubeSnapshot)
+	}
+
+	if *kubePodFlag != "" || *kubeServiceFlag != "" {
+		if userInput.KubeSnapshot == "" {
+			log.Println("Filtering on Kubernetes pods or services requires -k8s-snapshot")
+			os.Exit(1)
+		}
+
+		userInput.KubePod = *kubePodFlag
+		userInput.KubeService = *kubeServiceFlag
+
+		log.Printf("Filtering results on Kubernetes pod %q service %q", userInput.KubePod, userInput.KubeService)
+	}
+
+	if *resolveFlag {
+		userInput.Resolve = true
+		userInput.ResolveServer = *resolveServerFlag
+		userInput.ResolveTTL = *resolveTTLFlag
+
+		log.Println("Resolving IP addresses to hostnames")
+	}
+
+	if *dnsSnoopFlag {
+		userInput.DNSSnoop = true
+
+		log.Println("Labelling IP addresses with names from DNS responses")
+	}
+
+	if *geoIPFlag != "" || *asnDBFlag != "" {
+		userInput.GeoIPDatabase = *geoIPFlag
+		userInput.ASNDatabase = *asnDBFlag
+
+		log.Println("Enriching results with GeoIP and ASN information")
+	}
+
+	if *countryFlag != "" {
+		if userInput.GeoIPDatabase == "" {
+			log.Println("Filtering on country requires -geoip-db")
+			os.Exit(1)
+		}
+
+		userInput.Country = strings.ToUpper(*countryFlag)
+
+		log.Printf("Filtering results on country %v", userInput.Country)
+	}
+
+	if *asnFlag != 0 {
+		if userInput.ASNDatabase == "" {
+			log.Println("Filtering on ASN requires -asn-db")
+			os.Exit(1)
+		}
+
+		if *asnFlag > math.MaxUint32 {
+			log.Printf("Could not parse ASN %v", *asnFlag)
+			os.Exit(1)
+		}
+
+		userInput.ASN = uint32(*asnFlag)
+
+		log.Printf("Filtering results on AS%d", userInput.ASN)
+	}
+
+	if *alertsFlag != "" {
+		userInput.AlertRules = *alertsFlag
+
+		log.Printf("Evaluating latency alerts from %v", userInput.AlertRules)
+	}
+
+	if *metricsAddrFlag != "" {
+		if *metricsMaxDestinationsFlag < 0 {
+			log.Printf("Could not use %d as the maximum number of destination labels", *metricsMaxDestinationsFlag)
+			os.Exit(1)
+		}
+
+		userInput.MetricsAddr = *metricsAddrFlag
+		userInput.Metrics.MaxDestinations = *metricsMaxDestinationsFlag
+
+		for value := range strings.SplitSeq(*metricsDestinationsFlag, ",") {
+			if value == "" {
+				continue
+			}
+
+			prefix, err := parsePrefix(strings.TrimSpace(value))
+			if err != nil {
+				log.Printf("Could not parse metrics destination %v: %v", value, err)
+				os.Exit(1)
+			}
+
+			userInput.Metrics.Destinations = append(userInput.Metrics.Destinations, prefix)
+		}
+	}
+
+	if *otlpEndpointFlag != "" {
+		if *otlpIntervalFlag <= 0 {
+			log.Printf("Could not use %v as the OTLP export interval", *otlpIntervalFlag)
+			os.Exit(1)
+		}
+
+		userInput.OTLP = otlp.Config{
+			Endpoint: *otlpEndpointFlag,
+			Interval: *otlpIntervalFlag,
+			Spans:    *otlpSpansFlag,
+			Resource: otlp.DefaultResource(iface.Attrs().Name),
+		}
+
+		log.Printf("Exporting to the OTLP collector at %v every %v", userInput.OTLP.Endpoint, userInput.OTLP.Interval)
+	}
+
+	userInput.ControlSocket = *controlFlag
+
+	if *apiAddrFlag != "" {
+		userInput.API.Addr = *apiAddrFlag
+
+		if *apiTokenFileFlag != "" {
+			token, err := os.ReadFile(*apiTokenFileFlag)
+			if err != nil {
+				log.Printf("Could not read API token: %v", err)
+				os.Exit(1)
+			}
+
+			userInput.API.Token = strings.TrimSpace(string(token))
+
+			if userInput.API.Token == "" {
+				log.Printf("Could not use the empty API token in %v", *apiTokenFileFlag)
+				os.Exit(1)
+			}
+		}
+	}
+
+	if *statsdAddrFlag != "" {
+		userInput.StatsDAddr = *statsdAddrFlag
+		userInput.StatsDPrefix = *statsdPrefixFlag
+
+		log.Printf("Sending metrics to StatsD at %v", userInput.StatsDAddr)
+	}
+
+	if *ipfixAddrFlag != "" {
+		if *ipfixTransportFlag != "udp" && *ipfixTransportFlag != "tcp" {
+			log.Printf("Could not use %q as the IPFIX transport, expected udp or tcp", *ipfixTransportFlag)
+			os.Exit(1)
+		}
+
+		if *ipfixTemplateRefreshFlag <= 0 {
+			log.Printf("Could not use %v as the IPFIX template refresh interval", *ipfixTemplateRefreshFlag)
+			os.Exit(1)
+		}
+
+		if *ipfixEnterpriseFlag > math.MaxUint32 {
+			log.Printf("Could not parse enterprise number %v", *ipfixEnterpriseFlag)
+			os.Exit(1)
+		}
+
+		userInput.IPFIX = ipfix.Config{
+			Addr:             *ipfixAddrFlag,
+			Transport:        *ipfixTransportFlag,
+			TemplateRefresh:  *ipfixTemplateRefreshFlag,
+			EnterpriseNumber: uint32(*ipfixEnterpriseFlag),
+		}
+
+		log.Printf("Exporting IPFIX records to %v over %v", userInput.IPFIX.Addr, userInput.IPFIX.Transport)
+	}
+
+	if *historyFlag != "" {
+		if *historyIntervalFlag <= 0 || *historyRetentionFlag <= 0 {
+			log.Println("Could not use a history interval or retention that is not positive")
+			os.Exit(1)
+		}
+
+		userInput.History = history.Config{
+			Path:      *historyFlag,
+			Interval:  *historyIntervalFlag,
+			Samples:   *historySamplesFlag,
+			Retention: *historyRetentionFlag,
+		}
+
+		log.Printf("Keeping the latency history in %v", userInput.History.Path)
+	}
+
+	if *pcapFlag != "" {
+		if *pcapRotateSizeFlag < 0 || *pcapRotateIntervalFlag < 0 {
+			log.Println("Could not use a negative pcapng rotation size or interval")
+			os.Exit(1)
+		}
+
+		userInput.PcapFile = *pcapFlag
+		userInput.PcapMaxSize = *pcapRotateSizeFlag * 1024 * 1024
+		userInput.PcapMaxAge = *pcapRotateIntervalFlag
 
-		log.Printf("Filtering results on port %d", userInput.Port)
+		log.Printf("Writing the packets of every result to %v", userInput.PcapFile)
+	}
+
+	if !*anomalyFlag {
+		// The baseline flags would otherwise be silently ignored
+		flag.Visit(func(f *flag.Flag) {
+			if strings.HasPrefix(f.Name, "anomaly-") || f.Name == "baseline-file" {
+				log.Printf("Could not use -%v without -anomaly", f.Name)
+				os.Exit(1)
+			}
+		})
+	}
+
+	if *anomalyFlag {
+		if *anomalySensitivityFlag <= 0 {
+			log.Printf("Could not use %v as the anomaly sensitivity", *anomalySensitivityFlag)
+			os.Exit(1)
+		}
+
+		userInput.Anomaly = true
+		userInput.Baseline = baseline.DefaultConfig()
+		userInput.Baseline.Sensitivity = *anomalySensitivityFlag
+		userInput.Baseline.WarmUp = *anomalyWarmUpFlag
+		userInput.BaselineFile = *baselineFileFlag
+
+		log.Printf("Flagging latencies %v standard deviations away from their baseline", userInput.Baseline.Sensitivity)
+	}
+
+	if *recordFlag != "" {
+		userInput.RecordFile = *recordFlag
+
+		log.Printf("Recording every eBPF event to %v", userInput.RecordFile)
+	}
+
+	userInput.Stats = *statsFlag
+
+	if *statsWindowFlag <= 0 || *statsMaxDestinationsFlag < 1 {
+		log.Printf("Could not use the statistics window %v and %d destinations, both must be positive", *statsWindowFlag, *statsMaxDestinationsFlag)
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	userInput.Statistics = stats.DefaultConfig()
+	userInput.Statistics.Window = *statsWindowFlag
+	userInput.Statistics.MaxKeys = *statsMaxDestinationsFlag
+
+	if *summaryOnlyFlag && *intervalFlag == 0 {
+		*intervalFlag = time.Second * 10
+	}
+
+	if *intervalFlag < 0 {
+		log.Printf("Could not use %v as the summary interval", *intervalFlag)
+		os.Exit(1)
+	}
+
+	if *intervalFlag > 0 {
+		if _, err := report.KeyFunc(*groupByFlag); err != nil {
+			log.Printf("Could not group summaries: %v", err)
+			os.Exit(1)
+		}
+
+		userInput.Interval = *intervalFlag
+		userInput.SummaryOnly = *summaryOnlyFlag
+		userInput.GroupBy = *groupByFlag
+
+		log.Printf("Printing summaries by %v every %v", userInput.GroupBy, userInput.Interval)
+	}
+
+	if *tuiFlag {
+		if userInput.Interval > 0 {
+			log.Println("The interactive view cannot be combined with -interval or -summary-only")
+			os.Exit(1)
+		}
+
+		userInput.TUI = true
+	}
+
+	switch *outputFlag {
+	case "text":
+	case "json":
+		if userInput.Interval > 0 || userInput.TUI {
+			log.Println("JSON output cannot be combined with -interval, -summary-only or -tui")
+			os.Exit(1)
+		}
+	default:
+		log.Printf("Could not use %q as the output format, expected text or json", *outputFlag)
+		os.Exit(1)
+	}
+
+	userInput.Output = *outputFlag
+	userInput.JSONFile = *jsonFileFlag
+
+	if *processFlag {
+		userInput.Process = true
+
+		log.Println("Attributing flows to local processes")
 	}
 
 	return userInput
 }
 
-func main() {
-	userInput := getUserInput()
+// getOfflineInput gets and validates the flags of the analyze and replay
+// subcommands, which read packets from a file instead of an interface
+func getOfflineInput(subcommand string, args []string) types.UserInput {
+	flags := flag.NewFlagSet(subcommand, flag.ExitOnError)
+
+	var readFlag *string
+	var realtimeFlag *bool
+
+	switch subcommand {
+	case "analyze":
+		readFlag = flags.String("r", "", "pcap or pcapng file to read packets from")
+	case "replay":
+		realtimeFlag = flags.Bool("realtime", false, "Replay events at the pace they were recorded instead of as fast as possible")
+	}
+
+	ipFlag := flags.String("ip", "", "IP address to track (optional)")
+	portFlag := flags.Uint("port", 0, "Port number to track (optional)")
+	maxFlowsFlag := flags.Int("max-flows", flowtable.DefaultConfig().MaxEntries, "Maximum number of pending flows to track")
+	tcpTimeoutFlag := flags.Duration("tcp-timeout", flowtable.DefaultConfig().TCPTimeout, "How long to wait for a SYN/ACK")
+	udpTimeoutFlag := flags.Duration("udp-timeout", flowtable.DefaultConfig().UDPTimeout, "How long to wait for a UDP reply")
+	outputFlag := flags.String("output", "text", "Print every result as text or json (one object per line)")
+	statsFlag := flags.Bool("stats", false, "Print per destination latency statistics at the end (optional)")
+
+	flags.Parse(args)
+
+	var userInput types.UserInput
+
+	switch subcommand {
+	case "analyze":
+		if *readFlag == "" {
+			log.Println("Analyzing requires a capture file to read with -r")
+			os.Exit(1)
+		}
+
+		userInput.ReadFile = *readFlag
+	case "replay":
+		if flags.NArg() != 1 {
+			log.Println("Replaying requires a single recording, e.g. flat replay events.bin")
+			os.Exit(1)
+		}
+
+		userInput.ReadFile = flags.Arg(0)
+		userInput.Realtime = *realtimeFlag
+	}
+
+	if *maxFlowsFlag < 1 {
+		log.Printf("Could not use %d as the maximum number of flows", *maxFlowsFlag)
+		os.Exit(1)
+	}
+
+	if *tcpTimeoutFlag <= 0 || *udpTimeoutFlag <= 0 {
+		log.Printf("Could not use the TCP timeout %v and UDP timeout %v, both must be positive", *tcpTimeoutFlag, *udpTimeoutFlag)
+		flags.Usage()
+		os.Exit(1)
+	}
 
+	userInput.FlowTable = flowtable.DefaultConfig()
+	userInput.FlowTable.MaxEntries = *maxFlowsFlag
+	userInput.FlowTable.TCPTimeout = *tcpTimeoutFlag
+	userInput.FlowTable.UDPTimeout = *udpTimeoutFlag
+	userInput.FlowTable.PruneInterval = max(min(userInput.FlowTable.PruneInterval, *tcpTimeoutFlag, *udpTimeoutFlag), flowtable.MinPruneInterval)
+
+	if *ipFlag != "" {
+		ip, err := netip.ParseAddr(*ipFlag)
+
+		if err != nil {
+			log.Printf("Could not parse IP address %v: %v", *ipFlag, err)
+			os.Exit(1)
+		}
+
+		userInput.IPs = []netip.Addr{ip.Unmap()}
+	}
+
+	if *portFlag > 65535 {
+		log.Printf("Could not parse port %v", *portFlag)
+		os.Exit(1)
+	}
+
+	if *portFlag != 0 {
+		userInput.Ports = []uint16{uint16(*portFlag)}
+	}
+
+	if *outputFlag != "text" && *outputFlag != "json" {
+		log.Printf("Could not use %q as the output format, expected text or json", *outputFlag)
+		os.Exit(1)
+	}
+
+	userInput.Output = *outputFlag
+	userInput.Stats = *statsFlag
+
+	return userInput
+}
+
+// parsePrefix parses an IP prefix or a single IP address
+func parsePrefix(value string) (netip.Prefix, error) {
+	if strings.Contains(value, "/") {
+		prefix, err := netip.ParsePrefix(value)
+		return prefix.Masked(), err
+	}
+
+	ip, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+
+	return netip.PrefixFrom(ip, ip.BitLen()), nil
+}
+
+func main() {
 	ctx := context.Background()
 	ctx, cancel := context.WithCancel(ctx)
 
+	if len(os.Args) > 1 && os.Args[1] == "ctl" {
+		runCtl(os.Args[2:])
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "query" {
+		runQuery(os.Args[2:])
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "analyze" {
+		userInput := getOfflineInput(os.Args[1], os.Args[2:])
+
+		signalHandler(cancel)
+
+		if err := probe.Analyze(ctx, userInput); err != nil {
+			log.Fatalf("Failed analyzing %v: %v", userInput.ReadFile, err)
+		}
+
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		userInput := getOfflineInput(os.Args[1], os.Args[2:])
+
+		signalHandler(cancel)
+
+		if err := probe.Replay(ctx, userInput); err != nil {
+			log.Fatalf("Failed replaying %v: %v", userInput.ReadFile, err)
+		}
+
+		return
+	}
+
+	userInput := getUserInput()
+
 	signalHandler(cancel)
 
 	if err := probe.Run(ctx, userInput); err != nil {
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pouriyajamshidi/flat/internal/history"
+	"github.com/pouriyajamshidi/flat/internal/packet"
+)
+
+const queryUsage = `Usage: flat query -db file [flags]
+
+Prints the latency percentiles per destination kept in a -history file,
+over the last day unless -from, -to or -last say otherwise.
+
+Flags:
+`
+
+// queryRow is a row of flat query in JSON
+type queryRow struct {
+	Start       *time.Time       `json:"start,omitempty"`
+	Interface   string           `json:"interface"`
+	Protocol    string           `json:"protocol"`
+	Destination string           `json:"destination"`
+	Count       uint64           `json:"count"`
+	Timeouts    uint64           `json:"timeouts"`
+	Refused     uint64           `json:"refused"`
+	MinNs       int64            `json:"min_ns"`
+	MeanNs      int64            `json:"mean_ns"`
+	MaxNs       int64            `json:"max_ns"`
+	Percentiles map[string]int64 `json:"percentiles_ns"`
+}
+
+// parseQueryTime parses an RFC3339 time or a local date
+func parseQueryTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	t, err := time.ParseInLocation(time.DateOnly, value, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("could not parse %q as RFC3339 or YYYY-MM-DD", value)
+	}
+
+	return t, nil
+}
+
+// parsePercentiles parses a comma separated list of percentiles, e.g. 50,99.9
+func parsePercentiles(value string) ([]float64, error) {
+	var percentiles []float64
+
+	for field := range strings.SplitSeq(value, ",") {
+		p, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil || p <= 0 || p > 100 {
+			return nil, fmt.Errorf("could not parse percentile %q", field)
+		}
+
+		percentiles = append(percentiles, p)
+	}
+
+	return percentiles, nil
+}
+
+func percentileName(p float64) string {
+	return "p" + strconv.FormatFloat(p, 'f', -1, 64)
+}
+
+// runQuery prints the latency history kept by -history
+func runQuery(args []string) {
+	flags := flag.NewFlagSet("query", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), queryUsage)
+		flags.PrintDefaults()
+	}
+
+	dbFlag := flags.String("db", "", "SQLite history file written by -history")
+	fromFlag := flags.String("from", "", "Start of the range as RFC3339 or YYYY-MM-DD (optional)")
+	toFlag := flags.String("to", "", "End of the range as RFC3339 or YYYY-MM-DD, defaults to now (optional)")
+	lastFlag := flags.Duration("last", time.Hour*24, "Length of the range when -from is not set")
+	ipFlag := flags.String("ip", "", "Destination IP address or prefix to show, e.g. 1.1.1.0/24 (optional)")
+	portFlag := flags.Uint("port", 0, "Destination port to show (optional)")
+	protocolFlag := flags.String("protocol", "", "Protocol to show, tcp or udp (optional)")
+	ifaceFlag := flags.String("i", "", "Interface to show (optional)")
+	stepFlag := flags.Duration("step", 0, "Split the range into buckets of this length, e.g. 1h (optional)")
+	percentilesFlag := flags.String("percentiles", "50,90,99", "Comma separated percentiles to print")
+	outputFlag := flags.String("output", "table", "Print a table or json (one object per line)")
+
+	flags.Parse(args)
+
+	fail := func(format string, v ...any) {
+		log.Printf(format, v...)
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	if *dbFlag == "" {
+		fail("Could not query without a -db file")
+	}
+
+	if *outputFlag != "table" && *outputFlag != "json" {
+		fail("Could not use %q as the output, expected table or json", *outputFlag)
+	}
+
+	filter := history.Filter{To: time.Now(), Interface: *ifaceFlag, Step: *stepFlag}
+
+	if *toFlag != "" {
+		to, err := parseQueryTime(*toFlag)
+		if err != nil {
+			fail("Could not parse -to: %v", err)
+		}
+
+		filter.To = to
+	}
+
+	filter.From = filter.To.Add(-*lastFlag)
+
+	if *fromFlag != "" {
+		from, err := parseQueryTime(*fromFlag)
+		if err != nil {
+			fail("Could not parse -from: %v", err)
+		}
+
+		filter.From = from
+	}
+
+	if !filter.From.Before(filter.To) {
+		fail("Could not query the empty range from %v to %v", filter.From.Format(time.RFC3339), filter.To.Format(time.RFC3339))
+	}
+
+	if *stepFlag < 0 {
+		fail("Could not use the negative step %v", *stepFlag)
+	}
+
+	if *ipFlag != "" {
+		prefix, err := parsePrefix(*ipFlag)
+		if err != nil {
+			fail("Could not parse -ip: %v", err)
+		}
+
+		filter.Prefix = prefix
+	}
+
+	if *portFlag > 65535 {
+		fail("Could not parse port %v", *portFlag)
+	}
+
+	filter.Port = uint16(*portFlag)
+
+	switch strings.ToLower(*protocolFlag) {
+	case "":
+	case "tcp":
+		filter.Protocol = 6
+	case "udp":
+		filter.Protocol = 17
+	default:
+		fail("Could not use %q as the protocol, expected tcp or udp", *protocolFlag)
+	}
+
+	percentiles, err := parsePercentiles(*percentilesFlag)
+	if err != nil {
+		fail("Could not parse -percentiles: %v", err)
+	}
+
+	if _, err := os.Stat(*dbFlag); err != nil {
+		log.Printf("Failed opening the history file: %v", err)
+		os.Exit(1)
+	}
+
+	store, err := history.Open(*dbFlag)
+	if err != nil {
+		log.Printf("Failed opening the history file: %v", err)
+		os.Exit(1)
+	}
+	defer store.Close()
+
+	rows, err := store.Query(context.Background(), filter)
+	if err != nil {
+		log.Printf("Failed querying the history: %v", err)
+		os.Exit(1)
+	}
+
+	if *outputFlag == "json" {
+		printQueryJSON(rows, percentiles, *stepFlag > 0)
+		return
+	}
+
+	printQueryTable(rows, percentiles, *stepFlag > 0)
+}
+
+func printQueryJSON(rows []history.Row, percentiles []float64, stepped bool) {
+	encoder := json.NewEncoder(os.Stdout)
+
+	for _, row := range rows {
+		out := queryRow{
+			Interface:   row.Interface,
+			Protocol:    packet.ProtocolName(row.Destination.Protocol),
+			Destination: row.Destination.String(),
+			Count:       row.Sketch.Count(),
+			Timeouts:    row.Timeouts,
+			Refused:     row.Refused,
+			MinNs:       int64(row.Sketch.Min()),
+			MeanNs:      int64(row.Sketch.Mean()),
+			MaxNs:       int64(row.Sketch.Max()),
+			Percentiles: make(map[string]int64, len(percentiles)),
+		}
+
+		if stepped {
+			out.Start = &row.Start
+		}
+
+		for _, p := range percentiles {
+			out.Percentiles[percentileName(p)] = int64(row.Sketch.Quantile(p / 100))
+		}
+
+		encoder.Encode(out)
+	}
+}
+
+func printQueryTable(rows []history.Row, percentiles []float64, stepped bool) {
+	if len(rows) == 0 {
+		fmt.Println("No history in the range")
+		return
+	}
+
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	defer table.Flush()
+
+	if stepped {
+		fmt.Fprint(table, "start\t")
+	}
+
+	fmt.Fprint(table, "interface\tprotocol\tdestination\tcount\ttimeouts\trefused\tmin\tmean\t")
+
+	for _, p := range percentiles {
+		fmt.Fprintf(table, "%v\t", percentileName(p))
+	}
+
+	fmt.Fprintln(table, "max\t")
+
+	ms := func(ns float64) string {
+		return fmt.Sprintf("%.3fms", ns/float64(time.Millisecond))
+	}
+
+	for _, row := range rows {
+		if stepped {
+			fmt.Fprintf(table, "%v\t", row.Start.Local().Format(time.DateTime))
+		}
+
+		fmt.Fprintf(table, "%v\t%v\t%v\t%d\t%d\t%d\t",
+			row.Interface,
+			packet.ProtocolName(row.Destination.Protocol),
+			row.Destination,
+			row.Sketch.Count(),
+			row.Timeouts,
+			row.Refused,
+		)
+
+		if row.Sketch.Count() == 0 {
+			fmt.Fprint(table, "-\t-\t")
+
+			for range percentiles {
+				fmt.Fprint(table, "-\t")
+			}
+
+			fmt.Fprintln(table, "-\t")
+
+			continue
+		}
+
+		fmt.Fprintf(table, "%v\t%v\t", ms(row.Sketch.Min()), ms(row.Sketch.Mean()))
+
+		for _, p := range percentiles {
+			fmt.Fprintf(table, "%v\t", ms(row.Sketch.Quantile(p/100)))
+		}
+
+		fmt.Fprintf(table, "%v\t\n", ms(row.Sketch.Max()))
+	}
+}
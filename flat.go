@@ -0,0 +1,282 @@
+// Package flat measures the latency of TCP handshakes and UDP
+// request/response pairs with an eBPF program attached to an interface.
+//
+// Start attaches the program and streams a Measurement for every answered,
+// timed out or refused request until its context is cancelled:
+//
+//	events, err := flat.Start(ctx, flat.Options{Interface: "eth0"})
+//	if err != nil {
+//		return err
+//	}
+//
+//	for event := range events {
+//		if event.Err != nil {
+//			continue
+//		}
+//		fmt.Println(event.Server, event.Latency)
+//	}
+//
+// The package never prints, logs or exits. Attaching requires root or
+// CAP_BPF and CAP_NET_ADMIN
+package flat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/cilium/ebpf/ringbuf"
+	"github.com/pouriyajamshidi/flat/internal/flowtable"
+	"github.com/pouriyajamshidi/flat/internal/packet"
+	"github.com/pouriyajamshidi/flat/internal/reset"
+	"github.com/pouriyajamshidi/flat/internal/source"
+	"github.com/pouriyajamshidi/flat/internal/timer"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// DefaultBuffer is the default capacity of the event channel
+const DefaultBuffer = 1024
+
+// Options configures Start. Only Interface is required
+type Options struct {
+	// Interface is the name of the interface to attach to
+	Interface string
+	// IP and Port only keep the flows to or from them when set. When
+	// both are set, a flow matching either of them is kept
+	IP   netip.Addr
+	Port uint16
+	// MaxFlows is the maximum number of pending requests
+	MaxFlows int
+	// TCPTimeout is how long a SYN waits for its SYN/ACK
+	TCPTimeout time.Duration
+	// UDPTimeout is how long a UDP datagram waits for its reply
+	UDPTimeout time.Duration
+	// Buffer is the capacity of the event channel. Events are not dropped
+	// when it is full, the eBPF ring buffer fills up and drops them instead
+	Buffer int
+}
+
+// Result tells how a request ended
+type Result string
+
+const (
+	// Latency is a request that was answered
+	Latency Result = "latency"
+	// Timeout is a request that was not answered in time
+	Timeout Result = "timeout"
+	// Refused is a TCP SYN that was answered with a RST
+	Refused Result = "refused"
+)
+
+// Measurement is the outcome of one request
+type Measurement struct {
+	Result   Result
+	Protocol string // TCP or UDP
+	Client   netip.AddrPort
+	Server   netip.AddrPort
+	TTL      uint8         // of the reply, or of the request for timeouts and refusals
+	Latency  time.Duration // zero for timeouts and refusals
+	Time     time.Time     // when the reply, or the request for timeouts and refusals, was seen
+}
+
+// Event carries either a Measurement or an error
+// that occurred after Start returned
+type Event struct {
+	Measurement
+	Err error
+}
+
+// measurement describes the request packet, or the reply when latency is set
+func measurement(result Result, pkt packet.Packet, latency time.Duration, boot time.Time) Measurement {
+	request := pkt
+
+	if result == Latency {
+		request = pkt.Reverse()
+	}
+
+	return Measurement{
+		Result:   result,
+		Protocol: packet.ProtocolName(pkt.Protocol),
+		Client:   request.SrcAddrPort(),
+		Server:   request.DstAddrPort(),
+		TTL:      pkt.TTL,
+		Latency:  latency,
+		Time:     boot.Add(time.Duration(pkt.TimeStamp)),
+	}
+}
+
+// matches checks whether a packet is to or from the IP or the port of
+// the options, the same way the command line filters are applied
+func (opts Options) matches(pkt packet.Packet) bool {
+	if !opts.IP.IsValid() && opts.Port == 0 {
+		return true
+	}
+
+	if opts.IP.IsValid() && (opts.IP.Unmap() == pkt.SrcIP.Unmap() || opts.IP.Unmap() == pkt.DstIP.Unmap()) {
+		return true
+	}
+
+	return opts.Port != 0 && (opts.Port == pkt.SrcPort || opts.Port == pkt.DstPort)
+}
+
+// flowTableConfig applies the options to the default flow table configuration
+func (opts Options) flowTableConfig() flowtable.Config {
+	config := flowtable.DefaultConfig()
+
+	if opts.MaxFlows > 0 {
+		config.MaxEntries = opts.MaxFlows
+	}
+
+	if opts.TCPTimeout > 0 {
+		config.TCPTimeout = opts.TCPTimeout
+	}
+
+	if opts.UDPTimeout > 0 {
+		config.UDPTimeout = opts.UDPTimeout
+	}
+
+	config.PruneInterval = max(min(config.PruneInterval, config.TCPTimeout, config.UDPTimeout), flowtable.MinPruneInterval)
+
+	return config
+}
+
+// Start attaches the eBPF program to an interface and returns the channel
+// measurements are sent to. The program is detached and the channel
+// closed once ctx is cancelled or reading from the program fails
+func Start(ctx context.Context, opts Options) (<-chan Event, error) {
+	link, err := netlink.LinkByName(opts.Interface)
+	if err != nil {
+		return nil, fmt.Errorf("finding interface %q: %w", opts.Interface, err)
+	}
+
+	probe, err := source.Attach(link, log.New(io.Discard, "", 0))
+	if err != nil {
+		return nil, fmt.Errorf("attaching to %v: %w", opts.Interface, err)
+	}
+
+	buffer := opts.Buffer
+
+	if buffer <= 0 {
+		buffer = DefaultBuffer
+	}
+
+	events := make(chan Event, buffer)
+
+	go run(ctx, opts, link.Attrs().Index, probe, events)
+
+	return events, nil
+}
+
+func run(ctx context.Context, opts Options, ifindex int, probe *source.Source, events chan<- Event) {
+	defer close(events)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	send := func(event Event) {
+		select {
+		case events <- event:
+		case <-ctx.Done():
+		}
+	}
+
+	boot := timer.BootTime()
+	config := opts.flowTableConfig()
+	flowTable := flowtable.NewFlowTable[packet.Packet](config)
+
+	flowTable.OnExpire(func(_ flowtable.FlowKey, initiator packet.Packet) {
+		send(Event{Measurement: measurement(Timeout, initiator, 0, boot)})
+	})
+
+	samples := make(chan []byte)
+	refusals := make(chan [2]netip.AddrPort)
+
+	// Both goroutines are done before the events channel is closed
+	var wg sync.WaitGroup
+
+	wg.Go(func() {
+		for {
+			sample, err := probe.Read()
+			if errors.Is(err, ringbuf.ErrClosed) {
+				return
+			}
+
+			if err != nil {
+				send(Event{Err: fmt.Errorf("reading from ringbuf: %w", err)})
+				cancel()
+				return
+			}
+
+			select {
+			case samples <- sample:
+			case <-ctx.Done():
+				return
+			}
+		}
+	})
+
+	wg.Go(func() {
+		err := reset.Watch(ctx, ifindex, func(src, dst netip.AddrPort) {
+			select {
+			case refusals <- [2]netip.AddrPort{src, dst}:
+			case <-ctx.Done():
+			}
+		})
+		if err != nil {
+			send(Event{Err: fmt.Errorf("watching for refused connections: %w", err)})
+		}
+	})
+
+	ticker := time.NewTicker(config.PruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := probe.Close(); err != nil {
+				select {
+				case events <- Event{Err: fmt.Errorf("detaching from interface: %w", err)}:
+				default:
+				}
+			}
+
+			wg.Wait()
+			return
+
+		case <-ticker.C:
+			flowTable.Prune()
+
+		case sample := <-samples:
+			pkt, ok := packet.UnmarshalBinary(sample)
+			if !ok {
+				send(Event{Err: fmt.Errorf("malformed event of %d bytes", len(sample))})
+				continue
+			}
+
+			pkt.Interface = ifindex
+
+			if !opts.matches(pkt) {
+				continue
+			}
+
+			if result, ok := packet.CalcLatency(pkt, flowTable); ok {
+				send(Event{Measurement: measurement(Latency, pkt, result.Latency, boot)})
+			}
+
+		case refusal := <-refusals:
+			key := flowtable.NewFlowKey(refusal[0], refusal[1], unix.IPPROTO_TCP, ifindex)
+
+			// Only a RST answering a pending SYN is a refused connection
+			if initiator, ok := flowTable.Get(key); ok && initiator.Syn {
+				flowTable.Remove(key)
+				send(Event{Measurement: measurement(Refused, initiator, 0, boot)})
+			}
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package flat
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/pouriyajamshidi/flat/internal/packet"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	client = netip.MustParseAddrPort("192.168.0.156:53264")
+	server = netip.MustParseAddrPort("1.1.1.1:443")
+)
+
+func TestMeasurement(t *testing.T) {
+	boot := time.Unix(1700000000, 0)
+
+	reply := packet.Packet{
+		SrcIP:     netip.AddrFrom16(server.Addr().As16()),
+		DstIP:     netip.AddrFrom16(client.Addr().As16()),
+		SrcPort:   server.Port(),
+		DstPort:   client.Port(),
+		Protocol:  6,
+		TTL:       57,
+		Syn:       true,
+		Ack:       true,
+		TimeStamp: 5_012_000_000,
+	}
+
+	// The client and server of a reply are those of its request
+	m := measurement(Latency, reply, 12*time.Millisecond, boot)
+	require.Equal(t, Measurement{
+		Result:   Latency,
+		Protocol: "TCP",
+		Client:   client,
+		Server:   server,
+		TTL:      57,
+		Latency:  12 * time.Millisecond,
+		Time:     time.Unix(1700000005, 12_000_000),
+	}, m)
+
+	m = measurement(Timeout, reply.Reverse(), 0, boot)
+	require.Equal(t, client, m.Client)
+	require.Equal(t, server, m.Server)
+	require.Zero(t, m.Latency)
+}
+
+func TestMatches(t *testing.T) {
+	pkt := packet.Packet{
+		SrcIP:   netip.AddrFrom16(client.Addr().As16()),
+		DstIP:   netip.AddrFrom16(server.Addr().As16()),
+		SrcPort: client.Port(),
+		DstPort: server.Port(),
+	}
+
+	require.True(t, Options{}.matches(pkt))
+	require.True(t, Options{IP: server.Addr()}.matches(pkt))
+	require.True(t, Options{IP: server.Addr(), Port: 443}.matches(pkt))
+	require.False(t, Options{IP: netip.MustParseAddr("8.8.8.8")}.matches(pkt))
+	require.False(t, Options{Port: 80}.matches(pkt))
+
+	// Like on the command line, a flow matching either filter is kept
+	require.True(t, Options{IP: server.Addr(), Port: 80}.matches(pkt))
+	require.True(t, Options{IP: netip.MustParseAddr("8.8.8.8"), Port: 443}.matches(pkt))
+	require.False(t, Options{IP: netip.MustParseAddr("8.8.8.8"), Port: 80}.matches(pkt))
+}
+
+func TestFlowTableConfig(t *testing.T) {
+	config := Options{MaxFlows: 10, TCPTimeout: time.Second}.flowTableConfig()
+
+	require.Equal(t, 10, config.MaxEntries)
+	require.Equal(t, time.Second, config.TCPTimeout)
+	require.Equal(t, 10*time.Second, config.UDPTimeout)
+	require.Equal(t, time.Second, config.PruneInterval)
+}
+
+func TestStartUnknownInterface(t *testing.T) {
+	_, err := Start(context.Background(), Options{Interface: "does-not-exist0"})
+	require.Error(t, err)
+}
+
+func TestStartLoopback(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("attaching requires root")
+	}
+
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	listening := netip.MustParseAddrPort(listener.Addr().String())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events, err := Start(ctx, Options{Interface: "lo", Port: listening.Port()})
+	if errors.Is(err, os.ErrPermission) {
+		t.Skipf("attaching is not permitted: %v", err)
+	}
+	require.NoError(t, err)
+
+	conn, err := net.Dial("tcp4", listener.Addr().String())
+	require.NoError(t, err)
+	conn.Close()
+
+	select {
+	case event := <-events:
+		require.NoError(t, event.Err)
+		require.Equal(t, Latency, event.Result)
+		require.Equal(t, "TCP", event.Protocol)
+		require.Equal(t, listening, event.Server)
+		require.Positive(t, event.Latency)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no measurement of the handshake")
+	}
+
+	// The channel is closed once the program is detached
+	cancel()
+
+	for range events {
+	}
+}
@@ -0,0 +1,280 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/syslog"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/pouriyajamshidi/flat/internal/packet"
+)
+
+// flowPayload describes the flow that triggered an alert
+type flowPayload struct {
+	Protocol string `json:"protocol"`
+	SrcIP    string `json:"src_ip"`
+	SrcPort  uint16 `json:"src_port"`
+	DstIP    string `json:"dst_ip"`
+	DstPort  uint16 `json:"dst_port"`
+	DstHost  string `json:"dst_host,omitempty"`
+}
+
+// payload is the JSON body posted by the webhook action
+type payload struct {
+	Rule        string       `json:"rule"`
+	State       State        `json:"state"`
+	Statistic   string       `json:"statistic"`
+	ValueMs     float64      `json:"value_ms"`
+	ThresholdMs float64      `json:"threshold_ms"`
+	Time        time.Time    `json:"time"`
+	Flow        *flowPayload `json:"flow,omitempty"`
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func newPayload(event Event) payload {
+	body := payload{
+		Rule:        event.Rule.Name,
+		State:       event.State,
+		Statistic:   event.Rule.Statistic,
+		ValueMs:     millis(event.Value),
+		ThresholdMs: millis(event.Rule.Threshold),
+		Time:        event.Time,
+	}
+
+	if event.Triggered {
+		body.Flow = &flowPayload{
+			Protocol: packet.ProtocolName(event.Flow.Protocol),
+			SrcIP:    event.Flow.SrcIP.Unmap().String(),
+			SrcPort:  event.Flow.SrcPort,
+			DstIP:    event.Flow.DstIP.Unmap().String(),
+			DstPort:  event.Flow.DstPort,
+			DstHost:  event.Flow.DstHost,
+		}
+	}
+
+	return body
+}
+
+// Exec runs a command with the alert and flow details in FLAT_* environment variables
+type Exec struct {
+	Command []string
+}
+
+// Fire satisfies the Action interface
+func (action *Exec) Fire(ctx context.Context, event Event) error {
+	body := newPayload(event)
+
+	cmd := exec.CommandContext(ctx, action.Command[0], action.Command[1:]...)
+	cmd.Env = append(os.Environ(),
+		"FLAT_ALERT_RULE="+body.Rule,
+		"FLAT_ALERT_STATE="+string(body.State),
+		"FLAT_ALERT_STATISTIC="+body.Statistic,
+		"FLAT_ALERT_VALUE_MS="+strconv.FormatFloat(body.ValueMs, 'f', 3, 64),
+		"FLAT_ALERT_THRESHOLD_MS="+strconv.FormatFloat(body.ThresholdMs, 'f', 3, 64),
+	)
+
+	if body.Flow != nil {
+		cmd.Env = append(cmd.Env,
+			"FLAT_PROTOCOL="+body.Flow.Protocol,
+			"FLAT_SRC_IP="+body.Flow.SrcIP,
+			"FLAT_SRC_PORT="+strconv.Itoa(int(body.Flow.SrcPort)),
+			"FLAT_DST_IP="+body.Flow.DstIP,
+			"FLAT_DST_PORT="+strconv.Itoa(int(body.Flow.DstPort)),
+			"FLAT_DST_HOST="+body.Flow.DstHost,
+		)
+	}
+
+	// The output goes to the logger, which the interactive view silences,
+	// rather than over whatever is on the terminal
+	output, err := cmd.CombinedOutput()
+
+	if output = bytes.TrimSpace(output); len(output) > 0 {
+		log.Printf("Alert action %v: %s", action.Command[0], output)
+	}
+
+	return err
+}
+
+// Webhook posts the alert as JSON to a URL
+type Webhook struct {
+	URL    string
+	Client *http.Client
+}
+
+// Fire satisfies the Action interface
+func (action *Webhook) Fire(ctx context.Context, event Event) error {
+	body, err := json.Marshal(newPayload(event))
+
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, action.URL, bytes.NewReader(body))
+
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	client := action.Client
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %v returned %v", action.URL, resp.Status)
+	}
+
+	return nil
+}
+
+// Syslog writes the alert to the local syslog daemon
+type Syslog struct {
+	writer *syslog.Writer
+}
+
+// NewSyslog connects to the local syslog daemon
+func NewSyslog(tag string) (*Syslog, error) {
+	writer, err := syslog.New(syslog.LOG_WARNING|syslog.LOG_DAEMON, tag)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Syslog{writer: writer}, nil
+}
+
+// Fire satisfies the Action interface
+func (action *Syslog) Fire(_ context.Context, event Event) error {
+	body, err := json.Marshal(newPayload(event))
+
+	if err != nil {
+		return err
+	}
+
+	if event.State == Resolved {
+		return action.writer.Notice(string(body))
+	}
+
+	return action.writer.Warning(string(body))
+}
+
+// config is the layout of the alert configuration file
+type config struct {
+	Rules []struct {
+		Name  string `json:"name"`
+		Expr  string `json:"expr"`
+		Clear string `json:"clear"`
+	} `json:"rules"`
+	Actions []struct {
+		Type    string   `json:"type"`
+		Command []string `json:"command"`
+		URL     string   `json:"url"`
+		Tag     string   `json:"tag"`
+	} `json:"actions"`
+}
+
+// LoadConfig reads the rules and actions from a JSON file
+func LoadConfig(path string) ([]Rule, []Action, error) {
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var cfg config
+
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, nil, err
+	}
+
+	var rules []Rule
+
+	for i, ruleConfig := range cfg.Rules {
+		name := ruleConfig.Name
+
+		if name == "" {
+			name = fmt.Sprintf("rule-%d", i+1)
+		}
+
+		rule, err := ParseRule(name, ruleConfig.Expr)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if ruleConfig.Clear != "" && rule.Statistic == "anomaly" {
+			return nil, nil, fmt.Errorf("rule %q: anomaly rules take no clear", name)
+		}
+
+		if ruleConfig.Clear != "" {
+			rule.Clear, err = time.ParseDuration(ruleConfig.Clear)
+			if err != nil {
+				return nil, nil, fmt.Errorf("rule %q: %w", name, err)
+			}
+
+			if rule.Clear <= 0 || rule.Clear > rule.Threshold {
+				return nil, nil, fmt.Errorf("rule %q: clear %v must be positive and not above the threshold %v", name, rule.Clear, rule.Threshold)
+			}
+		}
+
+		rules = append(rules, rule)
+	}
+
+	var actions []Action
+
+	for _, actionConfig := range cfg.Actions {
+		switch actionConfig.Type {
+		case "exec":
+			if len(actionConfig.Command) == 0 {
+				return nil, nil, fmt.Errorf("exec action without a command")
+			}
+
+			actions = append(actions, &Exec{Command: actionConfig.Command})
+
+		case "webhook":
+			if actionConfig.URL == "" {
+				return nil, nil, fmt.Errorf("webhook action without a url")
+			}
+
+			actions = append(actions, &Webhook{URL: actionConfig.URL})
+
+		case "syslog":
+			tag := actionConfig.Tag
+
+			if tag == "" {
+				tag = "flat"
+			}
+
+			action, err := NewSyslog(tag)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			actions = append(actions, action)
+
+		default:
+			return nil, nil, fmt.Errorf("unknown action type %q", actionConfig.Type)
+		}
+	}
+
+	return rules, actions, nil
+}
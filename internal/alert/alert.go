@@ -0,0 +1,268 @@
+package alert
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pouriyajamshidi/flat/internal/baseline"
+	"github.com/pouriyajamshidi/flat/internal/packet"
+	"github.com/pouriyajamshidi/flat/internal/stats"
+)
+
+const (
+	evaluateInterval = time.Second
+	actionTimeout    = time.Second * 10
+	eventQueueSize   = 256
+)
+
+// State is the state of a rule carried by an Event
+type State string
+
+const (
+	// Firing means the rule's condition holds
+	Firing State = "firing"
+	// Resolved means a firing rule's condition no longer holds
+	Resolved State = "resolved"
+)
+
+// Event is emitted whenever a rule changes state. "any" and "anomaly"
+// rules fire on their first offending sample, which the Event carries
+type Event struct {
+	Rule      Rule
+	State     State
+	Value     time.Duration
+	Time      time.Time
+	Flow      packet.Packet // the offending flow or the latest one matching the rule
+	Triggered bool          // whether Flow is set
+}
+
+// Action is notified of alert events
+type Action interface {
+	Fire(ctx context.Context, event Event) error
+}
+
+type ruleState struct {
+	rule    Rule
+	buckets map[int64]*stats.Sketch // one sketch per second of the window
+	firing  bool
+	since   time.Time // when the pending state change started to hold
+	last    packet.Packet
+	matched bool
+}
+
+// Alerter evaluates latency samples against rules and runs actions on events
+type Alerter struct {
+	mu      sync.Mutex
+	rules   []*ruleState
+	actions []Action
+	events  chan Event
+}
+
+// New constructs a new Alerter
+func New(rules []Rule, actions []Action) *Alerter {
+	alerter := &Alerter{
+		actions: actions,
+		events:  make(chan Event, eventQueueSize),
+	}
+
+	for _, rule := range rules {
+		alerter.rules = append(alerter.rules, &ruleState{
+			rule:    rule,
+			buckets: make(map[int64]*stats.Sketch),
+		})
+	}
+
+	return alerter
+}
+
+func (alerter *Alerter) emit(event Event) {
+	select {
+	case alerter.events <- event:
+	default:
+		log.Printf("Dropping alert for rule %v, too many pending alerts", event.Rule.Name)
+	}
+}
+
+// Observe feeds a latency sample of a packet travelling from the client to the server
+func (alerter *Alerter) Observe(pkt packet.Packet, latency time.Duration, now time.Time) {
+	alerter.mu.Lock()
+	defer alerter.mu.Unlock()
+
+	for _, state := range alerter.rules {
+		if state.rule.Statistic == "anomaly" || !state.rule.Matches(pkt) {
+			continue
+		}
+
+		state.add(pkt, latency, now)
+
+		// Without a for duration, an "any" rule fires as soon as a sample
+		// offends, and resolves once none in its window is above clear
+		if state.rule.Statistic == "any" && state.rule.For == 0 && latency > state.rule.Threshold {
+			alerter.fire(state, pkt, latency, now)
+		}
+	}
+}
+
+// Anomaly feeds a latency sample of a packet travelling from the client to
+// the server that deviated from its baseline. An "anomaly" rule fires on the
+// first one, and resolves once its window has passed without another
+func (alerter *Alerter) Anomaly(pkt packet.Packet, anomaly baseline.Anomaly, now time.Time) {
+	alerter.mu.Lock()
+	defer alerter.mu.Unlock()
+
+	for _, state := range alerter.rules {
+		if state.rule.Statistic != "anomaly" || !state.rule.Matches(pkt) {
+			continue
+		}
+
+		state.add(pkt, anomaly.Latency, now)
+		alerter.fire(state, pkt, anomaly.Latency, now)
+	}
+}
+
+// add records a sample in the bucket of its second. Callers must hold the lock
+func (state *ruleState) add(pkt packet.Packet, latency time.Duration, now time.Time) {
+	second := now.Unix()
+
+	sketch, ok := state.buckets[second]
+
+	if !ok {
+		sketch = stats.NewSketch(stats.DefaultRelativeAccuracy)
+		state.buckets[second] = sketch
+	}
+
+	sketch.Add(float64(latency))
+
+	state.last = pkt
+	state.matched = true
+}
+
+// fire emits a Firing event for an offending sample unless
+// the rule is already firing. Callers must hold the lock
+func (alerter *Alerter) fire(state *ruleState, pkt packet.Packet, latency time.Duration, now time.Time) {
+	if state.firing {
+		return
+	}
+
+	state.firing = true
+	state.since = time.Time{}
+
+	alerter.emit(Event{Rule: state.rule, State: Firing, Value: latency, Time: now, Flow: pkt, Triggered: true})
+}
+
+// statistic computes the rule's statistic over its window and reports
+// whether there were any samples. Callers must hold the lock
+func (state *ruleState) statistic(now time.Time) (time.Duration, bool) {
+	oldest := now.Add(-state.rule.Window).Unix()
+
+	window := stats.NewSketch(stats.DefaultRelativeAccuracy)
+
+	for second, sketch := range state.buckets {
+		if second <= oldest {
+			delete(state.buckets, second)
+			continue
+		}
+
+		window.Merge(sketch)
+	}
+
+	if window.Count() == 0 {
+		return 0, false
+	}
+
+	switch state.rule.Statistic {
+	case "mean":
+		return time.Duration(window.Mean()), true
+	case "max", "any", "anomaly":
+		return time.Duration(window.Max()), true
+	default:
+		return time.Duration(window.Quantile(quantiles[state.rule.Statistic])), true
+	}
+}
+
+// Evaluate checks every rule and emits events on state changes
+func (alerter *Alerter) Evaluate(now time.Time) {
+	alerter.mu.Lock()
+	defer alerter.mu.Unlock()
+
+	for _, state := range alerter.rules {
+		value, ok := state.statistic(now)
+
+		var changing bool
+
+		if state.firing {
+			changing = !ok || value < state.rule.Clear
+		} else {
+			changing = ok && value > state.rule.Threshold
+		}
+
+		if !changing {
+			state.since = time.Time{}
+			continue
+		}
+
+		if state.since.IsZero() {
+			state.since = now
+		}
+
+		if now.Sub(state.since) < state.rule.For {
+			continue
+		}
+
+		state.firing = !state.firing
+		state.since = time.Time{}
+
+		event := Event{Rule: state.rule, State: Resolved, Value: value, Time: now, Flow: state.last, Triggered: state.matched}
+
+		if state.firing {
+			event.State = Firing
+		}
+
+		alerter.emit(event)
+	}
+}
+
+// Run evaluates the rules every second and runs the actions of
+// emitted events until ctx is cancelled
+func (alerter *Alerter) Run(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(evaluateInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				alerter.Evaluate(now)
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-alerter.events:
+			log.Printf("Alert %v is %v: %v %.3f ms (threshold %.3f ms)",
+				event.Rule.Name,
+				event.State,
+				event.Rule.Statistic,
+				float64(event.Value)/float64(time.Millisecond),
+				float64(event.Rule.Threshold)/float64(time.Millisecond),
+			)
+
+			for _, action := range alerter.actions {
+				actionCtx, cancel := context.WithTimeout(ctx, actionTimeout)
+
+				if err := action.Fire(actionCtx, event); err != nil {
+					log.Printf("Failed running alert action for rule %v: %v", event.Rule.Name, err)
+				}
+
+				cancel()
+			}
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pouriyajamshidi/flat/internal/baseline"
+	"github.com/pouriyajamshidi/flat/internal/packet"
+	"github.com/stretchr/testify/require"
+)
+
+var request = packet.Packet{
+	SrcIP:    netip.MustParseAddr("::ffff:192.168.0.156"),
+	DstIP:    netip.MustParseAddr("::ffff:10.1.2.3"),
+	SrcPort:  53264,
+	DstPort:  5432,
+	Protocol: 6,
+}
+
+func TestParseRule(t *testing.T) {
+	rule, err := ParseRule("db", "p95 to 10.0.0.0/8:5432 > 20ms for 30s")
+	require.NoError(t, err)
+	require.Equal(t, "p95", rule.Statistic)
+	require.Equal(t, netip.MustParsePrefix("10.0.0.0/8"), rule.Prefix)
+	require.Equal(t, uint16(5432), rule.Port)
+	require.Equal(t, 20*time.Millisecond, rule.Threshold)
+	require.Equal(t, 18*time.Millisecond, rule.Clear)
+	require.Equal(t, 30*time.Second, rule.For)
+	require.Equal(t, 30*time.Second, rule.Window)
+	require.True(t, rule.Matches(request))
+
+	rule, err = ParseRule("slow", "any > 500ms")
+	require.NoError(t, err)
+	require.Equal(t, "any", rule.Statistic)
+	require.False(t, rule.Prefix.IsValid())
+	require.Equal(t, defaultWindow, rule.Window)
+
+	rule, err = ParseRule("v6", "max to [2001:db8::/32]:443 > 1s")
+	require.NoError(t, err)
+	require.Equal(t, netip.MustParsePrefix("2001:db8::/32"), rule.Prefix)
+	require.Equal(t, uint16(443), rule.Port)
+	require.False(t, rule.Matches(request))
+
+	rule, err = ParseRule("host", "mean to 1.1.1.1 > 1s")
+	require.NoError(t, err)
+	require.Equal(t, netip.MustParsePrefix("1.1.1.1/32"), rule.Prefix)
+
+	for _, expr := range []string{"p42 > 1s", "p95 > fast", "p95 to 10.0.0.0/8", "p95 > 1s during 5s", "p95 < 1s", "p99 > -5ms", "any > 0s", "max > 1s for -1s"} {
+		_, err := ParseRule("bad", expr)
+		require.Error(t, err, expr)
+	}
+}
+
+func drain(alerter *Alerter) []Event {
+	var events []Event
+
+	for {
+		select {
+		case event := <-alerter.events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	rule, err := ParseRule("db", "p95 to 10.0.0.0/8 > 20ms for 3s")
+	require.NoError(t, err)
+
+	alerter := New([]Rule{rule}, nil)
+	start := time.Unix(1700000000, 0)
+
+	// The condition has to hold for 3 seconds before firing
+	for i := range 4 {
+		now := start.Add(time.Duration(i) * time.Second)
+		alerter.Observe(request, 30*time.Millisecond, now)
+		alerter.Evaluate(now)
+
+		if i < 3 {
+			require.Empty(t, drain(alerter), i)
+		}
+	}
+
+	events := drain(alerter)
+	require.Len(t, events, 1)
+	require.Equal(t, Firing, events[0].State)
+	require.True(t, events[0].Triggered)
+	require.InDelta(t, 30*time.Millisecond, events[0].Value, float64(time.Millisecond))
+
+	// Once the slow samples leave the window, the value falls below the
+	// clear threshold and the rule resolves after another 3 seconds
+	later := start.Add(time.Minute)
+
+	for i := range 4 {
+		now := later.Add(time.Duration(i) * time.Second)
+		alerter.Observe(request, 5*time.Millisecond, now)
+		alerter.Evaluate(now)
+	}
+
+	events = drain(alerter)
+	require.Len(t, events, 1)
+	require.Equal(t, Resolved, events[0].State)
+
+	// Samples to other destinations are ignored
+	other := request
+	other.DstIP = netip.MustParseAddr("1.1.1.1")
+
+	for i := range 10 {
+		now := later.Add(time.Duration(10+i) * time.Second)
+		alerter.Observe(other, time.Second, now)
+		alerter.Evaluate(now)
+	}
+
+	require.Empty(t, drain(alerter))
+}
+
+func TestEvaluateHysteresis(t *testing.T) {
+	rule, err := ParseRule("db", "max > 20ms")
+	require.NoError(t, err)
+
+	alerter := New([]Rule{rule}, nil)
+	now := time.Unix(1700000000, 0)
+
+	alerter.Observe(request, 25*time.Millisecond, now)
+	alerter.Evaluate(now)
+	require.Len(t, drain(alerter), 1)
+
+	// Between the clear and firing thresholds the rule keeps firing
+	now = now.Add(time.Minute)
+	alerter.Observe(request, 19*time.Millisecond, now)
+	alerter.Evaluate(now)
+	require.Empty(t, drain(alerter))
+
+	now = now.Add(time.Minute)
+	alerter.Observe(request, 10*time.Millisecond, now)
+	alerter.Evaluate(now)
+
+	events := drain(alerter)
+	require.Len(t, events, 1)
+	require.Equal(t, Resolved, events[0].State)
+}
+
+func TestObserveAny(t *testing.T) {
+	rule, err := ParseRule("slow", "any > 500ms")
+	require.NoError(t, err)
+
+	alerter := New([]Rule{rule}, nil)
+	now := time.Unix(1700000000, 0)
+
+	alerter.Observe(request, 100*time.Millisecond, now)
+	alerter.Observe(request, 600*time.Millisecond, now)
+	alerter.Evaluate(now)
+
+	events := drain(alerter)
+	require.Len(t, events, 1)
+	require.Equal(t, Firing, events[0].State)
+	require.Equal(t, 600*time.Millisecond, events[0].Value)
+	require.Equal(t, request, events[0].Flow)
+
+	// Further offending samples do not fire again while the rule is firing
+	for i := range 5 {
+		now = now.Add(time.Second)
+		alerter.Observe(request, time.Duration(700+i)*time.Millisecond, now)
+		alerter.Evaluate(now)
+	}
+
+	require.Empty(t, drain(alerter))
+
+	// Once no sample in the window is above clear, the rule resolves
+	now = now.Add(time.Minute)
+	alerter.Observe(request, 100*time.Millisecond, now)
+	alerter.Evaluate(now)
+
+	events = drain(alerter)
+	require.Len(t, events, 1)
+	require.Equal(t, Resolved, events[0].State)
+
+	// And fires again on the next offending sample
+	alerter.Observe(request, 600*time.Millisecond, now)
+	require.Len(t, drain(alerter), 1)
+}
+
+func TestAnomaly(t *testing.T) {
+	rule, err := ParseRule("db", "anomaly to 10.0.0.0/8:5432")
+	require.NoError(t, err)
+	require.Equal(t, "anomaly", rule.Statistic)
+	require.Equal(t, uint16(5432), rule.Port)
+
+	alerter := New([]Rule{rule}, nil)
+	now := time.Unix(1700000000, 0)
+
+	// Plain samples never fire an anomaly rule
+	alerter.Observe(request, time.Hour, now)
+	alerter.Evaluate(now)
+	require.Empty(t, drain(alerter))
+
+	alerter.Anomaly(request, baseline.Anomaly{Latency: 90 * time.Millisecond, Score: 12}, now)
+	alerter.Anomaly(request, baseline.Anomaly{Latency: 95 * time.Millisecond, Score: 13}, now)
+	alerter.Evaluate(now)
+
+	events := drain(alerter)
+	require.Len(t, events, 1)
+	require.Equal(t, Firing, events[0].State)
+	require.Equal(t, 90*time.Millisecond, events[0].Value)
+	require.Equal(t, request, events[0].Flow)
+
+	// Once the window passes without another anomaly, the rule resolves
+	now = now.Add(defaultWindow + time.Second)
+	alerter.Evaluate(now)
+
+	events = drain(alerter)
+	require.Len(t, events, 1)
+	require.Equal(t, Resolved, events[0].State)
+
+	_, err = ParseRule("bad", "anomaly > 1s")
+	require.Error(t, err)
+}
+
+func TestWebhook(t *testing.T) {
+	received := make(chan payload, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPost, r.Method)
+		require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+
+		var body payload
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		received <- body
+	}))
+	defer server.Close()
+
+	rule, err := ParseRule("db", "p95 to 10.0.0.0/8:5432 > 20ms")
+	require.NoError(t, err)
+
+	action := &Webhook{URL: server.URL}
+	event := Event{Rule: rule, State: Firing, Value: 25 * time.Millisecond, Time: time.Unix(1700000000, 0), Flow: request, Triggered: true}
+	require.NoError(t, action.Fire(context.Background(), event))
+
+	body := <-received
+	require.Equal(t, "db", body.Rule)
+	require.Equal(t, Firing, body.State)
+	require.Equal(t, "p95", body.Statistic)
+	require.Equal(t, 25.0, body.ValueMs)
+	require.Equal(t, 20.0, body.ThresholdMs)
+	require.Equal(t, "10.1.2.3", body.Flow.DstIP)
+	require.Equal(t, uint16(5432), body.Flow.DstPort)
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	require.Error(t, (&Webhook{URL: failing.URL}).Fire(context.Background(), event))
+}
+
+func TestExec(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "env")
+
+	rule, err := ParseRule("slow", "any > 500ms")
+	require.NoError(t, err)
+
+	action := &Exec{Command: []string{"sh", "-c", "env > " + output}}
+	event := Event{Rule: rule, State: Firing, Value: 600 * time.Millisecond, Flow: request, Triggered: true}
+	require.NoError(t, action.Fire(context.Background(), event))
+
+	data, err := os.ReadFile(output)
+	require.NoError(t, err)
+
+	env := strings.Split(string(data), "\n")
+	require.Contains(t, env, "FLAT_ALERT_RULE=slow")
+	require.Contains(t, env, "FLAT_ALERT_STATE=firing")
+	require.Contains(t, env, "FLAT_ALERT_VALUE_MS=600.000")
+	require.Contains(t, env, "FLAT_PROTOCOL=TCP")
+	require.Contains(t, env, "FLAT_SRC_IP=192.168.0.156")
+	require.Contains(t, env, "FLAT_DST_PORT=5432")
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "alerts.json")
+
+	config := `{
+		"rules": [
+			{"name": "db", "expr": "p95 to 10.0.0.0/8:5432 > 20ms for 30s", "clear": "15ms"},
+			{"expr": "any > 500ms"}
+		],
+		"actions": [
+			{"type": "exec", "command": ["logger", "flat"]},
+			{"type": "webhook", "url": "http://127.0.0.1:9000/alerts"}
+		]
+	}`
+	require.NoError(t, os.WriteFile(path, []byte(config), 0o644))
+
+	rules, actions, err := LoadConfig(path)
+	require.NoError(t, err)
+	require.Len(t, rules, 2)
+	require.Equal(t, 15*time.Millisecond, rules[0].Clear)
+	require.Equal(t, "rule-2", rules[1].Name)
+	require.Len(t, actions, 2)
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"actions": [{"type": "pager"}]}`), 0o644))
+
+	_, _, err = LoadConfig(path)
+	require.Error(t, err)
+
+	// A clear threshold above the firing one would never resolve
+	require.NoError(t, os.WriteFile(path, []byte(`{"rules": [{"expr": "max > 20ms", "clear": "30ms"}]}`), 0o644))
+
+	_, _, err = LoadConfig(path)
+	require.Error(t, err)
+}
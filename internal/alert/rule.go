@@ -0,0 +1,209 @@
+package alert
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pouriyajamshidi/flat/internal/packet"
+)
+
+// defaultWindow is the span of samples aggregate statistics are computed over
+const defaultWindow = time.Second * 10
+
+// defaultClearRatio places the resolve threshold below the firing one
+// so that a value hovering around the threshold does not flap
+const defaultClearRatio = 0.9
+
+// quantiles maps statistic names to the quantile they compute
+var quantiles = map[string]float64{
+	"p50":  0.50,
+	"p90":  0.90,
+	"p95":  0.95,
+	"p99":  0.99,
+	"p999": 0.999,
+}
+
+// Rule describes a latency condition such as
+// "p95 to 10.0.0.0/8:5432 > 20ms for 30s", "any > 500ms" or "anomaly to 10.0.0.5"
+type Rule struct {
+	Name string
+	// Statistic is any (every single sample), mean, max, a percentile like p95
+	// or anomaly (every sample flagged against its destination's baseline)
+	Statistic string
+	// Prefix restricts the rule to destinations within it, if valid
+	Prefix netip.Prefix
+	// Port restricts the rule to a destination port, if not zero
+	Port uint16
+	// Threshold is the latency above which the rule fires
+	Threshold time.Duration
+	// Clear is the latency below which a firing rule resolves
+	Clear time.Duration
+	// For is how long the condition has to hold before changing state
+	For time.Duration
+	// Window is the span of samples aggregate statistics are computed over
+	Window time.Duration
+}
+
+// ParseRule parses an expression of the form
+// <statistic> [to <prefix>[:port]] > <threshold> [for <duration>],
+// or anomaly [to <prefix>[:port]] which takes no threshold
+func ParseRule(name, expr string) (Rule, error) {
+	fields := strings.Fields(expr)
+
+	if len(fields) > 0 && strings.ToLower(fields[0]) == "anomaly" {
+		return parseAnomalyRule(name, fields[1:])
+	}
+
+	if len(fields) < 3 {
+		return Rule{}, fmt.Errorf("rule %q: expected \"<statistic> [to <destination>] > <threshold> [for <duration>]\"", name)
+	}
+
+	rule := Rule{Name: name, Statistic: strings.ToLower(fields[0]), Window: defaultWindow}
+
+	if _, ok := quantiles[rule.Statistic]; !ok && rule.Statistic != "any" && rule.Statistic != "mean" && rule.Statistic != "max" {
+		return Rule{}, fmt.Errorf("rule %q: unknown statistic %q", name, fields[0])
+	}
+
+	fields = fields[1:]
+
+	if fields[0] == "to" {
+		if len(fields) < 2 {
+			return Rule{}, fmt.Errorf("rule %q: missing destination", name)
+		}
+
+		prefix, port, err := parseDestination(fields[1])
+		if err != nil {
+			return Rule{}, fmt.Errorf("rule %q: %w", name, err)
+		}
+
+		rule.Prefix = prefix
+		rule.Port = port
+		fields = fields[2:]
+	}
+
+	if len(fields) < 2 || fields[0] != ">" {
+		return Rule{}, fmt.Errorf("rule %q: expected \"> <threshold>\"", name)
+	}
+
+	threshold, err := time.ParseDuration(fields[1])
+	if err != nil {
+		return Rule{}, fmt.Errorf("rule %q: %w", name, err)
+	}
+
+	// Every result would be above a threshold that is not positive
+	if threshold <= 0 {
+		return Rule{}, fmt.Errorf("rule %q: threshold %v must be positive", name, threshold)
+	}
+
+	rule.Threshold = threshold
+	rule.Clear = time.Duration(float64(threshold) * defaultClearRatio)
+	fields = fields[2:]
+
+	if len(fields) > 0 {
+		if len(fields) != 2 || fields[0] != "for" {
+			return Rule{}, fmt.Errorf("rule %q: expected \"for <duration>\"", name)
+		}
+
+		rule.For, err = time.ParseDuration(fields[1])
+		if err != nil {
+			return Rule{}, fmt.Errorf("rule %q: %w", name, err)
+		}
+
+		if rule.For < 0 {
+			return Rule{}, fmt.Errorf("rule %q: for %v must not be negative", name, rule.For)
+		}
+
+		rule.Window = max(rule.For, rule.Window)
+	}
+
+	return rule, nil
+}
+
+// parseAnomalyRule parses the fields that follow "anomaly"
+func parseAnomalyRule(name string, fields []string) (Rule, error) {
+	rule := Rule{Name: name, Statistic: "anomaly", Window: defaultWindow}
+
+	if len(fields) == 0 {
+		return rule, nil
+	}
+
+	if len(fields) != 2 || fields[0] != "to" {
+		return Rule{}, fmt.Errorf("rule %q: expected \"anomaly [to <destination>]\"", name)
+	}
+
+	prefix, port, err := parseDestination(fields[1])
+	if err != nil {
+		return Rule{}, fmt.Errorf("rule %q: %w", name, err)
+	}
+
+	rule.Prefix = prefix
+	rule.Port = port
+
+	return rule, nil
+}
+
+// parseDestination parses an IP address or prefix with an optional port,
+// e.g. 10.0.0.0/8:5432, 1.1.1.1, [2001:db8::/32]:443 or 2001:db8::/32
+func parseDestination(value string) (netip.Prefix, uint16, error) {
+	var port uint16
+
+	addr := value
+
+	if strings.HasPrefix(value, "[") {
+		end := strings.Index(value, "]")
+
+		if end < 0 {
+			return netip.Prefix{}, 0, fmt.Errorf("invalid destination %q", value)
+		}
+
+		addr = value[1:end]
+
+		if rest := value[end+1:]; rest != "" {
+			parsed, err := strconv.ParseUint(strings.TrimPrefix(rest, ":"), 10, 16)
+			if err != nil || !strings.HasPrefix(rest, ":") {
+				return netip.Prefix{}, 0, fmt.Errorf("invalid port in %q", value)
+			}
+
+			port = uint16(parsed)
+		}
+	} else if strings.Count(value, ":") == 1 {
+		host, portValue, _ := strings.Cut(value, ":")
+
+		parsed, err := strconv.ParseUint(portValue, 10, 16)
+		if err != nil {
+			return netip.Prefix{}, 0, fmt.Errorf("invalid port in %q", value)
+		}
+
+		addr = host
+		port = uint16(parsed)
+	}
+
+	if !strings.Contains(addr, "/") {
+		ip, err := netip.ParseAddr(addr)
+		if err != nil {
+			return netip.Prefix{}, 0, err
+		}
+
+		return netip.PrefixFrom(ip, ip.BitLen()), port, nil
+	}
+
+	prefix, err := netip.ParsePrefix(addr)
+	if err != nil {
+		return netip.Prefix{}, 0, err
+	}
+
+	return prefix.Masked(), port, nil
+}
+
+// Matches reports whether a packet travelling from the client
+// to the server is covered by the rule
+func (rule *Rule) Matches(pkt packet.Packet) bool {
+	if rule.Prefix.IsValid() && !rule.Prefix.Contains(pkt.DstIP.Unmap()) {
+		return false
+	}
+
+	return rule.Port == 0 || rule.Port == pkt.DstPort
+}
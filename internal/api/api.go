@@ -0,0 +1,394 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pouriyajamshidi/flat/internal/baseline"
+	"github.com/pouriyajamshidi/flat/internal/flowtable"
+	"github.com/pouriyajamshidi/flat/internal/output"
+	"github.com/pouriyajamshidi/flat/internal/packet"
+	"github.com/pouriyajamshidi/flat/internal/pending"
+	"github.com/pouriyajamshidi/flat/internal/stats"
+	"github.com/pouriyajamshidi/flat/internal/timer"
+	"golang.org/x/sys/unix"
+)
+
+// unixPrefix marks an address as the path of a unix socket
+const unixPrefix = "unix:"
+
+// subscriberBuffer is how many events a client of /events may fall behind by
+// before the events that follow are dropped for it
+const subscriberBuffer = 256
+
+// keepAlive is how often an idle /events stream is sent a comment
+// so that proxies do not time it out
+const keepAlive = time.Second * 15
+
+// Config holds the API address and credentials
+type Config struct {
+	// Addr is host:port, or unix:/path/to/socket to listen on a unix socket
+	Addr string
+	// Token is the bearer token every request but /healthz must carry.
+	// No token is required when it is empty
+	Token string
+}
+
+// Destination is the latency summary of a destination in the /stats response
+type Destination struct {
+	Destination string `json:"destination"`
+	Protocol    string `json:"protocol"`
+	Count       uint64 `json:"count"`
+	MinNs       int64  `json:"min_ns"`
+	MeanNs      int64  `json:"mean_ns"`
+	StdDevNs    int64  `json:"stddev_ns"`
+	P50Ns       int64  `json:"p50_ns"`
+	P90Ns       int64  `json:"p90_ns"`
+	P99Ns       int64  `json:"p99_ns"`
+	P999Ns      int64  `json:"p999_ns"`
+	MaxNs       int64  `json:"max_ns"`
+}
+
+// FlowTable holds the flow table counters in the /stats response
+type FlowTable struct {
+	Entries     int    `json:"entries"`
+	Inserts     uint64 `json:"inserts"`
+	Hits        uint64 `json:"hits"`
+	Misses      uint64 `json:"misses"`
+	Evictions   uint64 `json:"evictions"`
+	Expirations uint64 `json:"expirations"`
+}
+
+// Stats is the /stats response
+type Stats struct {
+	FlowTable    FlowTable     `json:"flow_table"`
+	Destinations []Destination `json:"destinations"`
+	// Recent holds the destinations seen over the last WindowNs
+	WindowNs int64         `json:"window_ns"`
+	Recent   []Destination `json:"recent"`
+}
+
+type subscriber struct {
+	filter  filter
+	events  chan output.Record
+	dropped uint64
+}
+
+// API serves the pending flows, the per destination statistics and a live
+// stream of results over HTTP. It is handed the results as an output.Sink
+type API struct {
+	config     Config
+	flowTable  *flowtable.FlowTable[packet.Packet]
+	statistics *stats.Stats[stats.Destination]
+	records    *output.Records
+	now        func() uint64
+
+	mu          sync.Mutex
+	subscribers map[*subscriber]bool
+}
+
+// New constructs a new API serving a flow table and the statistics
+// of its destinations. Records builds the results it streams
+func New(config Config, flowTable *flowtable.FlowTable[packet.Packet], statistics *stats.Stats[stats.Destination], records *output.Records) *API {
+	return &API{
+		config:      config,
+		flowTable:   flowTable,
+		statistics:  statistics,
+		records:     records,
+		now:         timer.GetNanosecSinceBoot,
+		subscribers: make(map[*subscriber]bool),
+	}
+}
+
+// publish hands a record to every subscriber whose filter it matches,
+// dropping it for the subscribers that are falling behind
+func (a *API) publish(record output.Record) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for sub := range a.subscribers {
+		if !sub.filter.matches(record) {
+			continue
+		}
+
+		select {
+		case sub.events <- record:
+		default:
+			sub.dropped++
+		}
+	}
+}
+
+// Latency satisfies the output.Sink interface
+func (a *API) Latency(result packet.Result) {
+	a.publish(a.records.Latency(result))
+}
+
+// Timeout satisfies the output.Sink interface
+func (a *API) Timeout(request packet.Packet) {
+	a.publish(a.records.Request(output.Timeout, request))
+}
+
+// Refused satisfies the output.Sink interface
+func (a *API) Refused(request packet.Packet) {
+	a.publish(a.records.Request(output.Refused, request))
+}
+
+// Anomaly satisfies the output.Sink interface
+func (a *API) Anomaly(result packet.Result, anomaly baseline.Anomaly) {
+	a.publish(a.records.Anomaly(result, anomaly))
+}
+
+func (a *API) subscribe(f filter) *subscriber {
+	sub := &subscriber{filter: f, events: make(chan output.Record, subscriberBuffer)}
+
+	a.mu.Lock()
+	a.subscribers[sub] = true
+	a.mu.Unlock()
+
+	return sub
+}
+
+func (a *API) unsubscribe(sub *subscriber) {
+	a.mu.Lock()
+	delete(a.subscribers, sub)
+	a.mu.Unlock()
+
+	if sub.dropped > 0 {
+		log.Printf("Dropped %d events an API client could not keep up with", sub.dropped)
+	}
+}
+
+// Handler serves the API routes
+func (a *API) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", a.healthz)
+	mux.Handle("GET /flows", a.authorize(http.HandlerFunc(a.flows)))
+	mux.Handle("GET /stats", a.authorize(http.HandlerFunc(a.stats)))
+	mux.Handle("GET /events", a.authorize(http.HandlerFunc(a.events)))
+
+	return mux
+}
+
+// authorize rejects requests without the bearer token, if one is configured
+func (a *API) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.config.Token != "" {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+			if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(a.config.Token)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed writing API response: %v", err)
+	}
+}
+
+func (a *API) healthz(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintln(w, "ok")
+}
+
+func (a *API) flows(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, pending.Flows(a.flowTable, a.now()))
+}
+
+// stats summarises the latencies of every destination, busiest first
+func (a *API) stats(w http.ResponseWriter, _ *http.Request) {
+	counters := a.flowTable.Stats()
+
+	response := Stats{
+		FlowTable: FlowTable{
+			Entries:     counters.Entries,
+			Inserts:     counters.Inserts,
+			Hits:        counters.Hits,
+			Misses:      counters.Misses,
+			Evictions:   counters.Evictions,
+			Expirations: counters.Expirations,
+		},
+		Destinations: destinations(a.statistics.Entries()),
+		WindowNs:     int64(a.statistics.Window()),
+		Recent:       destinations(a.statistics.Recent()),
+	}
+
+	writeJSON(w, response)
+}
+
+func destinations(entries []stats.Entry[stats.Destination]) []Destination {
+	result := make([]Destination, 0, len(entries))
+
+	for _, entry := range entries {
+		summary := entry.Summary
+
+		result = append(result, Destination{
+			Destination: entry.Key.String(),
+			Protocol:    packet.ProtocolName(entry.Key.Protocol),
+			Count:       summary.Count,
+			MinNs:       int64(summary.Min),
+			MeanNs:      int64(summary.Mean),
+			StdDevNs:    int64(summary.StdDev),
+			P50Ns:       int64(summary.P50),
+			P90Ns:       int64(summary.P90),
+			P99Ns:       int64(summary.P99),
+			P999Ns:      int64(summary.P999),
+			MaxNs:       int64(summary.Max),
+		})
+	}
+
+	return result
+}
+
+// events streams the results matching the query string as server-sent events
+func (a *API) events(w http.ResponseWriter, r *http.Request) {
+	f, err := parseFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	sub := a.subscribe(f)
+	defer a.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ticker := time.NewTicker(keepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+
+		case record := <-sub.events:
+			data, err := json.Marshal(record)
+			if err != nil {
+				log.Printf("Failed encoding API event: %v", err)
+				continue
+			}
+
+			if _, err := fmt.Fprintf(w, "event: %v\ndata: %s\n\n", record.Type, data); err != nil {
+				return
+			}
+		}
+
+		flusher.Flush()
+	}
+}
+
+// listen listens on a TCP address, or on a unix socket that only
+// its owner may connect to
+func listen(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, unixPrefix)
+
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+
+	// Replace the socket left behind by a previous run, but
+	// not the one of an instance that is still running
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		conn, err := net.DialTimeout("unix", path, time.Second)
+		if err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%v is in use by another instance", path)
+		}
+
+		if !errors.Is(err, unix.ECONNREFUSED) {
+			return nil, err
+		}
+
+		os.Remove(path)
+	}
+
+	// The socket is created accessible to its owner only, instead of
+	// being restricted once it already accepts connections
+	umask := unix.Umask(0o177)
+	listener, err := net.Listen("unix", path)
+	unix.Umask(umask)
+
+	return listener, err
+}
+
+// isLoopback tells whether a TCP address only accepts local connections
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+
+	if host == "localhost" {
+		return true
+	}
+
+	ip, err := netip.ParseAddr(host)
+
+	return err == nil && ip.IsLoopback()
+}
+
+// Listen binds the configured address, so that an address
+// in use fails before the API is served
+func (a *API) Listen() (net.Listener, error) {
+	return listen(a.config.Addr)
+}
+
+// Serve serves the API on a listener returned by Listen until ctx is cancelled
+func (a *API) Serve(ctx context.Context, listener net.Listener) error {
+	server := &http.Server{Handler: a.Handler(), ReadHeaderTimeout: time.Second * 10}
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	if strings.HasPrefix(a.config.Addr, unixPrefix) {
+		log.Printf("Serving the API on %v", a.config.Addr)
+	} else {
+		log.Printf("Serving the API on http://%v", listener.Addr())
+
+		if a.config.Token == "" && !isLoopback(a.config.Addr) {
+			log.Printf("The API is reachable from other hosts without a token")
+		}
+	}
+
+	if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
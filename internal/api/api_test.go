@@ -0,0 +1,260 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pouriyajamshidi/flat/internal/flowtable"
+	"github.com/pouriyajamshidi/flat/internal/output"
+	"github.com/pouriyajamshidi/flat/internal/packet"
+	"github.com/pouriyajamshidi/flat/internal/pending"
+	"github.com/pouriyajamshidi/flat/internal/stats"
+	"github.com/stretchr/testify/require"
+)
+
+var request = packet.Packet{
+	SrcIP:     netip.MustParseAddr("::ffff:192.168.0.156"),
+	DstIP:     netip.MustParseAddr("::ffff:1.1.1.1"),
+	SrcPort:   53264,
+	DstPort:   443,
+	Protocol:  6,
+	TTL:       64,
+	Syn:       true,
+	TimeStamp: 5_000_000_000,
+}
+
+func newTestAPI(token string) *API {
+	flowTable := flowtable.NewFlowTable[packet.Packet](flowtable.DefaultConfig())
+	statistics := stats.New[stats.Destination](stats.DefaultConfig())
+
+	a := New(Config{Token: token}, flowTable, statistics, output.NewRecords("eth0", nil))
+	a.now = func() uint64 { return 7_000_000_000 }
+
+	return a
+}
+
+func get(t *testing.T, server *httptest.Server, path, token string) *http.Response {
+	req, err := http.NewRequest(http.MethodGet, server.URL+path, nil)
+	require.NoError(t, err)
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := server.Client().Do(req)
+	require.NoError(t, err)
+
+	return resp
+}
+
+func TestAuthorization(t *testing.T) {
+	server := httptest.NewServer(newTestAPI("secret").Handler())
+	defer server.Close()
+
+	// Health checks never need the token
+	resp := get(t, server, "/healthz", "")
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = get(t, server, "/stats", "")
+	resp.Body.Close()
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp = get(t, server, "/stats", "wrong")
+	resp.Body.Close()
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp = get(t, server, "/stats", "secret")
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestFlowsAndStats(t *testing.T) {
+	a := newTestAPI("")
+
+	key := flowtable.NewFlowKey(request.SrcAddrPort(), request.DstAddrPort(), request.Protocol, 0)
+	a.flowTable.Insert(key, request.TimeStamp, request)
+	a.statistics.Record(stats.Destination{IP: request.DstIP, Port: 443, Protocol: 6}, time.Millisecond)
+
+	server := httptest.NewServer(a.Handler())
+	defer server.Close()
+
+	resp := get(t, server, "/flows", "")
+	defer resp.Body.Close()
+
+	var flows []pending.Flow
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&flows))
+	require.Equal(t, []pending.Flow{{
+		Protocol:  "TCP",
+		SrcIP:     "192.168.0.156",
+		SrcPort:   53264,
+		DstIP:     "1.1.1.1",
+		DstPort:   443,
+		PendingNs: 2_000_000_000,
+	}}, flows)
+
+	resp = get(t, server, "/stats", "")
+	defer resp.Body.Close()
+
+	var response Stats
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&response))
+	require.Equal(t, 1, response.FlowTable.Entries)
+	require.Len(t, response.Destinations, 1)
+	require.Equal(t, "1.1.1.1:443", response.Destinations[0].Destination)
+	require.Equal(t, uint64(1), response.Destinations[0].Count)
+	require.Equal(t, int64(time.Minute), response.WindowNs)
+	require.Len(t, response.Recent, 1)
+}
+
+func TestParseFilter(t *testing.T) {
+	for _, query := range []string{"type=dropped", "protocol=icmp", "ip=nope", "port=70000", "min_latency=fast"} {
+		values, err := url.ParseQuery(query)
+		require.NoError(t, err)
+
+		_, err = parseFilter(values)
+		require.Error(t, err, query)
+	}
+
+	values, err := url.ParseQuery("type=latency,timeout&protocol=tcp&ip=1.1.1.1&port=443&min_latency=10ms")
+	require.NoError(t, err)
+
+	f, err := parseFilter(values)
+	require.NoError(t, err)
+
+	records := output.NewRecords("eth0", nil)
+	reply := request.Reverse()
+
+	require.True(t, f.matches(records.Latency(packet.Result{Reply: reply, Latency: 20 * time.Millisecond})))
+	require.False(t, f.matches(records.Latency(packet.Result{Reply: reply, Latency: 5 * time.Millisecond})))
+	require.False(t, f.matches(records.Request(output.Timeout, request)))
+	require.False(t, f.matches(records.Request(output.Refused, request)))
+}
+
+func TestEvents(t *testing.T) {
+	a := newTestAPI("")
+
+	server := httptest.NewServer(a.Handler())
+	defer server.Close()
+
+	resp := get(t, server, "/events?type=timeout", "")
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	require.Eventually(t, func() bool {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+
+		return len(a.subscribers) == 1
+	}, time.Second, time.Millisecond*10)
+
+	// Only the timeout passes the filter
+	a.Latency(packet.Result{Reply: request.Reverse(), Latency: time.Millisecond})
+	a.Timeout(request)
+
+	reader := bufio.NewReader(resp.Body)
+
+	event, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, "event: timeout\n", event)
+
+	data, err := reader.ReadString('\n')
+	require.NoError(t, err)
+
+	var record output.Record
+	require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(data, "data: ")), &record))
+	require.Equal(t, output.Timeout, record.Type)
+	require.Equal(t, "1.1.1.1", record.DstIP)
+}
+
+func TestServeUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "flat.sock")
+
+	a := newTestAPI("")
+	a.config.Addr = "unix:" + path
+
+	listener, err := a.Listen()
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+
+	go func() { done <- a.Serve(ctx, listener) }()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+
+	require.Eventually(t, func() bool {
+		resp, err := client.Get("http://flat/healthz")
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+
+		return resp.StatusCode == http.StatusOK
+	}, time.Second, time.Millisecond*10)
+
+	cancel()
+	require.NoError(t, <-done)
+}
+
+func TestListenUnixSocketInUse(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "flat.sock")
+
+	a := newTestAPI("")
+	a.config.Addr = "unix:" + path
+
+	listener, err := a.Listen()
+	require.NoError(t, err)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	// The socket of a running instance is not taken over
+	_, err = a.Listen()
+	require.Error(t, err)
+
+	// The one left behind by an instance that exited is
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	require.NoError(t, listener.Close())
+
+	listener, err = a.Listen()
+	require.NoError(t, err)
+	require.NoError(t, listener.Close())
+}
+
+func TestListenAddressInUse(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer taken.Close()
+
+	a := newTestAPI("")
+	a.config.Addr = taken.Addr().String()
+
+	_, err = a.Listen()
+	require.Error(t, err)
+}
+
+func TestIsLoopback(t *testing.T) {
+	require.True(t, isLoopback("localhost:9810"))
+	require.True(t, isLoopback("127.0.0.1:9810"))
+	require.True(t, isLoopback("[::1]:9810"))
+	require.False(t, isLoopback(":9810"))
+	require.False(t, isLoopback("0.0.0.0:9810"))
+}
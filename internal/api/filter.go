@@ -0,0 +1,109 @@
+package api
+
+import (
+	"fmt"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pouriyajamshidi/flat/internal/output"
+)
+
+// filter selects the events of an /events stream. Its zero value matches every event
+type filter struct {
+	types      []output.Result
+	protocol   string
+	ip         netip.Addr
+	port       uint16
+	minLatency time.Duration
+}
+
+// parseFilter reads a filter from the query string of an /events request:
+//
+//	type=latency,timeout  only these result types
+//	protocol=tcp          only TCP or UDP
+//	ip=1.1.1.1            only flows to or from this address
+//	port=443              only flows to or from this port
+//	min_latency=50ms      only latencies of at least this long
+func parseFilter(query url.Values) (filter, error) {
+	var f filter
+
+	if value := query.Get("type"); value != "" {
+		for name := range strings.SplitSeq(value, ",") {
+			result := output.Result(strings.ToLower(strings.TrimSpace(name)))
+
+			switch result {
+			case output.Latency, output.Timeout, output.Refused, output.Anomaly:
+				f.types = append(f.types, result)
+			default:
+				return filter{}, fmt.Errorf("unknown type %q", name)
+			}
+		}
+	}
+
+	if value := query.Get("protocol"); value != "" {
+		f.protocol = strings.ToUpper(value)
+
+		if f.protocol != "TCP" && f.protocol != "UDP" {
+			return filter{}, fmt.Errorf("unknown protocol %q", value)
+		}
+	}
+
+	if value := query.Get("ip"); value != "" {
+		ip, err := netip.ParseAddr(value)
+		if err != nil {
+			return filter{}, fmt.Errorf("parsing ip: %w", err)
+		}
+
+		f.ip = ip.Unmap()
+	}
+
+	if value := query.Get("port"); value != "" {
+		port, err := strconv.ParseUint(value, 10, 16)
+		if err != nil || port == 0 {
+			return filter{}, fmt.Errorf("invalid port %q", value)
+		}
+
+		f.port = uint16(port)
+	}
+
+	if value := query.Get("min_latency"); value != "" {
+		latency, err := time.ParseDuration(value)
+		if err != nil {
+			return filter{}, fmt.Errorf("parsing min_latency: %w", err)
+		}
+
+		f.minLatency = latency
+	}
+
+	return f, nil
+}
+
+// matches checks whether a record passes every condition of the filter
+func (f filter) matches(record output.Record) bool {
+	if len(f.types) > 0 && !slices.Contains(f.types, record.Type) {
+		return false
+	}
+
+	if f.protocol != "" && f.protocol != record.Protocol {
+		return false
+	}
+
+	if f.ip.IsValid() && f.ip.String() != record.SrcIP && f.ip.String() != record.DstIP {
+		return false
+	}
+
+	if f.port != 0 && f.port != record.SrcPort && f.port != record.DstPort {
+		return false
+	}
+
+	// Timeouts and refusals have no latency to compare
+	if f.minLatency > 0 && ((record.Type != output.Latency && record.Type != output.Anomaly) || time.Duration(record.LatencyNs) < f.minLatency) {
+		return false
+	}
+
+	return true
+}
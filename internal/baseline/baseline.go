@@ -0,0 +1,306 @@
+package baseline
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pouriyajamshidi/flat/internal/packet"
+	"github.com/pouriyajamshidi/flat/internal/stats"
+)
+
+const (
+	// minRelativeDeviation keeps destinations with very stable latencies
+	// from flagging tiny absolute changes as anomalies
+	minRelativeDeviation = 0.05
+
+	saveInterval = time.Minute
+)
+
+// Config tunes how baselines are learned and how deviations are flagged
+type Config struct {
+	// Alpha is the EWMA smoothing factor, higher values forget faster
+	Alpha float64
+	// WarmUp is the number of samples a destination needs before it is scored
+	WarmUp uint64
+	// Sensitivity is the number of standard deviations a sample has
+	// to be away from the mean to be flagged
+	Sensitivity float64
+	// MaxDestinations bounds the memory used by baselines, the least
+	// recently seen destination is forgotten once it is reached
+	MaxDestinations int
+}
+
+// DefaultConfig returns the default baseline configuration
+func DefaultConfig() Config {
+	return Config{
+		Alpha:           0.05,
+		WarmUp:          30,
+		Sensitivity:     4,
+		MaxDestinations: 65536,
+	}
+}
+
+// Baseline is the learned latency of a destination
+type Baseline struct {
+	Count    uint64  `json:"count"`
+	Mean     float64 `json:"mean_ns"`
+	Variance float64 `json:"variance_ns2"`
+}
+
+// StdDev is the standard deviation of the baseline
+func (b Baseline) StdDev() float64 {
+	return math.Sqrt(b.Variance)
+}
+
+// update folds a sample into the exponentially weighted mean and variance
+func (b *Baseline) update(value, alpha float64) {
+	b.Count++
+
+	if b.Count == 1 {
+		b.Mean = value
+		b.Variance = 0
+		return
+	}
+
+	diff := value - b.Mean
+	increment := alpha * diff
+	b.Mean += increment
+	b.Variance = (1 - alpha) * (b.Variance + diff*increment)
+}
+
+// Anomaly describes a sample that deviates significantly from its baseline
+type Anomaly struct {
+	Destination stats.Destination
+	Latency     time.Duration
+	Mean        time.Duration
+	StdDev      time.Duration
+	Score       float64 // signed number of standard deviations away from the mean
+}
+
+// String formats the anomaly for humans
+func (anomaly Anomaly) String() string {
+	direction := "above"
+
+	if anomaly.Score < 0 {
+		direction = "below"
+	}
+
+	return fmt.Sprintf("(%v) %v latency %v is %.1fσ %v its baseline of %v ± %v",
+		packet.ProtocolName(anomaly.Destination.Protocol),
+		anomaly.Destination,
+		anomaly.Latency,
+		math.Abs(anomaly.Score),
+		direction,
+		anomaly.Mean,
+		anomaly.StdDev,
+	)
+}
+
+// learned is the baseline of a destination in the recency list
+type learned struct {
+	dest     stats.Destination
+	baseline Baseline
+}
+
+// Detector learns per destination baselines and flags anomalous samples
+type Detector struct {
+	mu        sync.Mutex
+	config    Config
+	baselines map[stats.Destination]*list.Element
+	lru       *list.List // of *learned, the most recently seen first
+}
+
+// NewDetector constructs a new Detector
+func NewDetector(config Config) *Detector {
+	if config.MaxDestinations < 1 {
+		config.MaxDestinations = DefaultConfig().MaxDestinations
+	}
+
+	return &Detector{
+		config:    config,
+		baselines: make(map[stats.Destination]*list.Element),
+		lru:       list.New(),
+	}
+}
+
+// add starts learning a destination, forgetting the least recently
+// seen one when full. Callers must hold the lock
+func (detector *Detector) add(dest stats.Destination, baseline Baseline) *Baseline {
+	if detector.lru.Len() >= detector.config.MaxDestinations {
+		oldest := detector.lru.Back()
+		detector.lru.Remove(oldest)
+		delete(detector.baselines, oldest.Value.(*learned).dest)
+	}
+
+	element := detector.lru.PushBack(&learned{dest: dest, baseline: baseline})
+	detector.baselines[dest] = element
+
+	return &element.Value.(*learned).baseline
+}
+
+// Observe scores a latency sample against the destination's baseline,
+// then folds it into the baseline so that lasting shifts become the new normal
+func (detector *Detector) Observe(dest stats.Destination, latency time.Duration) (Anomaly, bool) {
+	detector.mu.Lock()
+	defer detector.mu.Unlock()
+
+	var baseline *Baseline
+
+	if element, ok := detector.baselines[dest]; ok {
+		detector.lru.MoveToFront(element)
+		baseline = &element.Value.(*learned).baseline
+	} else {
+		baseline = detector.add(dest, Baseline{})
+		detector.lru.MoveToFront(detector.baselines[dest])
+	}
+
+	value := float64(latency)
+
+	var anomaly Anomaly
+	var flagged bool
+
+	if baseline.Count >= detector.config.WarmUp {
+		deviation := max(baseline.StdDev(), baseline.Mean*minRelativeDeviation)
+
+		if deviation > 0 {
+			score := (value - baseline.Mean) / deviation
+
+			if math.Abs(score) >= detector.config.Sensitivity {
+				flagged = true
+				anomaly = Anomaly{
+					Destination: dest,
+					Latency:     latency,
+					Mean:        time.Duration(baseline.Mean),
+					StdDev:      time.Duration(baseline.StdDev()),
+					Score:       score,
+				}
+			}
+		}
+	}
+
+	baseline.update(value, detector.config.Alpha)
+
+	return anomaly, flagged
+}
+
+// Baseline returns the learned baseline of a destination
+func (detector *Detector) Baseline(dest stats.Destination) (Baseline, bool) {
+	detector.mu.Lock()
+	defer detector.mu.Unlock()
+
+	element, ok := detector.baselines[dest]
+
+	if !ok {
+		return Baseline{}, false
+	}
+
+	return element.Value.(*learned).baseline, true
+}
+
+// entry is the persisted form of a destination's baseline
+type entry struct {
+	IP       netip.Addr `json:"ip"`
+	Port     uint16     `json:"port"`
+	Protocol uint8      `json:"protocol"`
+	Baseline
+}
+
+// Save writes the baselines to path, replacing it atomically
+func (detector *Detector) Save(path string) error {
+	detector.mu.Lock()
+
+	entries := make([]entry, 0, len(detector.baselines))
+
+	// Saved most recently seen first, so that Load keeps the same order
+	for element := detector.lru.Front(); element != nil; element = element.Next() {
+		current := element.Value.(*learned)
+		entries = append(entries, entry{IP: current.dest.IP, Port: current.dest.Port, Protocol: current.dest.Protocol, Baseline: current.baseline})
+	}
+
+	detector.mu.Unlock()
+
+	data, err := json.Marshal(entries)
+
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// Load restores the baselines saved at path. A missing file is not an error
+func (detector *Detector) Load(path string) error {
+	data, err := os.ReadFile(path)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	var entries []entry
+
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+
+	detector.mu.Lock()
+	defer detector.mu.Unlock()
+
+	for _, saved := range entries {
+		dest := stats.Destination{IP: saved.IP, Port: saved.Port, Protocol: saved.Protocol}
+
+		if _, ok := detector.baselines[dest]; ok || detector.lru.Len() >= detector.config.MaxDestinations {
+			continue
+		}
+
+		detector.add(dest, saved.Baseline)
+	}
+
+	return nil
+}
+
+// Run saves the baselines to path every minute until ctx is cancelled
+func (detector *Detector) Run(ctx context.Context, path string) {
+	ticker := time.NewTicker(saveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := detector.Save(path); err != nil {
+				log.Printf("Failed saving baselines: %v", err)
+			}
+		}
+	}
+}
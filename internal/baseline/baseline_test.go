@@ -0,0 +1,128 @@
+package baseline
+
+import (
+	"math/rand/v2"
+	"net/netip"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pouriyajamshidi/flat/internal/stats"
+	"github.com/stretchr/testify/require"
+)
+
+var dest = stats.Destination{IP: netip.MustParseAddr("1.1.1.1"), Port: 443, Protocol: 6}
+
+// jitter returns a latency around mean with a spread of ±10%
+func jitter(random *rand.Rand, mean time.Duration) time.Duration {
+	return mean + time.Duration((random.Float64()-0.5)*0.2*float64(mean))
+}
+
+func TestObserve(t *testing.T) {
+	random := rand.New(rand.NewPCG(1, 2))
+	detector := NewDetector(DefaultConfig())
+
+	// Nothing is flagged during the warm up, however odd
+	for i := range DefaultConfig().WarmUp {
+		latency := jitter(random, 20*time.Millisecond)
+
+		if i == 10 {
+			latency = time.Second
+		}
+
+		_, flagged := detector.Observe(dest, latency)
+		require.False(t, flagged, i)
+	}
+
+	for range 500 {
+		_, flagged := detector.Observe(dest, jitter(random, 20*time.Millisecond))
+		require.False(t, flagged)
+	}
+
+	baseline, ok := detector.Baseline(dest)
+	require.True(t, ok)
+	require.InDelta(t, float64(20*time.Millisecond), baseline.Mean, float64(2*time.Millisecond))
+
+	anomaly, flagged := detector.Observe(dest, 60*time.Millisecond)
+	require.True(t, flagged)
+	require.Equal(t, dest, anomaly.Destination)
+	require.Greater(t, anomaly.Score, DefaultConfig().Sensitivity)
+	require.Contains(t, anomaly.String(), "(TCP) 1.1.1.1:443 latency 60ms")
+	require.Contains(t, anomaly.String(), "above")
+
+	// Destinations are learned independently
+	other := stats.Destination{IP: netip.MustParseAddr("10.0.0.1"), Port: 5432, Protocol: 6}
+
+	for range 100 {
+		_, flagged := detector.Observe(other, jitter(random, 200*time.Millisecond))
+		require.False(t, flagged)
+	}
+}
+
+func TestSensitivity(t *testing.T) {
+	config := DefaultConfig()
+	config.Sensitivity = 100
+
+	random := rand.New(rand.NewPCG(1, 2))
+	detector := NewDetector(config)
+
+	for range 500 {
+		detector.Observe(dest, jitter(random, 20*time.Millisecond))
+	}
+
+	_, flagged := detector.Observe(dest, 60*time.Millisecond)
+	require.False(t, flagged)
+}
+
+func TestMaxDestinations(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxDestinations = 2
+
+	detector := NewDetector(config)
+	other := stats.Destination{IP: netip.MustParseAddr("8.8.8.8"), Port: 53, Protocol: 17}
+	third := stats.Destination{IP: netip.MustParseAddr("9.9.9.9"), Port: 53, Protocol: 17}
+
+	detector.Observe(dest, time.Millisecond)
+	detector.Observe(other, time.Millisecond)
+	detector.Observe(dest, time.Millisecond)
+
+	// The least recently seen destination makes room for the new one
+	detector.Observe(third, time.Millisecond)
+
+	_, ok := detector.Baseline(other)
+	require.False(t, ok)
+
+	learned, ok := detector.Baseline(dest)
+	require.True(t, ok)
+	require.Equal(t, uint64(2), learned.Count)
+
+	_, ok = detector.Baseline(third)
+	require.True(t, ok)
+}
+
+func TestSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "baselines.json")
+
+	random := rand.New(rand.NewPCG(1, 2))
+	detector := NewDetector(DefaultConfig())
+
+	for range 100 {
+		detector.Observe(dest, jitter(random, 20*time.Millisecond))
+	}
+
+	require.NoError(t, detector.Save(path))
+
+	restored := NewDetector(DefaultConfig())
+	require.NoError(t, restored.Load(path))
+
+	expected, _ := detector.Baseline(dest)
+	baseline, ok := restored.Baseline(dest)
+	require.True(t, ok)
+	require.Equal(t, expected, baseline)
+
+	// A restored baseline is past its warm up
+	_, flagged := restored.Observe(dest, 60*time.Millisecond)
+	require.True(t, flagged)
+
+	require.NoError(t, NewDetector(DefaultConfig()).Load(filepath.Join(t.TempDir(), "missing.json")))
+}
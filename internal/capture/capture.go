@@ -0,0 +1,64 @@
+package capture
+
+import (
+	"context"
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// SnapLen is the maximum number of bytes captured per frame
+const SnapLen = 65535
+
+func htons(value uint16) uint16 {
+	return value<<8 | value>>8
+}
+
+// Capture reads the Ethernet frames of an interface that match a classic
+// BPF filter and hands them to handle until ctx is cancelled.
+// The frame passed to handle is only valid until it returns
+func Capture(ctx context.Context, ifaceIndex int, filter []unix.SockFilter, handle func(frame []byte)) error {
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW|unix.SOCK_CLOEXEC, int(htons(unix.ETH_P_ALL)))
+
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	prog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+
+	if err := unix.SetsockoptSockFprog(fd, unix.SOL_SOCKET, unix.SO_ATTACH_FILTER, &prog); err != nil {
+		return err
+	}
+
+	// Wake up every second so that a cancelled ctx is noticed
+	timeout := unix.Timeval{Sec: 1}
+
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &timeout); err != nil {
+		return err
+	}
+
+	if err := unix.Bind(fd, &unix.SockaddrLinklayer{Protocol: htons(unix.ETH_P_ALL), Ifindex: ifaceIndex}); err != nil {
+		return err
+	}
+
+	buf := make([]byte, SnapLen)
+
+	for {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		n, _, err := unix.Recvfrom(fd, buf, 0)
+
+		if errors.Is(err, unix.EAGAIN) || errors.Is(err, unix.EINTR) {
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		handle(buf[:n])
+	}
+}
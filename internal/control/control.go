@@ -0,0 +1,194 @@
+package control
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pouriyajamshidi/flat/internal/flowtable"
+	"github.com/pouriyajamshidi/flat/internal/pending"
+)
+
+// DefaultPath is where flat ctl looks for the control socket
+const DefaultPath = "/run/flat.sock"
+
+// timeout bounds how long a client waits for a running instance
+const timeout = time.Second * 5
+
+// Commands understood by a running instance
+const (
+	Status       = "status"
+	Filters      = "filters"
+	AddFilter    = "filter-add"
+	RemoveFilter = "filter-remove"
+	Verbosity    = "verbosity"
+	Flows        = "flows"
+	ResetStats   = "reset-stats"
+)
+
+// Verbosity levels of the per result output
+const (
+	// Normal prints every result
+	Normal = "normal"
+	// Quiet prints no result, only summaries and statistics
+	Quiet = "quiet"
+)
+
+// Request is one command sent to a running instance as a JSON line
+type Request struct {
+	Command   string     `json:"command"`
+	IP        netip.Addr `json:"ip,omitzero"`         // of filter-add and filter-remove
+	Port      uint16     `json:"port,omitempty"`      // of filter-add and filter-remove
+	Verbosity string     `json:"verbosity,omitempty"` // of verbosity
+}
+
+// FilterSet holds the IPs and ports to track. A packet is tracked when it
+// is to or from any of them, or when no filter is set at all
+type FilterSet struct {
+	IPs   []netip.Addr `json:"ips"`
+	Ports []uint16     `json:"ports"`
+}
+
+// State describes a running instance
+type State struct {
+	Interface      string            `json:"interface"`
+	Index          int               `json:"index"`
+	AttachedAt     time.Time         `json:"attached_at"`
+	Filters        FilterSet         `json:"filters"`
+	Verbosity      string            `json:"verbosity"`
+	FlowTable      flowtable.Stats   `json:"flow_table"`
+	RingbufPending int               `json:"ringbuf_pending"`
+	RingbufSize    int               `json:"ringbuf_size"`
+	Dropped        map[string]uint64 `json:"dropped"` // results each output could not keep up with
+}
+
+// Response answers a Request. Only the field of the command is set
+type Response struct {
+	Error   string         `json:"error,omitempty"`
+	State   *State         `json:"state,omitempty"`
+	Filters *FilterSet     `json:"filters,omitempty"`
+	Flows   []pending.Flow `json:"flows,omitempty"`
+}
+
+// Handler answers a request
+type Handler func(Request) Response
+
+// listen listens on a unix socket that only its owner may connect to,
+// replacing the socket left behind by a previous run
+func listen(path string) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		// A socket that still accepts connections belongs to a running instance
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%v is in use by another instance", path)
+		}
+
+		os.Remove(path)
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Chmod(path, 0o600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	return listener, nil
+}
+
+// Serve answers the requests sent to the unix socket at path until ctx is cancelled
+func Serve(ctx context.Context, path string, handle Handler) error {
+	listener, err := listen(path)
+	if err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	log.Printf("Listening for control commands on %v", path)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return err
+		}
+
+		wg.Go(func() {
+			serveConn(ctx, conn, handle)
+		})
+	}
+}
+
+// serveConn answers the requests of a connection, one JSON line each
+func serveConn(ctx context.Context, conn net.Conn, handle Handler) {
+	defer conn.Close()
+
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	scanner := bufio.NewScanner(conn)
+	encoder := json.NewEncoder(conn)
+
+	for scanner.Scan() {
+		var request Request
+		var response Response
+
+		if err := json.Unmarshal(scanner.Bytes(), &request); err != nil {
+			response.Error = fmt.Sprintf("decoding request: %v", err)
+		} else {
+			response = handle(request)
+		}
+
+		if err := encoder.Encode(response); err != nil {
+			return
+		}
+	}
+}
+
+// Call sends a request to the instance listening on the unix socket at path
+func Call(path string, request Request) (Response, error) {
+	conn, err := net.DialTimeout("unix", path, timeout)
+	if err != nil {
+		return Response{}, err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	if err := json.NewEncoder(conn).Encode(request); err != nil {
+		return Response{}, err
+	}
+
+	var response Response
+
+	if err := json.NewDecoder(conn).Decode(&response); err != nil {
+		return Response{}, err
+	}
+
+	if response.Error != "" {
+		return response, errors.New(response.Error)
+	}
+
+	return response, nil
+}
@@ -0,0 +1,49 @@
+package control
+
+import (
+	"context"
+	"net/netip"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeAndCall(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "flat.sock")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+
+	go func() {
+		done <- Serve(ctx, path, func(request Request) Response {
+			if request.Command != Filters {
+				return Response{Error: "unknown command " + request.Command}
+			}
+
+			return Response{Filters: &FilterSet{IPs: []netip.Addr{request.IP}}}
+		})
+	}()
+
+	var response Response
+
+	require.Eventually(t, func() bool {
+		var err error
+		response, err = Call(path, Request{Command: Filters, IP: netip.MustParseAddr("1.1.1.1")})
+
+		return err == nil
+	}, time.Second, time.Millisecond*10)
+
+	require.Equal(t, []netip.Addr{netip.MustParseAddr("1.1.1.1")}, response.Filters.IPs)
+
+	// Errors of the running instance are returned as such
+	_, err := Call(path, Request{Command: "explode"})
+	require.EqualError(t, err, "unknown command explode")
+
+	// A second instance does not take over the socket
+	require.Error(t, Serve(ctx, path, nil))
+
+	cancel()
+	require.NoError(t, <-done)
+}
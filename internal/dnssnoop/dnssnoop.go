@@ -0,0 +1,155 @@
+package dnssnoop
+
+import (
+	"container/list"
+	"context"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/pouriyajamshidi/flat/internal/capture"
+	"golang.org/x/sys/unix"
+)
+
+// minTTL keeps short lived answers around long enough
+// for the connection that follows the lookup to be labelled
+const minTTL = time.Second * 30
+
+// maxEntries bounds the size of the table, whose least recently
+// answered address is evicted once it is full
+const maxEntries = 65536
+
+// udpSrcPort53 is a classic BPF program matching "udp and src port 53"
+// for both IPv4 and IPv6 Ethernet frames
+var udpSrcPort53 = []unix.SockFilter{
+	{Code: unix.BPF_LD | unix.BPF_H | unix.BPF_ABS, K: 12},                     // 0: ethertype
+	{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, Jt: 0, Jf: 4, K: 0x86dd},  // 1: IPv6?
+	{Code: unix.BPF_LD | unix.BPF_B | unix.BPF_ABS, K: 20},                     // 2: IPv6 next header
+	{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, Jt: 0, Jf: 11, K: 17},     // 3: UDP?
+	{Code: unix.BPF_LD | unix.BPF_H | unix.BPF_ABS, K: 54},                     // 4: UDP source port
+	{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, Jt: 8, Jf: 9, K: 53},      // 5: port 53?
+	{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, Jt: 0, Jf: 8, K: 0x0800},  // 6: IPv4?
+	{Code: unix.BPF_LD | unix.BPF_B | unix.BPF_ABS, K: 23},                     // 7: IPv4 protocol
+	{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, Jt: 0, Jf: 6, K: 17},      // 8: UDP?
+	{Code: unix.BPF_LD | unix.BPF_H | unix.BPF_ABS, K: 20},                     // 9: fragment offset
+	{Code: unix.BPF_JMP | unix.BPF_JSET | unix.BPF_K, Jt: 4, Jf: 0, K: 0x1fff}, // 10: fragmented?
+	{Code: unix.BPF_LDX | unix.BPF_B | unix.BPF_MSH, K: 14},                    // 11: IPv4 header length
+	{Code: unix.BPF_LD | unix.BPF_H | unix.BPF_IND, K: 14},                     // 12: UDP source port
+	{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, Jt: 0, Jf: 1, K: 53},      // 13: port 53?
+	{Code: unix.BPF_RET | unix.BPF_K, K: capture.SnapLen},                      // 14: accept
+	{Code: unix.BPF_RET | unix.BPF_K, K: 0},                                    // 15: drop
+}
+
+type entry struct {
+	ip      netip.Addr
+	name    string
+	expires time.Time
+}
+
+// Table maps IP addresses to the names that were queried to obtain them
+type Table struct {
+	mu         sync.RWMutex
+	maxEntries int
+	entries    map[netip.Addr]*list.Element
+	order      *list.List // of *entry, most recently answered first
+}
+
+// NewTable constructs a new Table
+func NewTable() *Table {
+	return &Table{
+		maxEntries: maxEntries,
+		entries:    make(map[netip.Addr]*list.Element),
+		order:      list.New(),
+	}
+}
+
+// Insert records that ip was returned for a query of name
+func (table *Table) Insert(ip netip.Addr, name string, ttl time.Duration) {
+	if ttl < minTTL {
+		ttl = minTTL
+	}
+
+	ip = ip.Unmap()
+	expires := time.Now().Add(ttl)
+
+	table.mu.Lock()
+	defer table.mu.Unlock()
+
+	if element, ok := table.entries[ip]; ok {
+		cached := element.Value.(*entry)
+		cached.name = name
+		cached.expires = expires
+		table.order.MoveToFront(element)
+
+		return
+	}
+
+	if table.order.Len() >= table.maxEntries {
+		oldest := table.order.Back()
+		table.order.Remove(oldest)
+		delete(table.entries, oldest.Value.(*entry).ip)
+	}
+
+	table.entries[ip] = table.order.PushFront(&entry{ip: ip, name: name, expires: expires})
+}
+
+// Lookup returns the queried name of an IP address if it has not expired
+func (table *Table) Lookup(ip netip.Addr) (string, bool) {
+	table.mu.RLock()
+	defer table.mu.RUnlock()
+
+	element, ok := table.entries[ip.Unmap()]
+
+	if !ok {
+		return "", false
+	}
+
+	cached := element.Value.(*entry)
+
+	if time.Now().After(cached.expires) {
+		return "", false
+	}
+
+	return cached.name, true
+}
+
+// HandleResponse records the A and AAAA answers of a DNS response.
+// Answers are labelled with the question name rather than the CNAME
+// target, since that is the name the application asked for
+func (table *Table) HandleResponse(dns *layers.DNS) {
+	if !dns.QR || dns.ResponseCode != layers.DNSResponseCodeNoErr || len(dns.Questions) == 0 {
+		return
+	}
+
+	name := string(dns.Questions[0].Name)
+
+	for _, answer := range dns.Answers {
+		if answer.Type != layers.DNSTypeA && answer.Type != layers.DNSTypeAAAA {
+			continue
+		}
+
+		ip, ok := netip.AddrFromSlice(answer.IP)
+		if !ok {
+			continue
+		}
+
+		table.Insert(ip, name, time.Duration(answer.TTL)*time.Second)
+	}
+}
+
+// HandleFrame decodes an Ethernet frame and records any DNS response in it
+func (table *Table) HandleFrame(frame []byte) {
+	pkt := gopacket.NewPacket(frame, layers.LayerTypeEthernet, gopacket.DecodeOptions{Lazy: true, NoCopy: true})
+
+	if dns, ok := pkt.Layer(layers.LayerTypeDNS).(*layers.DNS); ok {
+		table.HandleResponse(dns)
+	}
+}
+
+// Snoop captures DNS responses on an interface and feeds them
+// into the table until ctx is cancelled
+func (table *Table) Snoop(ctx context.Context, ifaceIndex int) error {
+	return capture.Capture(ctx, ifaceIndex, udpSrcPort53, table.HandleFrame)
+}
@@ -0,0 +1,142 @@
+package dnssnoop
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/require"
+)
+
+func dnsResponse(t *testing.T) []byte {
+	buf := gopacket.NewSerializeBuffer()
+
+	dns := &layers.DNS{
+		ID:        1,
+		QR:        true,
+		Questions: []layers.DNSQuestion{{Name: []byte("www.example.com"), Type: layers.DNSTypeA, Class: layers.DNSClassIN}},
+		Answers: []layers.DNSResourceRecord{
+			{Name: []byte("www.example.com"), Type: layers.DNSTypeCNAME, Class: layers.DNSClassIN, TTL: 60, CNAME: []byte("edge.cdn.net")},
+			{Name: []byte("edge.cdn.net"), Type: layers.DNSTypeA, Class: layers.DNSClassIN, TTL: 3600, IP: net.IP{93, 184, 216, 34}},
+			{Name: []byte("edge.cdn.net"), Type: layers.DNSTypeAAAA, Class: layers.DNSClassIN, TTL: 3600, IP: net.ParseIP("2606:2800:220:1::1")},
+		},
+	}
+
+	require.NoError(t, dns.SerializeTo(buf, gopacket.SerializeOptions{FixLengths: true}))
+
+	return buf.Bytes()
+}
+
+func dnsFrame(t *testing.T) []byte {
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 5},
+		DstMAC:       net.HardwareAddr{5, 4, 3, 2, 1, 0},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip := &layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		SrcIP:    net.IP{1, 1, 1, 1},
+		DstIP:    net.IP{192, 168, 0, 156},
+		Protocol: layers.IPProtocolUDP,
+	}
+	udp := &layers.UDP{SrcPort: 53, DstPort: 53264}
+	require.NoError(t, udp.SetNetworkLayerForChecksum(ip))
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	require.NoError(t, gopacket.SerializeLayers(buf, opts, eth, ip, udp, gopacket.Payload(dnsResponse(t))))
+
+	return buf.Bytes()
+}
+
+func TestHandleFrame(t *testing.T) {
+	table := NewTable()
+	table.HandleFrame(dnsFrame(t))
+
+	name, ok := table.Lookup(netip.MustParseAddr("::ffff:93.184.216.34"))
+	require.True(t, ok)
+	require.Equal(t, "www.example.com", name)
+
+	name, ok = table.Lookup(netip.MustParseAddr("2606:2800:220:1::1"))
+	require.True(t, ok)
+	require.Equal(t, "www.example.com", name)
+
+	_, ok = table.Lookup(netip.MustParseAddr("1.1.1.1"))
+	require.False(t, ok)
+}
+
+func TestInsertExpiry(t *testing.T) {
+	table := NewTable()
+	ip := netip.MustParseAddr("10.0.0.1")
+
+	table.Insert(ip, "db.internal", 0)
+	_, ok := table.Lookup(ip)
+	require.True(t, ok, "TTLs below the minimum are extended")
+
+	table.entries[ip].Value.(*entry).expires = time.Now().Add(-time.Second)
+	_, ok = table.Lookup(ip)
+	require.False(t, ok)
+}
+
+func TestInsertEviction(t *testing.T) {
+	table := NewTable()
+	table.maxEntries = 2
+
+	table.Insert(netip.MustParseAddr("10.0.0.1"), "a.internal", time.Minute)
+	table.Insert(netip.MustParseAddr("10.0.0.2"), "b.internal", time.Minute)
+
+	// Answering the first address again makes the second the oldest
+	table.Insert(netip.MustParseAddr("10.0.0.1"), "a.internal", time.Minute)
+	table.Insert(netip.MustParseAddr("10.0.0.3"), "c.internal", time.Minute)
+
+	require.Equal(t, 2, table.order.Len())
+
+	_, ok := table.Lookup(netip.MustParseAddr("10.0.0.2"))
+	require.False(t, ok)
+
+	name, ok := table.Lookup(netip.MustParseAddr("10.0.0.1"))
+	require.True(t, ok)
+	require.Equal(t, "a.internal", name)
+}
+
+func TestSnoopLoopback(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("snooping requires root")
+	}
+
+	lo, err := net.InterfaceByName("lo")
+	require.NoError(t, err)
+
+	table := NewTable()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() { done <- table.Snoop(ctx, lo.Index) }()
+
+	server, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IP{127, 0, 0, 1}, Port: 53})
+	if err != nil {
+		t.Skipf("cannot bind port 53: %v", err)
+	}
+	defer server.Close()
+
+	client, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IP{127, 0, 0, 1}})
+	require.NoError(t, err)
+	defer client.Close()
+
+	require.Eventually(t, func() bool {
+		server.WriteToUDP(dnsResponse(t), client.LocalAddr().(*net.UDPAddr))
+		_, ok := table.Lookup(netip.MustParseAddr("93.184.216.34"))
+		return ok
+	}, time.Second*5, time.Millisecond*50)
+
+	cancel()
+	require.NoError(t, <-done)
+}
@@ -0,0 +1,32 @@
+package flowtable
+
+import (
+	"net/netip"
+)
+
+// FlowKey identifies a flow regardless of the direction a packet travels in.
+// The two endpoints are stored in canonical order, so a packet and its reply
+// produce the same key while distinct flows never share one
+type FlowKey struct {
+	Low       netip.AddrPort
+	High      netip.AddrPort
+	Protocol  uint8
+	Interface int
+}
+
+// NewFlowKey builds the canonical FlowKey of a packet travelling from src to dst
+func NewFlowKey(src, dst netip.AddrPort, protocol uint8, iface int) FlowKey {
+	src = netip.AddrPortFrom(src.Addr().Unmap(), src.Port())
+	dst = netip.AddrPortFrom(dst.Addr().Unmap(), dst.Port())
+
+	if src.Compare(dst) > 0 {
+		src, dst = dst, src
+	}
+
+	return FlowKey{
+		Low:       src,
+		High:      dst,
+		Protocol:  protocol,
+		Interface: iface,
+	}
+}
@@ -1,73 +1,303 @@
 package flowtable
 
 import (
-	"log"
+	"container/list"
+	"context"
+	"hash/maphash"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pouriyajamshidi/flat/internal/timer"
 )
 
-// FlowTable stores all TCP and UDP flows
-type FlowTable struct {
-	Ticker *time.Ticker
-	sync.Map
+const protocolUDP = 17
+
+// Config holds the FlowTable limits and timeouts
+type Config struct {
+	// MaxEntries is the maximum number of pending flows across all shards.
+	// The least recently used flow of a shard is evicted when it is full
+	MaxEntries int
+	// Shards is the number of independently locked partitions
+	Shards int
+	// TCPTimeout is how long a SYN waits for its SYN/ACK
+	TCPTimeout time.Duration
+	// UDPTimeout is how long a UDP datagram waits for its reply
+	UDPTimeout time.Duration
+	// PruneInterval is how often expired flows are removed
+	PruneInterval time.Duration
+}
+
+// MinPruneInterval is the shortest interval expired flows are removed at
+const MinPruneInterval = time.Millisecond * 100
+
+// DefaultConfig returns the default FlowTable configuration
+func DefaultConfig() Config {
+	return Config{
+		MaxEntries:    65536,
+		Shards:        32,
+		TCPTimeout:    time.Second * 10,
+		UDPTimeout:    time.Second * 10,
+		PruneInterval: time.Second * 10,
+	}
+}
+
+// Stats holds the FlowTable counters
+type Stats struct {
+	Entries     int
+	Inserts     uint64
+	Hits        uint64
+	Misses      uint64
+	Evictions   uint64
+	Expirations uint64
+}
+
+// Flow is a pending flow as returned by Flows
+type Flow[V any] struct {
+	Key       FlowKey
+	Timestamp uint64
+	Value     V
+}
+
+type entry[V any] struct {
+	key       FlowKey
+	timestamp uint64
+	value     V
+}
+
+type shard struct {
+	mu      sync.Mutex
+	entries map[FlowKey]*list.Element
+	lru     *list.List // most recently used at the front
+}
+
+// FlowTable stores the pending TCP and UDP flows in a bounded,
+// sharded table with per protocol timeouts and LRU eviction.
+// Each flow carries a value, such as the packet that initiated it
+type FlowTable[V any] struct {
+	config      Config
+	maxPerShard int
+	shards      []*shard
+	seed        maphash.Seed
+	now         func() uint64
+	onExpire    func(FlowKey, V)
+
+	inserts     atomic.Uint64
+	hits        atomic.Uint64
+	misses      atomic.Uint64
+	evictions   atomic.Uint64
+	expirations atomic.Uint64
 }
 
 // NewFlowTable Constructs a new FlowTable
-func NewFlowTable() *FlowTable {
-	return &FlowTable{Ticker: time.NewTicker(time.Second * 10)}
+func NewFlowTable[V any](config Config) *FlowTable[V] {
+	if config.Shards < 1 {
+		config.Shards = 1
+	}
+
+	if config.MaxEntries < config.Shards {
+		config.MaxEntries = config.Shards
+	}
+
+	config.PruneInterval = max(config.PruneInterval, MinPruneInterval)
+
+	table := &FlowTable[V]{
+		config:      config,
+		maxPerShard: (config.MaxEntries + config.Shards - 1) / config.Shards,
+		shards:      make([]*shard, config.Shards),
+		seed:        maphash.MakeSeed(),
+		now:         timer.GetNanosecSinceBoot,
+	}
+
+	for i := range table.shards {
+		table.shards[i] = &shard{
+			entries: make(map[FlowKey]*list.Element),
+			lru:     list.New(),
+		}
+	}
+
+	return table
 }
 
-// Insert adds packet hash and its timestamp to the FlowTable
-func (table *FlowTable) Insert(hash, timestamp uint64) {
-	table.Store(hash, timestamp)
+func (table *FlowTable[V]) shard(key FlowKey) *shard {
+	return table.shards[maphash.Comparable(table.seed, key)%uint64(len(table.shards))]
+}
+
+// timeout returns how long a flow of the given protocol may stay pending
+func (table *FlowTable[V]) timeout(protocol uint8) time.Duration {
+	if protocol == protocolUDP {
+		return table.config.UDPTimeout
+	}
+	return table.config.TCPTimeout
+}
+
+// OnExpire registers a function to be called for every flow
+// that outlives its protocol timeout
+func (table *FlowTable[V]) OnExpire(fn func(FlowKey, V)) {
+	table.onExpire = fn
+}
+
+// SetClock replaces the clock flows expire by, which defaults to
+// the monotonic clock of the eBPF timestamps
+func (table *FlowTable[V]) SetClock(now func() uint64) {
+	table.now = now
+}
+
+// Insert adds a flow, its timestamp and value to the FlowTable,
+// evicting the least recently used flow if the shard is full
+func (table *FlowTable[V]) Insert(key FlowKey, timestamp uint64, value V) {
+	s := table.shard(key)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	table.inserts.Add(1)
+
+	if elem, ok := s.entries[key]; ok {
+		elem.Value = entry[V]{key: key, timestamp: timestamp, value: value}
+		s.lru.MoveToFront(elem)
+		return
+	}
+
+	if s.lru.Len() >= table.maxPerShard {
+		oldest := s.lru.Back()
+		s.lru.Remove(oldest)
+		delete(s.entries, oldest.Value.(entry[V]).key)
+		table.evictions.Add(1)
+	}
+
+	s.entries[key] = s.lru.PushFront(entry[V]{key: key, timestamp: timestamp, value: value})
 }
 
-// Get loads packet hash and its timestamp from the FlowTable
-func (table *FlowTable) Get(hash uint64) (uint64, bool) {
-	value, ok := table.Load(hash)
+// Get loads a flow's value from the FlowTable
+func (table *FlowTable[V]) Get(key FlowKey) (V, bool) {
+	s := table.shard(key)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elem, ok := s.entries[key]
 
 	if !ok {
-		return 0, ok
+		table.misses.Add(1)
+
+		var zero V
+		return zero, false
 	}
-	return value.(uint64), true
+
+	table.hits.Add(1)
+	s.lru.MoveToFront(elem)
+
+	return elem.Value.(entry[V]).value, true
 }
 
-// Remove deletes packet hash and its timestamp from the FlowTable
-func (table *FlowTable) Remove(hash uint64) {
-	_, found := table.Load(hash)
+// Remove deletes a flow and its timestamp from the FlowTable, if it is there
+func (table *FlowTable[V]) Remove(key FlowKey) {
+	s := table.shard(key)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	if found {
-		// log.Printf("Removing hash %v from flow table", hash)
-		table.Delete(hash)
-	} else {
-		log.Printf("hash %v is not in flow table", hash)
+	if elem, found := s.entries[key]; found {
+		s.lru.Remove(elem)
+		delete(s.entries, key)
 	}
 }
 
-// Prune clears the stale entries (older than 10 seconds) from the FlowTable
-func (table *FlowTable) Prune() {
-	now := timer.GetNanosecSinceBoot()
+// Prune clears the entries that have outlived their protocol timeout
+func (table *FlowTable[V]) Prune() int {
+	now := table.now()
+
+	var expired []entry[V]
+
+	for _, s := range table.shards {
+		s.mu.Lock()
+
+		for elem := s.lru.Back(); elem != nil; {
+			prev := elem.Prev()
+			flow := elem.Value.(entry[V])
+
+			if now > flow.timestamp && now-flow.timestamp > uint64(table.timeout(flow.key.Protocol)) {
+				s.lru.Remove(elem)
+				delete(s.entries, flow.key)
+				expired = append(expired, flow)
+			}
+
+			elem = prev
+		}
+
+		s.mu.Unlock()
+	}
+
+	table.expirations.Add(uint64(len(expired)))
 
-	table.Range(func(hash, timestamp interface{}) bool {
-		if (now-timestamp.(uint64))/1000000 > 10000 {
-			log.Printf("Pruning stale entry from flow table: %v", hash)
+	// Call the handler without holding any shard lock
+	// so that it is free to use the table
+	if table.onExpire != nil {
+		for _, flow := range expired {
+			table.onExpire(flow.key, flow.value)
+		}
+	}
 
-			table.Delete(hash)
+	return len(expired)
+}
 
-			return true
+// Run prunes the FlowTable periodically until ctx is cancelled.
+// The pruned flows are counted in Stats and handed to the OnExpire handler
+func (table *FlowTable[V]) Run(ctx context.Context) {
+	ticker := time.NewTicker(table.config.PruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			table.Prune()
 		}
-		return false
-	})
+	}
 }
 
 // Entries displays the current number of entries in flowtable
-func (table *FlowTable) Entries() int {
+func (table *FlowTable[V]) Entries() int {
 	count := 0
-	table.Range(func(_, _ interface{}) bool {
-		count++
-		return true
-	})
+
+	for _, s := range table.shards {
+		s.mu.Lock()
+		count += s.lru.Len()
+		s.mu.Unlock()
+	}
+
 	return count
 }
+
+// Flows returns a snapshot of the pending flows without
+// counting as a use of any of them
+func (table *FlowTable[V]) Flows() []Flow[V] {
+	var flows []Flow[V]
+
+	for _, s := range table.shards {
+		s.mu.Lock()
+
+		for elem := s.lru.Front(); elem != nil; elem = elem.Next() {
+			flow := elem.Value.(entry[V])
+			flows = append(flows, Flow[V]{Key: flow.key, Timestamp: flow.timestamp, Value: flow.value})
+		}
+
+		s.mu.Unlock()
+	}
+
+	return flows
+}
+
+// Stats returns a snapshot of the FlowTable counters
+func (table *FlowTable[V]) Stats() Stats {
+	return Stats{
+		Entries:     table.Entries(),
+		Inserts:     table.inserts.Load(),
+		Hits:        table.hits.Load(),
+		Misses:      table.misses.Load(),
+		Evictions:   table.evictions.Load(),
+		Expirations: table.expirations.Load(),
+	}
+}
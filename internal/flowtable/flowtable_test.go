@@ -0,0 +1,133 @@
+package flowtable
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testKey(id uint64, protocol uint8) FlowKey {
+	return NewFlowKey(
+		netip.AddrPortFrom(netip.MustParseAddr("10.0.0.1"), uint16(id)),
+		netip.AddrPortFrom(netip.MustParseAddr("10.0.0.2"), uint16(id>>16)),
+		protocol,
+		1,
+	)
+}
+
+func newTestTable(maxEntries int) (*FlowTable[uint64], *uint64) {
+	config := DefaultConfig()
+	config.MaxEntries = maxEntries
+	config.Shards = 1
+	config.TCPTimeout = time.Second
+	config.UDPTimeout = time.Second * 5
+
+	var now uint64
+
+	table := NewFlowTable[uint64](config)
+	table.now = func() uint64 { return now }
+
+	return table, &now
+}
+
+func TestLRUEviction(t *testing.T) {
+	table, _ := newTestTable(2)
+
+	table.Insert(testKey(1, 6), 100, 100)
+	table.Insert(testKey(2, 6), 200, 200)
+
+	// Touch 1 so that 2 becomes the least recently used flow
+	_, ok := table.Get(testKey(1, 6))
+	require.True(t, ok)
+
+	table.Insert(testKey(3, 6), 300, 300)
+
+	_, ok = table.Get(testKey(2, 6))
+	require.False(t, ok)
+
+	ts, ok := table.Get(testKey(1, 6))
+	require.True(t, ok)
+	require.Equal(t, uint64(100), ts)
+
+	stats := table.Stats()
+	require.Equal(t, 2, stats.Entries)
+	require.Equal(t, uint64(3), stats.Inserts)
+	require.Equal(t, uint64(2), stats.Hits)
+	require.Equal(t, uint64(1), stats.Misses)
+	require.Equal(t, uint64(1), stats.Evictions)
+}
+
+func TestPrunePerProtocol(t *testing.T) {
+	table, now := newTestTable(100)
+
+	var expired []FlowKey
+
+	table.OnExpire(func(key FlowKey, _ uint64) {
+		expired = append(expired, key)
+	})
+
+	for id := uint64(0); id < 10; id++ {
+		table.Insert(testKey(id, 6), 0, 0)
+	}
+	table.Insert(testKey(100, 17), 0, 0)
+
+	// A fresh entry in the middle must not stop pruning of the others
+	*now = uint64(time.Second * 2)
+	table.Insert(testKey(5, 6), *now, *now)
+
+	// The UDP flow is older than the TCP timeout but within its own
+	require.Equal(t, 9, table.Prune())
+	require.Equal(t, 2, table.Entries())
+	require.Len(t, expired, 9)
+	require.NotContains(t, expired, testKey(5, 6))
+
+	_, ok := table.Get(testKey(100, 17))
+	require.True(t, ok)
+
+	*now = uint64(time.Second * 6)
+
+	require.Equal(t, 2, table.Prune())
+	require.Equal(t, 0, table.Entries())
+	require.Equal(t, uint64(11), table.Stats().Expirations)
+}
+
+func TestShardedMaxEntries(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxEntries = 64
+	config.Shards = 8
+
+	table := NewFlowTable[uint64](config)
+
+	for id := uint64(0); id < 10_000; id++ {
+		table.Insert(testKey(id, 17), id, id)
+	}
+
+	require.Equal(t, 64, table.Entries())
+	require.Equal(t, uint64(10_000-64), table.Stats().Evictions)
+}
+
+func TestPruneIntervalFloor(t *testing.T) {
+	config := DefaultConfig()
+	config.PruneInterval = 0
+
+	table := NewFlowTable[uint64](config)
+	require.Equal(t, MinPruneInterval, table.config.PruneInterval)
+}
+
+func TestFlows(t *testing.T) {
+	table, _ := newTestTable(10)
+
+	table.Insert(testKey(1, 6), 100, 1)
+	table.Insert(testKey(2, 17), 200, 2)
+
+	flows := table.Flows()
+	require.ElementsMatch(t, []Flow[uint64]{
+		{Key: testKey(1, 6), Timestamp: 100, Value: 1},
+		{Key: testKey(2, 17), Timestamp: 200, Value: 2},
+	}, flows)
+
+	// Taking a snapshot is not a lookup
+	require.Zero(t, table.Stats().Hits)
+}
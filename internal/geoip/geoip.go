@@ -0,0 +1,142 @@
+package geoip
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/oschwald/maxminddb-golang/v2"
+)
+
+// Location holds the geographical and network ownership of an IP address
+type Location struct {
+	Country string
+	City    string
+	ASN     uint32
+	Org     string
+}
+
+// IsZero reports whether nothing is known about the address
+func (loc Location) IsZero() bool {
+	return loc == Location{}
+}
+
+// String formats the location as "country/city ASn (org)"
+func (loc Location) String() string {
+	var parts []string
+
+	switch {
+	case loc.Country != "" && loc.City != "":
+		parts = append(parts, loc.Country+"/"+loc.City)
+	case loc.Country != "":
+		parts = append(parts, loc.Country)
+	}
+
+	if loc.ASN != 0 {
+		parts = append(parts, fmt.Sprintf("AS%d", loc.ASN))
+	}
+
+	if loc.Org != "" {
+		parts = append(parts, "("+loc.Org+")")
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// cityRecord is the subset of GeoIP2/GeoLite2 City and Country records in use
+type cityRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	City struct {
+		Names struct {
+			English string `maxminddb:"en"`
+		} `maxminddb:"names"`
+	} `maxminddb:"city"`
+}
+
+// asnRecord is the subset of GeoLite2 ASN records in use
+type asnRecord struct {
+	Number uint32 `maxminddb:"autonomous_system_number"`
+	Org    string `maxminddb:"autonomous_system_organization"`
+}
+
+// Database looks up IP addresses in MaxMind format databases
+type Database struct {
+	city *maxminddb.Reader
+	asn  *maxminddb.Reader
+}
+
+// Open loads the City (or Country) and ASN databases.
+// Either path may be empty, but not both
+func Open(cityPath, asnPath string) (*Database, error) {
+	if cityPath == "" && asnPath == "" {
+		return nil, errors.New("no GeoIP database provided")
+	}
+
+	db := &Database{}
+
+	if cityPath != "" {
+		reader, err := maxminddb.Open(cityPath)
+		if err != nil {
+			return nil, err
+		}
+
+		db.city = reader
+	}
+
+	if asnPath != "" {
+		reader, err := maxminddb.Open(asnPath)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+
+		db.asn = reader
+	}
+
+	return db, nil
+}
+
+// Lookup returns the location of an IP address
+func (db *Database) Lookup(ip netip.Addr) (Location, bool) {
+	var loc Location
+
+	ip = ip.Unmap()
+
+	if db.city != nil {
+		var record cityRecord
+
+		if err := db.city.Lookup(ip).Decode(&record); err == nil {
+			loc.Country = record.Country.ISOCode
+			loc.City = record.City.Names.English
+		}
+	}
+
+	if db.asn != nil {
+		var record asnRecord
+
+		if err := db.asn.Lookup(ip).Decode(&record); err == nil {
+			loc.ASN = record.Number
+			loc.Org = record.Org
+		}
+	}
+
+	return loc, !loc.IsZero()
+}
+
+// Close releases the underlying databases
+func (db *Database) Close() error {
+	var errs []error
+
+	if db.city != nil {
+		errs = append(errs, db.city.Close())
+	}
+
+	if db.asn != nil {
+		errs = append(errs, db.asn.Close())
+	}
+
+	return errors.Join(errs...)
+}
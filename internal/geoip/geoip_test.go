@@ -0,0 +1,73 @@
+package geoip
+
+import (
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/stretchr/testify/require"
+)
+
+func writeDatabase(t *testing.T, dbType, network string, record mmdbtype.Map) string {
+	tree, err := mmdbwriter.New(mmdbwriter.Options{DatabaseType: dbType, RecordSize: 24})
+	require.NoError(t, err)
+
+	_, ipNet, err := net.ParseCIDR(network)
+	require.NoError(t, err)
+	require.NoError(t, tree.Insert(ipNet, record))
+
+	path := filepath.Join(t.TempDir(), dbType+".mmdb")
+
+	file, err := os.Create(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	_, err = tree.WriteTo(file)
+	require.NoError(t, err)
+
+	return path
+}
+
+func TestLookup(t *testing.T) {
+	cityPath := writeDatabase(t, "GeoLite2-City", "1.1.1.0/24", mmdbtype.Map{
+		"country": mmdbtype.Map{"iso_code": mmdbtype.String("AU")},
+		"city":    mmdbtype.Map{"names": mmdbtype.Map{"en": mmdbtype.String("Sydney")}},
+	})
+	asnPath := writeDatabase(t, "GeoLite2-ASN", "1.1.1.0/24", mmdbtype.Map{
+		"autonomous_system_number":       mmdbtype.Uint32(13335),
+		"autonomous_system_organization": mmdbtype.String("CLOUDFLARENET"),
+	})
+
+	db, err := Open(cityPath, asnPath)
+	require.NoError(t, err)
+	defer db.Close()
+
+	loc, ok := db.Lookup(netip.MustParseAddr("::ffff:1.1.1.1"))
+	require.True(t, ok)
+	require.Equal(t, Location{Country: "AU", City: "Sydney", ASN: 13335, Org: "CLOUDFLARENET"}, loc)
+	require.Equal(t, "AU/Sydney AS13335 (CLOUDFLARENET)", loc.String())
+
+	_, ok = db.Lookup(netip.MustParseAddr("8.8.8.8"))
+	require.False(t, ok)
+}
+
+func TestOpenASNOnly(t *testing.T) {
+	asnPath := writeDatabase(t, "GeoLite2-ASN", "8.8.8.0/24", mmdbtype.Map{
+		"autonomous_system_number": mmdbtype.Uint32(15169),
+	})
+
+	db, err := Open("", asnPath)
+	require.NoError(t, err)
+	defer db.Close()
+
+	loc, ok := db.Lookup(netip.MustParseAddr("8.8.8.8"))
+	require.True(t, ok)
+	require.Equal(t, "AS15169", loc.String())
+
+	_, err = Open("", "")
+	require.Error(t, err)
+}
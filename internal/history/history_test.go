@@ -0,0 +1,149 @@
+package history
+
+import (
+	"context"
+	"net/netip"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pouriyajamshidi/flat/internal/packet"
+	"github.com/pouriyajamshidi/flat/internal/stats"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	cloudflare = stats.Destination{IP: netip.MustParseAddr("1.1.1.1"), Port: 443, Protocol: 6}
+	google     = stats.Destination{IP: netip.MustParseAddr("8.8.8.8"), Port: 53, Protocol: 17}
+)
+
+func open(t *testing.T) *Store {
+	store, err := Open(filepath.Join(t.TempDir(), "history.db"))
+	require.NoError(t, err)
+
+	t.Cleanup(func() { store.Close() })
+
+	return store
+}
+
+func sketchOf(latencies ...time.Duration) *stats.Sketch {
+	sketch := stats.NewSketch(stats.DefaultRelativeAccuracy)
+
+	for _, latency := range latencies {
+		sketch.Add(float64(latency))
+	}
+
+	return sketch
+}
+
+func TestQuery(t *testing.T) {
+	store := open(t)
+	ctx := context.Background()
+	start := time.Unix(1700000000, 0)
+
+	var aggregates []Aggregate
+
+	for i := range 4 {
+		begin := start.Add(time.Minute * time.Duration(i))
+
+		aggregates = append(aggregates,
+			Aggregate{
+				Start: begin, End: begin.Add(time.Minute), Interface: "eth0", Destination: cloudflare,
+				Timeouts: 1, Sketch: sketchOf(10*time.Millisecond, 20*time.Millisecond),
+			},
+			Aggregate{
+				Start: begin, End: begin.Add(time.Minute), Interface: "eth0", Destination: google,
+				Refused: 1, Sketch: sketchOf(5 * time.Millisecond),
+			},
+		)
+	}
+
+	require.NoError(t, store.Write(ctx, aggregates, nil))
+
+	// The whole range merges into a row per destination, busiest first
+	rows, err := store.Query(ctx, Filter{From: start, To: start.Add(time.Hour)})
+	require.NoError(t, err)
+	require.Len(t, rows, 2)
+	require.Equal(t, cloudflare, rows[0].Destination)
+	require.Equal(t, uint64(8), rows[0].Sketch.Count())
+	require.Equal(t, uint64(4), rows[0].Timeouts)
+	require.Equal(t, google, rows[1].Destination)
+	require.Equal(t, uint64(4), rows[1].Refused)
+	require.InDelta(t, float64(20*time.Millisecond), rows[0].Sketch.Max(), 1)
+
+	// Steps split the range into buckets
+	rows, err = store.Query(ctx, Filter{From: start, To: start.Add(time.Hour), Step: time.Minute * 2, Prefix: netip.MustParsePrefix("1.1.1.0/24")})
+	require.NoError(t, err)
+	require.Len(t, rows, 2)
+	require.True(t, rows[0].Start.Equal(start))
+	require.True(t, rows[1].Start.Equal(start.Add(time.Minute*2)))
+	require.Equal(t, uint64(4), rows[1].Sketch.Count())
+
+	// Intervals that merely touch the range are left out
+	rows, err = store.Query(ctx, Filter{From: start.Add(time.Minute), To: start.Add(time.Minute * 2), Port: 53, Protocol: 17})
+	require.NoError(t, err)
+	require.Len(t, rows, 1)
+	require.Equal(t, google, rows[0].Destination)
+	require.Equal(t, uint64(1), rows[0].Sketch.Count())
+
+	// An interval that overlaps the range counts even if it started before it
+	rows, err = store.Query(ctx, Filter{From: start.Add(time.Second * 90), To: start.Add(time.Second * 100), Step: time.Second, Port: 53})
+	require.NoError(t, err)
+	require.Len(t, rows, 1)
+	require.True(t, rows[0].Start.Equal(start.Add(time.Second*90)))
+	require.Equal(t, uint64(1), rows[0].Sketch.Count())
+
+	rows, err = store.Query(ctx, Filter{From: start, To: start.Add(time.Hour), Interface: "eth1"})
+	require.NoError(t, err)
+	require.Empty(t, rows)
+}
+
+func TestRecorder(t *testing.T) {
+	store := open(t)
+	ctx := context.Background()
+
+	recorder := NewRecorder(store, Config{Samples: true, Retention: time.Hour}, "eth0")
+	recorder.boot = time.Now().Add(-time.Second * 5)
+
+	request := packet.Packet{
+		SrcIP:     netip.MustParseAddr("::ffff:192.168.0.156"),
+		DstIP:     netip.MustParseAddr("::ffff:1.1.1.1"),
+		SrcPort:   53264,
+		DstPort:   443,
+		Protocol:  6,
+		Syn:       true,
+		TimeStamp: 5_000_000_000,
+	}
+
+	reply := request.Reverse()
+	reply.TimeStamp = request.TimeStamp + uint64(12*time.Millisecond)
+
+	recorder.Latency(packet.Result{Request: request, Reply: reply, Latency: 12 * time.Millisecond})
+	recorder.Timeout(request)
+	recorder.Refused(request)
+
+	now := time.Now().Add(time.Minute)
+	recorder.now = func() time.Time { return now }
+	recorder.Flush(ctx)
+
+	rows, err := store.Query(ctx, Filter{From: now.Add(-time.Hour), To: now})
+	require.NoError(t, err)
+	require.Len(t, rows, 1)
+	require.Equal(t, cloudflare, rows[0].Destination)
+	require.Equal(t, uint64(1), rows[0].Sketch.Count())
+	require.Equal(t, uint64(1), rows[0].Timeouts)
+	require.Equal(t, uint64(1), rows[0].Refused)
+
+	var samples int
+	require.NoError(t, store.db.QueryRow(`SELECT COUNT(*) FROM samples`).Scan(&samples))
+	require.Equal(t, 1, samples)
+
+	// Samples that outlived the retention are dropped, aggregates are kept
+	deleted, err := store.DeleteSamples(ctx, time.Now().Add(time.Hour*24*365))
+	require.NoError(t, err)
+	require.Equal(t, int64(1), deleted)
+
+	rows, err = store.Query(ctx, Filter{From: now.Add(-time.Hour), To: now})
+	require.NoError(t, err)
+	require.Len(t, rows, 1)
+}
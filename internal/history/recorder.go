@@ -0,0 +1,191 @@
+package history
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pouriyajamshidi/flat/internal/baseline"
+	"github.com/pouriyajamshidi/flat/internal/packet"
+	"github.com/pouriyajamshidi/flat/internal/stats"
+	"github.com/pouriyajamshidi/flat/internal/timer"
+)
+
+const (
+	// DefaultInterval is how long each stored aggregate covers
+	DefaultInterval = time.Minute
+	// DefaultRetention is how long raw samples are kept for
+	DefaultRetention = time.Hour * 24 * 7
+)
+
+// Config holds the history file and what is kept in it
+type Config struct {
+	// Path is the SQLite file
+	Path string
+	// Interval is how long each aggregate covers
+	Interval time.Duration
+	// Samples also stores every latency, not only the aggregates
+	Samples bool
+	// Retention is how long samples are kept for. Aggregates are kept forever
+	Retention time.Duration
+}
+
+type aggregate struct {
+	timeouts uint64
+	refused  uint64
+	sketch   *stats.Sketch
+}
+
+// Recorder aggregates the results of each interval per destination and
+// writes them to a Store, along with the raw samples if configured
+type Recorder struct {
+	store  *Store
+	config Config
+	iface  string
+	boot   time.Time // wall clock time of the monotonic clock's zero
+	now    func() time.Time
+
+	mu         sync.Mutex
+	start      time.Time
+	aggregates map[stats.Destination]*aggregate
+	samples    []Sample
+}
+
+// NewRecorder constructs a new Recorder of the results seen on the named interface
+func NewRecorder(store *Store, config Config, iface string) *Recorder {
+	if config.Interval <= 0 {
+		config.Interval = DefaultInterval
+	}
+
+	if config.Retention <= 0 {
+		config.Retention = DefaultRetention
+	}
+
+	return &Recorder{
+		store:      store,
+		config:     config,
+		iface:      iface,
+		boot:       timer.BootTime(),
+		now:        time.Now,
+		start:      time.Now(),
+		aggregates: make(map[stats.Destination]*aggregate),
+	}
+}
+
+// destination returns the aggregate of the server a request was sent to.
+// Callers must hold the lock
+func (r *Recorder) destination(request packet.Packet) *aggregate {
+	dest := stats.Destination{IP: request.DstIP.Unmap(), Port: request.DstPort, Protocol: request.Protocol}
+
+	a, ok := r.aggregates[dest]
+
+	if !ok {
+		a = &aggregate{sketch: stats.NewSketch(stats.DefaultRelativeAccuracy)}
+		r.aggregates[dest] = a
+	}
+
+	return a
+}
+
+// Latency satisfies the output.Sink interface
+func (r *Recorder) Latency(result packet.Result) {
+	request := result.Reply.Reverse()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.destination(request).sketch.Add(float64(result.Latency))
+
+	if r.config.Samples {
+		r.samples = append(r.samples, Sample{
+			Time:        r.boot.Add(time.Duration(result.Reply.TimeStamp)),
+			Interface:   r.iface,
+			Client:      request.SrcAddrPort(),
+			Destination: stats.Destination{IP: request.DstIP.Unmap(), Port: request.DstPort, Protocol: request.Protocol},
+			Latency:     result.Latency,
+		})
+	}
+}
+
+// Timeout satisfies the output.Sink interface
+func (r *Recorder) Timeout(request packet.Packet) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.destination(request).timeouts++
+}
+
+// Refused satisfies the output.Sink interface
+func (r *Recorder) Refused(request packet.Packet) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.destination(request).refused++
+}
+
+// Anomaly satisfies the output.Sink interface, anomalies are not recorded
+func (r *Recorder) Anomaly(packet.Result, baseline.Anomaly) {}
+
+// Flush writes the aggregates of the interval that ends now and the pending
+// samples, then drops the samples that have outlived the retention
+func (r *Recorder) Flush(ctx context.Context) {
+	r.mu.Lock()
+
+	end := r.now()
+	aggregates := make([]Aggregate, 0, len(r.aggregates))
+
+	for dest, a := range r.aggregates {
+		aggregates = append(aggregates, Aggregate{
+			Start:       r.start,
+			End:         end,
+			Interface:   r.iface,
+			Destination: dest,
+			Timeouts:    a.timeouts,
+			Refused:     a.refused,
+			Sketch:      a.sketch,
+		})
+	}
+
+	samples := r.samples
+
+	r.start = end
+	r.aggregates = make(map[stats.Destination]*aggregate)
+	r.samples = nil
+
+	r.mu.Unlock()
+
+	if len(aggregates) > 0 || len(samples) > 0 {
+		if err := r.store.Write(ctx, aggregates, samples); err != nil {
+			log.Printf("Failed writing history: %v", err)
+		}
+	}
+
+	if r.config.Samples {
+		if _, err := r.store.DeleteSamples(ctx, end.Add(-r.config.Retention)); err != nil {
+			log.Printf("Failed deleting old samples: %v", err)
+		}
+	}
+}
+
+// Run writes the aggregates every interval until ctx is cancelled,
+// then writes the last partial interval and closes the Store
+func (r *Recorder) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			r.Flush(context.Background())
+
+			if err := r.store.Close(); err != nil {
+				log.Printf("Failed closing history: %v", err)
+			}
+
+			return
+		case <-ticker.C:
+			r.Flush(ctx)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package history
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"fmt"
+	"net/netip"
+	"slices"
+	"time"
+
+	"github.com/pouriyajamshidi/flat/internal/stats"
+
+	// Registers the pure Go sqlite database/sql driver, which unlike
+	// the cgo one builds with CGO_ENABLED=0 and static binaries
+	_ "modernc.org/sqlite"
+)
+
+// schema creates the tables on first use. Aggregates keep a sketch of their
+// latencies so that percentiles can be computed over any range of intervals,
+// and the most common columns for querying the file with sqlite3 directly
+const schema = `
+CREATE TABLE IF NOT EXISTS aggregates (
+	start_ns  INTEGER NOT NULL,
+	end_ns    INTEGER NOT NULL,
+	interface TEXT    NOT NULL,
+	protocol  INTEGER NOT NULL,
+	ip        TEXT    NOT NULL,
+	port      INTEGER NOT NULL,
+	count     INTEGER NOT NULL,
+	timeouts  INTEGER NOT NULL,
+	refused   INTEGER NOT NULL,
+	min_ns    INTEGER NOT NULL,
+	mean_ns   INTEGER NOT NULL,
+	p50_ns    INTEGER NOT NULL,
+	p90_ns    INTEGER NOT NULL,
+	p99_ns    INTEGER NOT NULL,
+	max_ns    INTEGER NOT NULL,
+	sketch    BLOB    NOT NULL
+);
+CREATE INDEX IF NOT EXISTS aggregates_start ON aggregates (start_ns);
+
+CREATE TABLE IF NOT EXISTS samples (
+	time_ns    INTEGER NOT NULL,
+	interface  TEXT    NOT NULL,
+	protocol   INTEGER NOT NULL,
+	src_ip     TEXT    NOT NULL,
+	src_port   INTEGER NOT NULL,
+	ip         TEXT    NOT NULL,
+	port       INTEGER NOT NULL,
+	latency_ns INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS samples_time ON samples (time_ns);
+`
+
+// Aggregate holds the results of a destination over an interval
+type Aggregate struct {
+	Start       time.Time
+	End         time.Time
+	Interface   string
+	Destination stats.Destination
+	Timeouts    uint64
+	Refused     uint64
+	Sketch      *stats.Sketch // latencies in nanoseconds
+}
+
+// Sample is a single latency
+type Sample struct {
+	Time        time.Time
+	Interface   string
+	Client      netip.AddrPort
+	Destination stats.Destination
+	Latency     time.Duration
+}
+
+// Store is a SQLite file holding the aggregates and samples
+type Store struct {
+	db *sql.DB
+}
+
+// Open opens or creates the SQLite file at path
+func Open(path string) (*Store, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("creating tables: %w", err)
+	}
+
+	return &Store{db: db}, nil
+}
+
+// Close closes the file
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Write stores aggregates and samples in a single transaction
+func (s *Store) Write(ctx context.Context, aggregates []Aggregate, samples []Sample) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, a := range aggregates {
+		sketch, err := a.Sketch.MarshalBinary()
+		if err != nil {
+			return err
+		}
+
+		summary := stats.Summarize(a.Sketch)
+
+		_, err = tx.ExecContext(ctx, `INSERT INTO aggregates VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			a.Start.UnixNano(), a.End.UnixNano(), a.Interface,
+			a.Destination.Protocol, a.Destination.IP.Unmap().String(), a.Destination.Port,
+			summary.Count, a.Timeouts, a.Refused,
+			int64(summary.Min), int64(summary.Mean), int64(summary.P50), int64(summary.P90), int64(summary.P99), int64(summary.Max),
+			sketch,
+		)
+		if err != nil {
+			return fmt.Errorf("writing aggregate: %w", err)
+		}
+	}
+
+	for _, sample := range samples {
+		_, err = tx.ExecContext(ctx, `INSERT INTO samples VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			sample.Time.UnixNano(), sample.Interface, sample.Destination.Protocol,
+			sample.Client.Addr().Unmap().String(), sample.Client.Port(),
+			sample.Destination.IP.Unmap().String(), sample.Destination.Port,
+			int64(sample.Latency),
+		)
+		if err != nil {
+			return fmt.Errorf("writing sample: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DeleteSamples removes the samples older than before and returns how many there were
+func (s *Store) DeleteSamples(ctx context.Context, before time.Time) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM samples WHERE time_ns < ?`, before.UnixNano())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// Filter selects the aggregates of a query. Zero fields match everything
+type Filter struct {
+	From      time.Time
+	To        time.Time
+	Prefix    netip.Prefix // of the destination IP
+	Port      uint16
+	Protocol  uint8
+	Interface string
+	// Step splits the range into buckets of this length, or not at all when zero
+	Step time.Duration
+}
+
+// Row is the merged aggregates of a destination over a bucket of a query
+type Row struct {
+	Start       time.Time // of the bucket
+	Interface   string
+	Destination stats.Destination
+	Timeouts    uint64
+	Refused     uint64
+	Sketch      *stats.Sketch // latencies in nanoseconds
+}
+
+type rowKey struct {
+	start       int64
+	iface       string
+	destination stats.Destination
+}
+
+// Query merges the aggregates whose interval overlaps the range of the filter
+// per bucket, interface and destination. Buckets are in order and their
+// destinations busiest first
+func (s *Store) Query(ctx context.Context, filter Filter) ([]Row, error) {
+	query := `SELECT start_ns, interface, protocol, ip, port, timeouts, refused, sketch
+		FROM aggregates WHERE end_ns > ? AND start_ns < ?`
+	args := []any{filter.From.UnixNano(), filter.To.UnixNano()}
+
+	if filter.Port != 0 {
+		query += ` AND port = ?`
+		args = append(args, filter.Port)
+	}
+
+	if filter.Protocol != 0 {
+		query += ` AND protocol = ?`
+		args = append(args, filter.Protocol)
+	}
+
+	if filter.Interface != "" {
+		query += ` AND interface = ?`
+		args = append(args, filter.Interface)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query+` ORDER BY start_ns`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var merged []*Row
+
+	index := make(map[rowKey]*Row)
+
+	for rows.Next() {
+		var start int64
+		var iface, ip string
+		var protocol uint8
+		var port uint16
+		var timeouts, refused uint64
+		var blob []byte
+
+		if err := rows.Scan(&start, &iface, &protocol, &ip, &port, &timeouts, &refused, &blob); err != nil {
+			return nil, err
+		}
+
+		addr, err := netip.ParseAddr(ip)
+		if err != nil {
+			return nil, fmt.Errorf("parsing stored IP %q: %w", ip, err)
+		}
+
+		if filter.Prefix.IsValid() && !filter.Prefix.Contains(addr) {
+			continue
+		}
+
+		var sketch stats.Sketch
+
+		if err := sketch.UnmarshalBinary(blob); err != nil {
+			return nil, fmt.Errorf("decoding stored sketch: %w", err)
+		}
+
+		bucket := filter.From.UnixNano()
+
+		// An interval that started before the range counts towards its first bucket
+		if filter.Step > 0 {
+			bucket += max(start-bucket, 0) / int64(filter.Step) * int64(filter.Step)
+		}
+
+		key := rowKey{start: bucket, iface: iface, destination: stats.Destination{IP: addr, Port: port, Protocol: protocol}}
+		row, ok := index[key]
+
+		if !ok {
+			row = &Row{
+				Start:       time.Unix(0, bucket),
+				Interface:   iface,
+				Destination: key.destination,
+				Sketch:      stats.NewSketch(stats.DefaultRelativeAccuracy),
+			}
+
+			index[key] = row
+			merged = append(merged, row)
+		}
+
+		row.Timeouts += timeouts
+		row.Refused += refused
+		row.Sketch.Merge(&sketch)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result := make([]Row, len(merged))
+
+	for i, row := range merged {
+		result[i] = *row
+	}
+
+	slices.SortStableFunc(result, func(a, b Row) int {
+		return cmp.Or(a.Start.Compare(b.Start), cmp.Compare(b.Sketch.Count(), a.Sketch.Count()))
+	})
+
+	return result, nil
+}
@@ -0,0 +1,285 @@
+package ipfix
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pouriyajamshidi/flat/internal/baseline"
+	"github.com/pouriyajamshidi/flat/internal/packet"
+	"github.com/pouriyajamshidi/flat/internal/timer"
+)
+
+const (
+	// maxMessageSize keeps a message within a 1500 bytes MTU
+	// once the IPv6 and UDP headers are added
+	maxMessageSize = 1432
+
+	flushInterval = time.Second
+	dialTimeout   = time.Second * 5
+
+	// writeTimeout bounds how long a collector that stopped reading
+	// holds up the results handed to the Exporter
+	writeTimeout = time.Second
+)
+
+// DefaultTemplateRefresh is how often the templates are resent over UDP
+const DefaultTemplateRefresh = time.Minute
+
+// Config holds the IPFIX collector address and options
+type Config struct {
+	// Addr is the host:port of the collector
+	Addr string
+	// Transport is udp or tcp
+	Transport string
+	// TemplateRefresh is how often the templates are resent over UDP.
+	// Over TCP they are sent once per connection
+	TemplateRefresh time.Duration
+	// EnterpriseNumber scopes flat's own information elements, which
+	// are only exported when it is set
+	EnterpriseNumber uint32
+}
+
+// Exporter sends an IPFIX data record per answered request to a collector.
+// Timeouts and refusals have no latency and are not exported
+type Exporter struct {
+	config    Config
+	iface     int
+	boot      time.Time // wall clock time of the monotonic clock's zero
+	now       func() time.Time
+	templates []template
+
+	mu            sync.Mutex
+	conn          net.Conn
+	sequence      uint32            // data records sent so far
+	pending       map[uint16][]byte // encoded records waiting to be sent, per template
+	size          int               // size of the message holding the pending records
+	templatesSent time.Time         // zero when the templates are due
+	dropped       int               // records dropped while disconnected
+}
+
+// New constructs a new Exporter of the flows seen on the interface
+// with the given index, which is also its observation domain
+func New(config Config, iface int) (*Exporter, error) {
+	if config.Transport != "udp" && config.Transport != "tcp" {
+		return nil, fmt.Errorf("unknown transport %q, expected udp or tcp", config.Transport)
+	}
+
+	if config.TemplateRefresh <= 0 {
+		config.TemplateRefresh = DefaultTemplateRefresh
+	}
+
+	e := &Exporter{
+		config:    config,
+		iface:     iface,
+		boot:      timer.BootTime(),
+		now:       time.Now,
+		templates: templates(config.EnterpriseNumber),
+		pending:   make(map[uint16][]byte),
+	}
+
+	conn, err := net.DialTimeout(config.Transport, config.Addr, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	e.conn = conn
+
+	return e, nil
+}
+
+// reconnect opens a new connection to the collector if the last one failed.
+// It dials without holding the lock, so that results keep being handed
+// to the Exporter, and dropped, while the collector is unreachable
+func (e *Exporter) reconnect() {
+	e.mu.Lock()
+	connected := e.conn != nil
+	e.mu.Unlock()
+
+	if connected {
+		return
+	}
+
+	conn, err := net.DialTimeout(e.config.Transport, e.config.Addr, dialTimeout)
+	if err != nil {
+		log.Printf("Failed connecting to the IPFIX collector: %v", err)
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// The collector needs the templates before any data record
+	e.conn = conn
+	e.templatesSent = time.Time{}
+
+	if e.dropped > 0 {
+		log.Printf("Dropped %d records while disconnected from the IPFIX collector", e.dropped)
+		e.dropped = 0
+	}
+}
+
+// templatesDue tells whether the next message must carry the templates
+func (e *Exporter) templatesDue(now time.Time) bool {
+	if e.templatesSent.IsZero() {
+		return true
+	}
+
+	return e.config.Transport == "udp" && now.Sub(e.templatesSent) >= e.config.TemplateRefresh
+}
+
+func (e *Exporter) templateSetSize() int {
+	return len(appendTemplateSet(nil, e.templates))
+}
+
+// Latency satisfies the output.Sink interface
+func (e *Exporter) Latency(result packet.Result) {
+	record := appendRecord(nil, result, e.iface, e.boot, e.config.EnterpriseNumber)
+	id := templateID(result.Request)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.size == 0 {
+		e.size = headerSize + e.templateSetSize()
+	}
+
+	grow := len(record)
+
+	if len(e.pending[id]) == 0 {
+		grow += setHeaderSize
+	}
+
+	if e.size+grow > maxMessageSize {
+		e.flush()
+
+		e.size = headerSize + e.templateSetSize()
+		grow = setHeaderSize + len(record)
+	}
+
+	e.pending[id] = append(e.pending[id], record...)
+	e.size += grow
+}
+
+// Timeout satisfies the output.Sink interface, timeouts are not exported
+func (e *Exporter) Timeout(packet.Packet) {}
+
+// Refused satisfies the output.Sink interface, refusals are not exported
+func (e *Exporter) Refused(packet.Packet) {}
+
+// Anomaly satisfies the output.Sink interface, anomalies are not exported
+func (e *Exporter) Anomaly(packet.Result, baseline.Anomaly) {}
+
+// flush sends the pending records, and the templates when they are due,
+// in a single message. The records are dropped while disconnected, Run
+// connects again. Callers must hold the lock
+func (e *Exporter) flush() {
+	now := e.now()
+	due := e.templatesDue(now)
+
+	records := 0
+
+	for _, t := range e.templates {
+		records += len(e.pending[t.id]) / recordSize(t)
+	}
+
+	if records == 0 && !due {
+		return
+	}
+
+	if e.conn == nil {
+		e.dropped += records
+		e.clear()
+		return
+	}
+
+	msg := appendHeader(make([]byte, 0, maxMessageSize), now, e.sequence, uint32(e.iface))
+
+	if due {
+		msg = appendTemplateSet(msg, e.templates)
+	}
+
+	for _, t := range e.templates {
+		if len(e.pending[t.id]) > 0 {
+			msg = appendDataSet(msg, t.id, e.pending[t.id])
+		}
+	}
+
+	e.clear()
+
+	e.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+
+	if _, err := e.conn.Write(finishMessage(msg)); err != nil {
+		log.Printf("Failed sending to the IPFIX collector: %v", err)
+
+		// A stream that failed or timed out part way through a message
+		// cannot be resumed, Run connects again
+		if e.config.Transport == "tcp" {
+			e.conn.Close()
+			e.conn = nil
+		}
+
+		return
+	}
+
+	e.sequence += uint32(records)
+
+	if due {
+		e.templatesSent = now
+	}
+}
+
+func (e *Exporter) clear() {
+	for id := range e.pending {
+		e.pending[id] = e.pending[id][:0]
+	}
+
+	e.size = 0
+}
+
+func recordSize(t template) int {
+	size := 0
+
+	for _, f := range t.fields {
+		size += int(f.length)
+	}
+
+	return size
+}
+
+// Flush sends the pending records
+func (e *Exporter) Flush() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.flush()
+}
+
+// Run sends the pending records every second, connecting again
+// after a connection failed, until ctx is cancelled
+func (e *Exporter) Run(ctx context.Context) {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			e.mu.Lock()
+			defer e.mu.Unlock()
+
+			e.flush()
+
+			if e.conn != nil {
+				e.conn.Close()
+			}
+
+			return
+		case <-ticker.C:
+			e.reconnect()
+			e.Flush()
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package ipfix
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/pouriyajamshidi/flat/internal/packet"
+)
+
+// Version is the IPFIX version number of every message header
+const Version = 10
+
+const (
+	headerSize    = 16
+	setHeaderSize = 4
+
+	templateSetID = 2
+
+	// Template IDs of the flows between IPv4 and IPv6 addresses
+	templateIPv4 = 256
+	templateIPv6 = 257
+
+	enterpriseBit = 0x8000
+)
+
+// Information elements of the IANA IPFIX registry
+const (
+	protocolIdentifier       = 4
+	sourceTransportPort      = 7
+	sourceIPv4Address        = 8
+	ingressInterface         = 10
+	destinationTransportPort = 11
+	destinationIPv4Address   = 12
+	sourceIPv6Address        = 27
+	destinationIPv6Address   = 28
+	flowStartMilliseconds    = 152
+	flowEndMilliseconds      = 153
+	flowDurationMicroseconds = 162
+	ipTTL                    = 192
+)
+
+// Information elements of flat, scoped to the configured enterprise number
+const (
+	// latencyNanoseconds is the handshake RTT or UDP response time
+	latencyNanoseconds = 1
+)
+
+// field is an information element of a template
+type field struct {
+	id         uint16
+	length     uint16
+	enterprise uint32 // zero for the IANA elements
+}
+
+type template struct {
+	id     uint16
+	fields []field
+}
+
+// templates returns the IPv4 and IPv6 templates, which carry
+// flat's own elements when an enterprise number is set
+func templates(enterprise uint32) []template {
+	build := func(id uint16, src, dst uint16, addrLength uint16) template {
+		fields := []field{
+			{id: src, length: addrLength},
+			{id: dst, length: addrLength},
+			{id: sourceTransportPort, length: 2},
+			{id: destinationTransportPort, length: 2},
+			{id: protocolIdentifier, length: 1},
+			{id: ipTTL, length: 1},
+			{id: ingressInterface, length: 4},
+			{id: flowStartMilliseconds, length: 8},
+			{id: flowEndMilliseconds, length: 8},
+			{id: flowDurationMicroseconds, length: 4},
+		}
+
+		if enterprise != 0 {
+			fields = append(fields, field{id: latencyNanoseconds, length: 8, enterprise: enterprise})
+		}
+
+		return template{id: id, fields: fields}
+	}
+
+	return []template{
+		build(templateIPv4, sourceIPv4Address, destinationIPv4Address, 4),
+		build(templateIPv6, sourceIPv6Address, destinationIPv6Address, 16),
+	}
+}
+
+// appendTemplateSet encodes a template set holding every template
+func appendTemplateSet(b []byte, templates []template) []byte {
+	start := len(b)
+	b = binary.BigEndian.AppendUint16(b, templateSetID)
+	b = binary.BigEndian.AppendUint16(b, 0) // set length, filled in below
+
+	for _, t := range templates {
+		b = binary.BigEndian.AppendUint16(b, t.id)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(t.fields)))
+
+		for _, f := range t.fields {
+			if f.enterprise == 0 {
+				b = binary.BigEndian.AppendUint16(b, f.id)
+				b = binary.BigEndian.AppendUint16(b, f.length)
+				continue
+			}
+
+			b = binary.BigEndian.AppendUint16(b, f.id|enterpriseBit)
+			b = binary.BigEndian.AppendUint16(b, f.length)
+			b = binary.BigEndian.AppendUint32(b, f.enterprise)
+		}
+	}
+
+	binary.BigEndian.PutUint16(b[start+2:], uint16(len(b)-start))
+
+	return b
+}
+
+// appendDataSet encodes a data set of already encoded records
+func appendDataSet(b []byte, templateID uint16, records []byte) []byte {
+	b = binary.BigEndian.AppendUint16(b, templateID)
+	b = binary.BigEndian.AppendUint16(b, uint16(setHeaderSize+len(records)))
+
+	return append(b, records...)
+}
+
+// appendHeader encodes a message header, whose length is filled in by finishMessage
+func appendHeader(b []byte, exportTime time.Time, sequence, domain uint32) []byte {
+	b = binary.BigEndian.AppendUint16(b, Version)
+	b = binary.BigEndian.AppendUint16(b, 0)
+	b = binary.BigEndian.AppendUint32(b, uint32(exportTime.Unix()))
+	b = binary.BigEndian.AppendUint32(b, sequence)
+
+	return binary.BigEndian.AppendUint32(b, domain)
+}
+
+func finishMessage(b []byte) []byte {
+	binary.BigEndian.PutUint16(b[2:], uint16(len(b)))
+	return b
+}
+
+// templateID returns the template of the flow of a request
+func templateID(request packet.Packet) uint16 {
+	if request.SrcIP.Unmap().Is4() && request.DstIP.Unmap().Is4() {
+		return templateIPv4
+	}
+
+	return templateIPv6
+}
+
+func unixMillis(t time.Time) uint64 {
+	return uint64(t.UnixMilli())
+}
+
+// appendRecord encodes the data record of an answered request, in the field
+// order of its template. boot is the wall clock time of the packet timestamps
+func appendRecord(b []byte, result packet.Result, iface int, boot time.Time, enterprise uint32) []byte {
+	request, reply := result.Request, result.Reply
+
+	if templateID(request) == templateIPv4 {
+		src, dst := request.SrcIP.Unmap().As4(), request.DstIP.Unmap().As4()
+		b = append(b, src[:]...)
+		b = append(b, dst[:]...)
+	} else {
+		src, dst := request.SrcIP.As16(), request.DstIP.As16()
+		b = append(b, src[:]...)
+		b = append(b, dst[:]...)
+	}
+
+	b = binary.BigEndian.AppendUint16(b, request.SrcPort)
+	b = binary.BigEndian.AppendUint16(b, request.DstPort)
+	b = append(b, request.Protocol, reply.TTL)
+	b = binary.BigEndian.AppendUint32(b, uint32(iface))
+	b = binary.BigEndian.AppendUint64(b, unixMillis(boot.Add(time.Duration(request.TimeStamp))))
+	b = binary.BigEndian.AppendUint64(b, unixMillis(boot.Add(time.Duration(reply.TimeStamp))))
+	b = binary.BigEndian.AppendUint32(b, uint32(min(result.Latency.Microseconds(), 1<<32-1)))
+
+	if enterprise != 0 {
+		b = binary.BigEndian.AppendUint64(b, uint64(result.Latency))
+	}
+
+	return b
+}
@@ -0,0 +1,341 @@
+package ipfix
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/pouriyajamshidi/flat/internal/packet"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	requestV4 = packet.Packet{
+		SrcIP:     netip.MustParseAddr("::ffff:192.168.0.156"),
+		DstIP:     netip.MustParseAddr("::ffff:1.1.1.1"),
+		SrcPort:   53264,
+		DstPort:   443,
+		Protocol:  6,
+		TTL:       64,
+		Syn:       true,
+		TimeStamp: 5_000_000_000,
+	}
+
+	requestV6 = packet.Packet{
+		SrcIP:     netip.MustParseAddr("2001:db8::1"),
+		DstIP:     netip.MustParseAddr("2606:4700::1111"),
+		SrcPort:   40000,
+		DstPort:   53,
+		Protocol:  17,
+		TTL:       64,
+		TimeStamp: 5_000_000_000,
+	}
+)
+
+// answered builds the Result of a request answered after latency
+func answered(request packet.Packet, latency time.Duration) packet.Result {
+	reply := request.Reverse()
+	reply.TTL = 57
+	reply.TimeStamp = request.TimeStamp + uint64(latency)
+
+	return packet.Result{Request: request, Reply: reply, Latency: latency}
+}
+
+// message is an IPFIX message as decoded by the collector
+type message struct {
+	sequence  uint32
+	domain    uint32
+	templates []uint16
+	records   []map[uint16][]byte // data records by element ID, enterprise elements included
+}
+
+// collector decodes IPFIX messages the way a collector would,
+// learning the templates before it can read the data records
+type collector struct {
+	t         *testing.T
+	templates map[uint16][]field
+}
+
+func newCollector(t *testing.T) *collector {
+	return &collector{t: t, templates: make(map[uint16][]field)}
+}
+
+func (c *collector) decode(b []byte) message {
+	t := c.t
+
+	require.GreaterOrEqual(t, len(b), headerSize)
+	require.Equal(t, uint16(Version), binary.BigEndian.Uint16(b))
+	require.Equal(t, len(b), int(binary.BigEndian.Uint16(b[2:])))
+
+	msg := message{
+		sequence: binary.BigEndian.Uint32(b[8:]),
+		domain:   binary.BigEndian.Uint32(b[12:]),
+	}
+
+	for sets := b[headerSize:]; len(sets) > 0; {
+		id, length := binary.BigEndian.Uint16(sets), int(binary.BigEndian.Uint16(sets[2:]))
+		require.LessOrEqual(t, length, len(sets))
+
+		body := sets[setHeaderSize:length]
+		sets = sets[length:]
+
+		if id == templateSetID {
+			for len(body) > 0 {
+				templateID, count := binary.BigEndian.Uint16(body), int(binary.BigEndian.Uint16(body[2:]))
+				body = body[4:]
+
+				var fields []field
+
+				for range count {
+					f := field{id: binary.BigEndian.Uint16(body), length: binary.BigEndian.Uint16(body[2:])}
+					body = body[4:]
+
+					if f.id&enterpriseBit != 0 {
+						f.id &^= enterpriseBit
+						f.enterprise = binary.BigEndian.Uint32(body)
+						body = body[4:]
+					}
+
+					fields = append(fields, f)
+				}
+
+				c.templates[templateID] = fields
+				msg.templates = append(msg.templates, templateID)
+			}
+
+			continue
+		}
+
+		fields, ok := c.templates[id]
+		require.True(t, ok, "data set %d arrived before its template", id)
+
+		for len(body) > 0 {
+			record := make(map[uint16][]byte)
+
+			for _, f := range fields {
+				record[f.id] = body[:f.length]
+				body = body[f.length:]
+			}
+
+			msg.records = append(msg.records, record)
+		}
+	}
+
+	return msg
+}
+
+func receive(t *testing.T, conn net.PacketConn) []byte {
+	buf := make([]byte, 65536)
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+
+	n, _, err := conn.ReadFrom(buf)
+	require.NoError(t, err)
+
+	return buf[:n]
+}
+
+func TestExportUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	exporter, err := New(Config{Addr: conn.LocalAddr().String(), Transport: "udp", EnterpriseNumber: 4242}, 2)
+	require.NoError(t, err)
+
+	exporter.boot = time.Unix(1700000000, 0)
+
+	exporter.Latency(answered(requestV4, 12*time.Millisecond))
+	exporter.Latency(answered(requestV6, 1500*time.Microsecond))
+	exporter.Timeout(requestV4)
+	exporter.Flush()
+
+	c := newCollector(t)
+	msg := c.decode(receive(t, conn))
+
+	// The first message carries the templates ahead of the records
+	require.Equal(t, []uint16{templateIPv4, templateIPv6}, msg.templates)
+	require.Equal(t, uint32(0), msg.sequence)
+	require.Equal(t, uint32(2), msg.domain)
+	require.Len(t, msg.records, 2)
+
+	v4 := msg.records[0]
+	require.Equal(t, []byte{192, 168, 0, 156}, v4[sourceIPv4Address])
+	require.Equal(t, []byte{1, 1, 1, 1}, v4[destinationIPv4Address])
+	require.Equal(t, uint16(53264), binary.BigEndian.Uint16(v4[sourceTransportPort]))
+	require.Equal(t, uint16(443), binary.BigEndian.Uint16(v4[destinationTransportPort]))
+	require.Equal(t, []byte{6}, v4[protocolIdentifier])
+	require.Equal(t, []byte{57}, v4[ipTTL])
+	require.Equal(t, uint32(2), binary.BigEndian.Uint32(v4[ingressInterface]))
+	require.Equal(t, uint64(1700000005000), binary.BigEndian.Uint64(v4[flowStartMilliseconds]))
+	require.Equal(t, uint64(1700000005012), binary.BigEndian.Uint64(v4[flowEndMilliseconds]))
+	require.Equal(t, uint32(12000), binary.BigEndian.Uint32(v4[flowDurationMicroseconds]))
+	require.Equal(t, uint64(12_000_000), binary.BigEndian.Uint64(v4[latencyNanoseconds]))
+	require.Equal(t, uint32(4242), c.templates[templateIPv4][len(c.templates[templateIPv4])-1].enterprise)
+
+	v6 := msg.records[1]
+	require.Equal(t, netip.MustParseAddr("2606:4700::1111").AsSlice(), v6[destinationIPv6Address])
+	require.Equal(t, []byte{17}, v6[protocolIdentifier])
+	require.Equal(t, uint32(1500), binary.BigEndian.Uint32(v6[flowDurationMicroseconds]))
+
+	// The next message continues the sequence without the templates
+	exporter.Latency(answered(requestV4, time.Millisecond))
+	exporter.Flush()
+
+	msg = c.decode(receive(t, conn))
+	require.Empty(t, msg.templates)
+	require.Equal(t, uint32(2), msg.sequence)
+	require.Len(t, msg.records, 1)
+
+	// Until it is time to refresh them
+	now := time.Now().Add(DefaultTemplateRefresh)
+	exporter.now = func() time.Time { return now }
+	exporter.Flush()
+
+	msg = c.decode(receive(t, conn))
+	require.Equal(t, []uint16{templateIPv4, templateIPv6}, msg.templates)
+	require.Empty(t, msg.records)
+	require.Equal(t, uint32(3), msg.sequence)
+}
+
+func TestExportBatching(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	exporter, err := New(Config{Addr: conn.LocalAddr().String(), Transport: "udp"}, 2)
+	require.NoError(t, err)
+
+	const records = 200
+
+	for range records {
+		exporter.Latency(answered(requestV4, time.Millisecond))
+	}
+
+	exporter.Flush()
+
+	c := newCollector(t)
+	received := uint32(0)
+
+	for received < records {
+		b := receive(t, conn)
+		require.LessOrEqual(t, len(b), maxMessageSize)
+
+		msg := c.decode(b)
+		require.Equal(t, received, msg.sequence)
+
+		received += uint32(len(msg.records))
+	}
+
+	require.Equal(t, uint32(records), received)
+}
+
+func TestExportTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	exporter, err := New(Config{Addr: listener.Addr().String(), Transport: "tcp"}, 2)
+	require.NoError(t, err)
+
+	read := func(conn net.Conn) []byte {
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+
+		header := make([]byte, headerSize)
+		_, err := io.ReadFull(conn, header)
+		require.NoError(t, err)
+
+		b := make([]byte, binary.BigEndian.Uint16(header[2:]))
+		copy(b, header)
+
+		_, err = io.ReadFull(conn, b[headerSize:])
+		require.NoError(t, err)
+
+		return b
+	}
+
+	conn, err := listener.Accept()
+	require.NoError(t, err)
+
+	exporter.Latency(answered(requestV4, time.Millisecond))
+	exporter.Flush()
+	exporter.Latency(answered(requestV4, time.Millisecond))
+	exporter.Flush()
+
+	c := newCollector(t)
+
+	// Templates are sent once per connection, whatever the refresh interval
+	msg := c.decode(read(conn))
+	require.Len(t, msg.templates, 2)
+	require.Len(t, msg.records, 1)
+
+	msg = c.decode(read(conn))
+	require.Empty(t, msg.templates)
+	require.Len(t, msg.records, 1)
+
+	// Once the collector goes away, records are dropped until the exporter
+	// connects again and starts the new connection with the templates
+	conn.Close()
+
+	require.Eventually(t, func() bool {
+		exporter.Latency(answered(requestV4, time.Millisecond))
+		exporter.Flush()
+
+		exporter.mu.Lock()
+		defer exporter.mu.Unlock()
+
+		return exporter.conn == nil
+	}, time.Second*5, time.Millisecond*10)
+
+	exporter.Latency(answered(requestV4, time.Millisecond))
+	exporter.Flush()
+
+	exporter.reconnect()
+
+	exporter.Latency(answered(requestV4, time.Millisecond))
+	exporter.Flush()
+
+	conn, err = listener.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	msg = newCollector(t).decode(read(conn))
+	require.Len(t, msg.templates, 2)
+	require.Len(t, msg.records, 1)
+}
+
+func TestExportTCPStalled(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	exporter, err := New(Config{Addr: listener.Addr().String(), Transport: "tcp"}, 2)
+	require.NoError(t, err)
+
+	// The collector accepts the connection but never reads from it
+	conn, err := listener.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.NoError(t, conn.(*net.TCPConn).SetReadBuffer(4096))
+
+	// Once the socket buffers are full, the write times out
+	// instead of blocking, and the connection is given up
+	for i := 0; exporter.conn != nil; i++ {
+		require.Less(t, i, 100_000)
+
+		for range 20 {
+			exporter.Latency(answered(requestV4, time.Millisecond))
+		}
+
+		exporter.Flush()
+	}
+}
+
+func TestUnknownTransport(t *testing.T) {
+	_, err := New(Config{Addr: "127.0.0.1:4739", Transport: "sctp"}, 2)
+	require.Error(t, err)
+}
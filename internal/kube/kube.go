@@ -0,0 +1,226 @@
+package kube
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// Metadata holds the Kubernetes identity of an IP address
+type Metadata struct {
+	Namespace string
+	Pod       string
+	Service   string
+}
+
+// IsZero reports whether no Kubernetes identity is known
+func (meta Metadata) IsZero() bool {
+	return meta == Metadata{}
+}
+
+// String formats the metadata as namespace/pod, namespace/service
+// or namespace/pod (service) when both are known
+func (meta Metadata) String() string {
+	switch {
+	case meta.IsZero():
+		return ""
+	case meta.Pod != "" && meta.Service != "":
+		return meta.Namespace + "/" + meta.Pod + " (" + meta.Service + ")"
+	case meta.Pod != "":
+		return meta.Namespace + "/" + meta.Pod
+	default:
+		return meta.Namespace + "/" + meta.Service
+	}
+}
+
+// MatchesPod reports whether the metadata belongs to the given namespace/pod
+func (meta Metadata) MatchesPod(name string) bool {
+	return meta.Pod != "" && meta.Namespace+"/"+meta.Pod == name
+}
+
+// MatchesService reports whether the metadata belongs to the given namespace/service
+func (meta Metadata) MatchesService(name string) bool {
+	return meta.Service != "" && meta.Namespace+"/"+meta.Service == name
+}
+
+// object is the subset of a Kubernetes object that is needed for enrichment.
+// It understands Pods, Services and Endpoints as produced by
+// kubectl get pods,services,endpoints -A -o json
+type object struct {
+	Kind     string `json:"kind"`
+	Metadata struct {
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+	} `json:"metadata"`
+	Spec struct {
+		ClusterIP  string   `json:"clusterIP"`
+		ClusterIPs []string `json:"clusterIPs"`
+	} `json:"spec"`
+	Status struct {
+		PodIP  string `json:"podIP"`
+		PodIPs []struct {
+			IP string `json:"ip"`
+		} `json:"podIPs"`
+	} `json:"status"`
+	Subsets []struct {
+		Addresses []struct {
+			IP string `json:"ip"`
+		} `json:"addresses"`
+	} `json:"subsets"`
+}
+
+type objectList struct {
+	Items []object `json:"items"`
+}
+
+// Store maps IP addresses to Kubernetes metadata read from a snapshot file
+type Store struct {
+	path     string
+	interval time.Duration
+	modTime  time.Time
+	ips      atomic.Pointer[map[netip.Addr]Metadata]
+}
+
+// NewStore constructs a new Store and performs the initial snapshot load
+func NewStore(path string, interval time.Duration) (*Store, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("refresh interval must be positive, got %v", interval)
+	}
+
+	store := &Store{path: path, interval: interval}
+
+	if err := store.Load(); err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// Lookup returns the Kubernetes metadata for an IP address
+func (store *Store) Lookup(ip netip.Addr) (Metadata, bool) {
+	ips := store.ips.Load()
+
+	if ips == nil {
+		return Metadata{}, false
+	}
+
+	meta, ok := (*ips)[ip.Unmap()]
+
+	return meta, ok
+}
+
+// Load reads the snapshot file if it has changed since the last load
+func (store *Store) Load() error {
+	info, err := os.Stat(store.path)
+
+	if err != nil {
+		return err
+	}
+
+	if info.ModTime().Equal(store.modTime) {
+		return nil
+	}
+
+	data, err := os.ReadFile(store.path)
+
+	if err != nil {
+		return err
+	}
+
+	ips, err := parseSnapshot(data)
+
+	if err != nil {
+		return err
+	}
+
+	store.modTime = info.ModTime()
+	store.ips.Store(&ips)
+
+	log.Printf("Loaded %d Kubernetes addresses from %v", len(ips), store.path)
+
+	return nil
+}
+
+// Run periodically reloads the snapshot file until ctx is cancelled
+func (store *Store) Run(ctx context.Context) {
+	ticker := time.NewTicker(store.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := store.Load(); err != nil {
+				log.Printf("Failed reloading Kubernetes snapshot: %v", err)
+			}
+		}
+	}
+}
+
+// parseSnapshot builds the IP to metadata table from a Kubernetes List
+func parseSnapshot(data []byte) (map[netip.Addr]Metadata, error) {
+	var list objectList
+
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+
+	ips := make(map[netip.Addr]Metadata)
+
+	update := func(value string, fn func(meta *Metadata)) {
+		ip, err := netip.ParseAddr(value)
+
+		if err != nil {
+			return
+		}
+
+		meta := ips[ip.Unmap()]
+		fn(&meta)
+		ips[ip.Unmap()] = meta
+	}
+
+	for _, item := range list.Items {
+		namespace := item.Metadata.Namespace
+		name := item.Metadata.Name
+
+		switch item.Kind {
+		case "Pod":
+			setPod := func(meta *Metadata) {
+				meta.Namespace = namespace
+				meta.Pod = name
+			}
+
+			update(item.Status.PodIP, setPod)
+
+			for _, podIP := range item.Status.PodIPs {
+				update(podIP.IP, setPod)
+			}
+
+		case "Service", "Endpoints":
+			setService := func(meta *Metadata) {
+				meta.Namespace = namespace
+				meta.Service = name
+			}
+
+			update(item.Spec.ClusterIP, setService)
+
+			for _, clusterIP := range item.Spec.ClusterIPs {
+				update(clusterIP, setService)
+			}
+
+			for _, subset := range item.Subsets {
+				for _, address := range subset.Addresses {
+					update(address.IP, setService)
+				}
+			}
+		}
+	}
+
+	return ips, nil
+}
@@ -0,0 +1,73 @@
+package kube
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const snapshot = `{
+	"apiVersion": "v1",
+	"kind": "List",
+	"items": [
+		{
+			"kind": "Pod",
+			"metadata": {"name": "web-7d4f", "namespace": "shop"},
+			"status": {"podIP": "10.244.1.5", "podIPs": [{"ip": "10.244.1.5"}, {"ip": "fd00::5"}]}
+		},
+		{
+			"kind": "Service",
+			"metadata": {"name": "web", "namespace": "shop"},
+			"spec": {"clusterIP": "10.96.0.20", "clusterIPs": ["10.96.0.20"]}
+		},
+		{
+			"kind": "Endpoints",
+			"metadata": {"name": "web", "namespace": "shop"},
+			"subsets": [{"addresses": [{"ip": "10.244.1.5"}]}]
+		}
+	]
+}`
+
+func TestParseSnapshot(t *testing.T) {
+	ips, err := parseSnapshot([]byte(snapshot))
+	require.NoError(t, err)
+
+	require.Equal(t, Metadata{Namespace: "shop", Pod: "web-7d4f", Service: "web"}, ips[netip.MustParseAddr("10.244.1.5")])
+	require.Equal(t, Metadata{Namespace: "shop", Pod: "web-7d4f"}, ips[netip.MustParseAddr("fd00::5")])
+	require.Equal(t, Metadata{Namespace: "shop", Service: "web"}, ips[netip.MustParseAddr("10.96.0.20")])
+
+	require.Equal(t, "shop/web-7d4f (web)", ips[netip.MustParseAddr("10.244.1.5")].String())
+	require.Equal(t, "shop/web", ips[netip.MustParseAddr("10.96.0.20")].String())
+}
+
+func TestStoreReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"items": []}`), 0o644))
+
+	store, err := NewStore(path, time.Second)
+	require.NoError(t, err)
+
+	_, ok := store.Lookup(netip.MustParseAddr("::ffff:10.96.0.20"))
+	require.False(t, ok)
+
+	require.NoError(t, os.WriteFile(path, []byte(snapshot), 0o644))
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Minute)))
+	require.NoError(t, store.Load())
+
+	meta, ok := store.Lookup(netip.MustParseAddr("::ffff:10.96.0.20"))
+	require.True(t, ok)
+	require.True(t, meta.MatchesService("shop/web"))
+	require.False(t, meta.MatchesPod("shop/web"))
+}
+
+func TestStoreRefreshInterval(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"items": []}`), 0o644))
+
+	_, err := NewStore(path, 0)
+	require.Error(t, err)
+}
@@ -0,0 +1,240 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/pouriyajamshidi/flat/internal/baseline"
+	"github.com/pouriyajamshidi/flat/internal/flowtable"
+	"github.com/pouriyajamshidi/flat/internal/packet"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// otherDestination labels the destinations that are not allow-listed
+// or that exceed the maximum number of destination labels
+const otherDestination = "other"
+
+// DefaultMaxDestinations is the default bound on destination labels
+const DefaultMaxDestinations = 100
+
+// Config bounds the cardinality of the destination label
+type Config struct {
+	// Destinations allow-lists the destinations that get their own label,
+	// every destination is allowed if it is empty
+	Destinations []netip.Prefix
+	// MaxDestinations is the maximum number of distinct destination labels
+	MaxDestinations int
+}
+
+// Metrics exposes latency measurements and internal health in the Prometheus format
+type Metrics struct {
+	registry   *prometheus.Registry
+	latency    *prometheus.HistogramVec
+	handshakes *prometheus.CounterVec
+	timeouts   *prometheus.CounterVec
+	refused    *prometheus.CounterVec
+	anomalies  *prometheus.CounterVec
+	events     prometheus.Counter
+	malformed  prometheus.Counter
+
+	config       Config
+	mu           sync.Mutex
+	destinations map[netip.AddrPort]bool
+}
+
+// New constructs a new Metrics
+func New(config Config) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "flat_latency_seconds",
+			Help:    "Latency between a request and its reply.",
+			Buckets: prometheus.ExponentialBuckets(0.0001, 2, 16),
+		}, []string{"protocol", "destination"}),
+		handshakes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "flat_handshakes_total",
+			Help: "Requests that were answered, i.e. completed TCP handshakes and UDP exchanges.",
+		}, []string{"protocol"}),
+		timeouts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "flat_timeouts_total",
+			Help: "Requests that were not answered in time.",
+		}, []string{"protocol"}),
+		refused: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "flat_refused_total",
+			Help: "TCP connections that were refused with a RST.",
+		}, []string{"protocol"}),
+		anomalies: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "flat_anomalies_total",
+			Help: "Latencies that deviated from their destination's baseline.",
+		}, []string{"protocol", "destination"}),
+		events: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "flat_events_total",
+			Help: "Packets read from the eBPF ring buffer.",
+		}),
+		malformed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "flat_malformed_events_total",
+			Help: "Packets read from the eBPF ring buffer that could not be decoded.",
+		}),
+		config:       config,
+		destinations: make(map[netip.AddrPort]bool),
+	}
+
+	m.registry.MustRegister(
+		m.latency,
+		m.handshakes,
+		m.timeouts,
+		m.refused,
+		m.anomalies,
+		m.events,
+		m.malformed,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	return m
+}
+
+// destination returns the destination label of a server address
+func (m *Metrics) destination(addr netip.AddrPort) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.destinations[addr] {
+		return addr.String()
+	}
+
+	if len(m.destinations) >= m.config.MaxDestinations {
+		return otherDestination
+	}
+
+	allowed := len(m.config.Destinations) == 0
+
+	for _, prefix := range m.config.Destinations {
+		if prefix.Contains(addr.Addr()) {
+			allowed = true
+			break
+		}
+	}
+
+	if !allowed {
+		return otherDestination
+	}
+
+	m.destinations[addr] = true
+
+	return addr.String()
+}
+
+// Event counts a packet read from the ring buffer
+func (m *Metrics) Event() {
+	m.events.Inc()
+}
+
+// Malformed counts a packet that could not be decoded
+func (m *Metrics) Malformed() {
+	m.malformed.Inc()
+}
+
+// Latency records the latency of the flow completed by a reply packet
+func (m *Metrics) Latency(result packet.Result) {
+	reply, latency := result.Reply, result.Latency
+	protocol := packet.ProtocolName(reply.Protocol)
+
+	m.latency.WithLabelValues(protocol, m.destination(reply.SrcAddrPort())).Observe(latency.Seconds())
+	m.handshakes.WithLabelValues(protocol).Inc()
+}
+
+// Timeout counts a request that was not answered in time
+func (m *Metrics) Timeout(request packet.Packet) {
+	m.timeouts.WithLabelValues(packet.ProtocolName(request.Protocol)).Inc()
+}
+
+// Refused counts a TCP SYN that was answered with a RST
+func (m *Metrics) Refused(request packet.Packet) {
+	m.refused.WithLabelValues(packet.ProtocolName(request.Protocol)).Inc()
+}
+
+// Anomaly counts a latency that deviated from its destination's baseline
+func (m *Metrics) Anomaly(result packet.Result, _ baseline.Anomaly) {
+	reply := result.Reply
+	m.anomalies.WithLabelValues(packet.ProtocolName(reply.Protocol), m.destination(reply.SrcAddrPort())).Inc()
+}
+
+// RegisterFlowTable exposes the size and churn of a flow table
+func (m *Metrics) RegisterFlowTable(stats func() flowtable.Stats) {
+	m.registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "flat_flow_table_entries",
+			Help: "Requests waiting for a reply.",
+		}, func() float64 { return float64(stats().Entries) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "flat_flow_table_evictions_total",
+			Help: "Requests evicted because the flow table was full.",
+		}, func() float64 { return float64(stats().Evictions) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "flat_flow_table_expirations_total",
+			Help: "Requests expired from the flow table.",
+		}, func() float64 { return float64(stats().Expirations) }),
+	)
+}
+
+// RegisterRingbuf exposes the backlog of the eBPF ring buffer and the
+// packets dropped once it is full, which means userspace is not keeping up
+func (m *Metrics) RegisterRingbuf(pending func() int, size int, dropped func() (uint64, error)) {
+	m.registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "flat_ringbuf_pending_bytes",
+			Help: "Bytes waiting to be read from the eBPF ring buffer.",
+		}, func() float64 { return float64(pending()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "flat_ringbuf_size_bytes",
+			Help: "Size of the eBPF ring buffer.",
+		}, func() float64 { return float64(size) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "flat_ringbuf_dropped_total",
+			Help: "Packets the eBPF program dropped because the ring buffer was full.",
+		}, func() float64 {
+			count, err := dropped()
+			if err != nil {
+				log.Printf("Failed reading dropped packets: %v", err)
+			}
+
+			return float64(count)
+		}),
+	)
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Serve serves /metrics on listener until ctx is cancelled. The caller
+// binds the listener, so that an address in use fails before Serve
+func (m *Metrics) Serve(ctx context.Context, listener net.Listener) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m.Handler())
+
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: time.Second * 10}
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	log.Printf("Serving metrics on http://%v/metrics", listener.Addr())
+
+	if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
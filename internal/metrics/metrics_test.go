@@ -0,0 +1,93 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/pouriyajamshidi/flat/internal/flowtable"
+	"github.com/pouriyajamshidi/flat/internal/packet"
+	"github.com/stretchr/testify/require"
+)
+
+func reply(server string) packet.Packet {
+	addr := netip.MustParseAddrPort(server)
+
+	return packet.Packet{
+		SrcIP:    netip.AddrFrom16(addr.Addr().As16()),
+		DstIP:    netip.MustParseAddr("::ffff:192.168.0.156"),
+		SrcPort:  addr.Port(),
+		DstPort:  53264,
+		Protocol: 6,
+		Syn:      true,
+		Ack:      true,
+	}
+}
+
+func scrape(t *testing.T, m *Metrics) string {
+	server := httptest.NewServer(m.Handler())
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	return string(body)
+}
+
+func TestMetrics(t *testing.T) {
+	m := New(Config{MaxDestinations: DefaultMaxDestinations})
+
+	m.Event()
+	m.Event()
+	m.Malformed()
+	m.Latency(packet.Result{Reply: reply("1.1.1.1:443"), Latency: 12 * time.Millisecond})
+	m.Timeout(reply("1.1.1.1:443").Reverse())
+	m.Refused(reply("1.1.1.1:443").Reverse())
+
+	m.RegisterFlowTable(func() flowtable.Stats { return flowtable.Stats{Entries: 7, Evictions: 2, Expirations: 3} })
+	m.RegisterRingbuf(func() int { return 128 }, 4096, func() (uint64, error) { return 5, nil })
+
+	body := scrape(t, m)
+
+	for _, line := range []string{
+		`flat_latency_seconds_bucket{destination="1.1.1.1:443",protocol="TCP",le="0.0128"} 1`,
+		`flat_latency_seconds_count{destination="1.1.1.1:443",protocol="TCP"} 1`,
+		`flat_handshakes_total{protocol="TCP"} 1`,
+		`flat_timeouts_total{protocol="TCP"} 1`,
+		`flat_refused_total{protocol="TCP"} 1`,
+		`flat_events_total 2`,
+		`flat_malformed_events_total 1`,
+		`flat_flow_table_entries 7`,
+		`flat_flow_table_evictions_total 2`,
+		`flat_flow_table_expirations_total 3`,
+		`flat_ringbuf_pending_bytes 128`,
+		`flat_ringbuf_size_bytes 4096`,
+		`flat_ringbuf_dropped_total 5`,
+	} {
+		require.Contains(t, body, line+"\n")
+	}
+}
+
+func TestDestinationCardinality(t *testing.T) {
+	m := New(Config{
+		Destinations:    []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+		MaxDestinations: 2,
+	})
+
+	require.Equal(t, otherDestination, m.destination(netip.MustParseAddrPort("1.1.1.1:443")))
+	require.Equal(t, "10.0.0.1:5432", m.destination(netip.MustParseAddrPort("10.0.0.1:5432")))
+	require.Equal(t, "10.0.0.2:5432", m.destination(netip.MustParseAddrPort("10.0.0.2:5432")))
+	require.Equal(t, otherDestination, m.destination(netip.MustParseAddrPort("10.0.0.3:5432")))
+
+	// Destinations that already have a label keep it
+	require.Equal(t, "10.0.0.1:5432", m.destination(netip.MustParseAddrPort("10.0.0.1:5432")))
+
+	m = New(Config{MaxDestinations: 0})
+	require.Equal(t, otherDestination, m.destination(netip.MustParseAddrPort("1.1.1.1:443")))
+}
@@ -0,0 +1,506 @@
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pouriyajamshidi/flat/internal/baseline"
+	"github.com/pouriyajamshidi/flat/internal/packet"
+	"github.com/pouriyajamshidi/flat/internal/timer"
+)
+
+const (
+	// maxSeries bounds the number of histogram series, the samples of
+	// further destinations are recorded in a single overflow series
+	maxSeries = 1000
+
+	// maxPendingSpans bounds the spans buffered between exports
+	maxPendingSpans = 4096
+
+	exportTimeout = time.Second * 10
+
+	// Values of the OTLP enums used here
+	temporalityCumulative = 2
+	spanKindClient        = 3
+)
+
+// latencyBounds are the explicit histogram bucket boundaries in milliseconds
+var latencyBounds = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+// Config describes where and how often to export
+type Config struct {
+	// Endpoint is the base URL of the OTLP/HTTP receiver, e.g. http://localhost:4318
+	Endpoint string
+	// Interval is how often metrics and spans are exported
+	Interval time.Duration
+	// Spans enables a span for every TCP handshake and DNS transaction
+	Spans bool
+	// Resource holds the attributes describing this instance
+	Resource map[string]string
+}
+
+// DefaultResource describes this host, the interface the probe is
+// attached to and the network namespace it runs in
+func DefaultResource(iface string) map[string]string {
+	resource := map[string]string{
+		"service.name":           "flat",
+		"network.interface.name": iface,
+	}
+
+	if host, err := os.Hostname(); err == nil {
+		resource["host.name"] = host
+	}
+
+	// e.g. net:[4026531840]
+	if netns, err := os.Readlink("/proc/self/ns/net"); err == nil {
+		resource["flat.netns"] = strings.TrimSuffix(strings.TrimPrefix(netns, "net:["), "]")
+	}
+
+	return resource
+}
+
+type seriesKey struct {
+	protocol string
+	server   string
+	overflow bool
+}
+
+type histogram struct {
+	count  uint64
+	sum    float64
+	min    float64
+	max    float64
+	counts []uint64
+}
+
+func (h *histogram) add(value float64) {
+	if h.count == 0 {
+		h.min, h.max = value, value
+	}
+
+	h.count++
+	h.sum += value
+	h.min = min(h.min, value)
+	h.max = max(h.max, value)
+
+	index, _ := slices.BinarySearch(latencyBounds, value)
+	h.counts[index]++
+}
+
+type span struct {
+	name       string
+	start, end uint64 // BPF timestamps
+	attributes map[string]string
+}
+
+// Exporter aggregates latency samples and pushes them to an
+// OpenTelemetry collector using OTLP over HTTP with JSON encoding
+type Exporter struct {
+	config Config
+	client *http.Client
+	boot   time.Time
+	start  time.Time
+
+	mu         sync.Mutex
+	histograms map[seriesKey]*histogram
+	timeouts   map[string]uint64
+	refused    map[string]uint64
+	spans      []span
+	dropped    int
+}
+
+// New constructs a new Exporter
+func New(config Config) *Exporter {
+	return &Exporter{
+		config:     config,
+		client:     &http.Client{Timeout: exportTimeout},
+		boot:       timer.BootTime(),
+		start:      time.Now(),
+		histograms: make(map[seriesKey]*histogram),
+		timeouts:   make(map[string]uint64),
+		refused:    make(map[string]uint64),
+	}
+}
+
+// Latency records the latency of the flow completed by a reply packet
+func (e *Exporter) Latency(result packet.Result) {
+	reply, latency := result.Reply, result.Latency
+	protocol := packet.ProtocolName(reply.Protocol)
+	server := reply.SrcAddrPort().String()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	key := seriesKey{protocol: protocol, server: server}
+
+	current, ok := e.histograms[key]
+
+	if !ok {
+		if len(e.histograms) >= maxSeries {
+			key = seriesKey{protocol: protocol, overflow: true}
+			current, ok = e.histograms[key]
+		}
+
+		if !ok {
+			current = &histogram{counts: make([]uint64, len(latencyBounds)+1)}
+			e.histograms[key] = current
+		}
+	}
+
+	current.add(float64(latency) / float64(time.Millisecond))
+
+	if !e.config.Spans {
+		return
+	}
+
+	var name string
+
+	switch {
+	case reply.Protocol == 6:
+		name = "TCP handshake"
+	case reply.Protocol == 17 && reply.SrcPort == 53:
+		name = "DNS"
+	default:
+		return
+	}
+
+	if len(e.spans) >= maxPendingSpans {
+		e.dropped++
+		return
+	}
+
+	e.spans = append(e.spans, span{
+		name:  name,
+		start: reply.TimeStamp - uint64(latency),
+		end:   reply.TimeStamp,
+		attributes: map[string]string{
+			"network.transport": strings.ToLower(protocol),
+			"client.address":    reply.DstIP.Unmap().String(),
+			"client.port":       strconv.Itoa(int(reply.DstPort)),
+			"server.address":    reply.SrcIP.Unmap().String(),
+			"server.port":       strconv.Itoa(int(reply.SrcPort)),
+		},
+	})
+}
+
+// Timeout counts a request that was not answered in time
+func (e *Exporter) Timeout(request packet.Packet) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.timeouts[packet.ProtocolName(request.Protocol)]++
+}
+
+// Refused counts a TCP SYN that was answered with a RST
+func (e *Exporter) Refused(request packet.Packet) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.refused[packet.ProtocolName(request.Protocol)]++
+}
+
+// Anomaly satisfies the output.Sink interface, anomalies are not exported
+func (e *Exporter) Anomaly(packet.Result, baseline.Anomaly) {}
+
+// The types below follow the JSON encoding of the OTLP protobuf messages,
+// in which 64 bit integers are strings and IDs are hex encoded
+
+type anyValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	BoolValue   *bool   `json:"boolValue,omitempty"`
+}
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type resource struct {
+	Attributes []keyValue `json:"attributes"`
+}
+
+type scope struct {
+	Name string `json:"name"`
+}
+
+type histogramDataPoint struct {
+	Attributes        []keyValue `json:"attributes"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	TimeUnixNano      string     `json:"timeUnixNano"`
+	Count             string     `json:"count"`
+	Sum               float64    `json:"sum"`
+	Min               float64    `json:"min"`
+	Max               float64    `json:"max"`
+	BucketCounts      []string   `json:"bucketCounts"`
+	ExplicitBounds    []float64  `json:"explicitBounds"`
+}
+
+type numberDataPoint struct {
+	Attributes        []keyValue `json:"attributes"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	TimeUnixNano      string     `json:"timeUnixNano"`
+	AsInt             string     `json:"asInt"`
+}
+
+type histogramData struct {
+	AggregationTemporality int                  `json:"aggregationTemporality"`
+	DataPoints             []histogramDataPoint `json:"dataPoints"`
+}
+
+type sumData struct {
+	AggregationTemporality int               `json:"aggregationTemporality"`
+	IsMonotonic            bool              `json:"isMonotonic"`
+	DataPoints             []numberDataPoint `json:"dataPoints"`
+}
+
+type metric struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Unit        string         `json:"unit"`
+	Histogram   *histogramData `json:"histogram,omitempty"`
+	Sum         *sumData       `json:"sum,omitempty"`
+}
+
+type scopeMetrics struct {
+	Scope   scope    `json:"scope"`
+	Metrics []metric `json:"metrics"`
+}
+
+type resourceMetrics struct {
+	Resource     resource       `json:"resource"`
+	ScopeMetrics []scopeMetrics `json:"scopeMetrics"`
+}
+
+type metricsRequest struct {
+	ResourceMetrics []resourceMetrics `json:"resourceMetrics"`
+}
+
+type spanData struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []keyValue `json:"attributes"`
+}
+
+type scopeSpans struct {
+	Scope scope      `json:"scope"`
+	Spans []spanData `json:"spans"`
+}
+
+type resourceSpans struct {
+	Resource   resource     `json:"resource"`
+	ScopeSpans []scopeSpans `json:"scopeSpans"`
+}
+
+type tracesRequest struct {
+	ResourceSpans []resourceSpans `json:"resourceSpans"`
+}
+
+func stringAttribute(key, value string) keyValue {
+	return keyValue{Key: key, Value: anyValue{StringValue: &value}}
+}
+
+// attributes converts a map to attributes sorted by key
+func attributes(values map[string]string) []keyValue {
+	result := make([]keyValue, 0, len(values))
+
+	for _, key := range slices.Sorted(maps.Keys(values)) {
+		result = append(result, stringAttribute(key, values[key]))
+	}
+
+	return result
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+func randomID(size int) string {
+	id := make([]byte, size)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// metrics builds the export request of the cumulative metrics
+func (e *Exporter) metrics(now time.Time) metricsRequest {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	latency := &histogramData{AggregationTemporality: temporalityCumulative}
+
+	for key, current := range e.histograms {
+		attrs := []keyValue{stringAttribute("network.transport", strings.ToLower(key.protocol))}
+
+		if key.overflow {
+			overflow := true
+			attrs = append(attrs, keyValue{Key: "otel.metric.overflow", Value: anyValue{BoolValue: &overflow}})
+		} else {
+			attrs = append(attrs, stringAttribute("server.address", key.server))
+		}
+
+		counts := make([]string, len(current.counts))
+
+		for i, count := range current.counts {
+			counts[i] = strconv.FormatUint(count, 10)
+		}
+
+		latency.DataPoints = append(latency.DataPoints, histogramDataPoint{
+			Attributes:        attrs,
+			StartTimeUnixNano: unixNano(e.start),
+			TimeUnixNano:      unixNano(now),
+			Count:             strconv.FormatUint(current.count, 10),
+			Sum:               current.sum,
+			Min:               current.min,
+			Max:               current.max,
+			BucketCounts:      counts,
+			ExplicitBounds:    latencyBounds,
+		})
+	}
+
+	counter := func(name, description string, values map[string]uint64) metric {
+		data := &sumData{AggregationTemporality: temporalityCumulative, IsMonotonic: true}
+
+		for protocol, value := range values {
+			data.DataPoints = append(data.DataPoints, numberDataPoint{
+				Attributes:        []keyValue{stringAttribute("network.transport", strings.ToLower(protocol))},
+				StartTimeUnixNano: unixNano(e.start),
+				TimeUnixNano:      unixNano(now),
+				AsInt:             strconv.FormatUint(value, 10),
+			})
+		}
+
+		return metric{Name: name, Description: description, Unit: "{request}", Sum: data}
+	}
+
+	return metricsRequest{ResourceMetrics: []resourceMetrics{{
+		Resource: resource{Attributes: attributes(e.config.Resource)},
+		ScopeMetrics: []scopeMetrics{{
+			Scope: scope{Name: "flat"},
+			Metrics: []metric{
+				{Name: "flat.latency", Description: "Latency between a request and its reply.", Unit: "ms", Histogram: latency},
+				counter("flat.timeouts", "Requests that were not answered in time.", e.timeouts),
+				counter("flat.refused", "TCP connections that were refused with a RST.", e.refused),
+			},
+		}},
+	}}}
+}
+
+// traces builds the export request of the pending spans and clears them
+func (e *Exporter) traces() (tracesRequest, bool) {
+	e.mu.Lock()
+	pending := e.spans
+	dropped := e.dropped
+	e.spans = nil
+	e.dropped = 0
+	e.mu.Unlock()
+
+	if dropped > 0 {
+		log.Printf("Dropped %d spans, too many pending spans", dropped)
+	}
+
+	if len(pending) == 0 {
+		return tracesRequest{}, false
+	}
+
+	var spans []spanData
+
+	for _, current := range pending {
+		spans = append(spans, spanData{
+			TraceID:           randomID(16),
+			SpanID:            randomID(8),
+			Name:              current.name,
+			Kind:              spanKindClient,
+			StartTimeUnixNano: unixNano(e.boot.Add(time.Duration(current.start))),
+			EndTimeUnixNano:   unixNano(e.boot.Add(time.Duration(current.end))),
+			Attributes:        attributes(current.attributes),
+		})
+	}
+
+	return tracesRequest{ResourceSpans: []resourceSpans{{
+		Resource:   resource{Attributes: attributes(e.config.Resource)},
+		ScopeSpans: []scopeSpans{{Scope: scope{Name: "flat"}, Spans: spans}},
+	}}}, true
+}
+
+func (e *Exporter) post(ctx context.Context, path string, body any) error {
+	data, err := json.Marshal(body)
+
+	if err != nil {
+		return err
+	}
+
+	url := strings.TrimSuffix(e.config.Endpoint, "/") + path
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%v returned %v", url, resp.Status)
+	}
+
+	return nil
+}
+
+// Export pushes the metrics and the pending spans to the collector
+func (e *Exporter) Export(ctx context.Context) error {
+	if err := e.post(ctx, "/v1/metrics", e.metrics(time.Now())); err != nil {
+		return err
+	}
+
+	if traces, ok := e.traces(); ok {
+		return e.post(ctx, "/v1/traces", traces)
+	}
+
+	return nil
+}
+
+// Run exports every interval until ctx is cancelled, then exports one last time
+func (e *Exporter) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			final, cancel := context.WithTimeout(context.Background(), exportTimeout)
+			defer cancel()
+
+			if err := e.Export(final); err != nil {
+				log.Printf("Failed exporting to OTLP collector: %v", err)
+			}
+			return
+		case <-ticker.C:
+			if err := e.Export(ctx); err != nil {
+				log.Printf("Failed exporting to OTLP collector: %v", err)
+			}
+		}
+	}
+}
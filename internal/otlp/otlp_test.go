@@ -0,0 +1,171 @@
+package otlp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/pouriyajamshidi/flat/internal/packet"
+	"github.com/stretchr/testify/require"
+)
+
+// receiver is an in-process OTLP/HTTP collector
+type receiver struct {
+	*httptest.Server
+	metrics chan metricsRequest
+	traces  chan tracesRequest
+}
+
+func newReceiver(t *testing.T) *receiver {
+	r := &receiver{
+		metrics: make(chan metricsRequest, 10),
+		traces:  make(chan tracesRequest, 10),
+	}
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("POST /v1/metrics", func(w http.ResponseWriter, req *http.Request) {
+		require.Equal(t, "application/json", req.Header.Get("Content-Type"))
+
+		var body metricsRequest
+		require.NoError(t, json.NewDecoder(req.Body).Decode(&body))
+		r.metrics <- body
+	})
+
+	mux.HandleFunc("POST /v1/traces", func(w http.ResponseWriter, req *http.Request) {
+		var body tracesRequest
+		require.NoError(t, json.NewDecoder(req.Body).Decode(&body))
+		r.traces <- body
+	})
+
+	r.Server = httptest.NewServer(mux)
+	t.Cleanup(r.Close)
+
+	return r
+}
+
+func reply(server string, protocol uint8, timestamp uint64) packet.Packet {
+	addr := netip.MustParseAddrPort(server)
+
+	return packet.Packet{
+		SrcIP:     addr.Addr(),
+		DstIP:     netip.MustParseAddr("192.168.0.156"),
+		SrcPort:   addr.Port(),
+		DstPort:   53264,
+		Protocol:  protocol,
+		TimeStamp: timestamp,
+	}
+}
+
+func stringValue(attrs []keyValue, key string) string {
+	for _, attr := range attrs {
+		if attr.Key == key && attr.Value.StringValue != nil {
+			return *attr.Value.StringValue
+		}
+	}
+
+	return ""
+}
+
+func TestExport(t *testing.T) {
+	collector := newReceiver(t)
+
+	exporter := New(Config{
+		Endpoint: collector.URL,
+		Spans:    true,
+		Resource: map[string]string{"host.name": "node-1", "network.interface.name": "eth0"},
+	})
+	exporter.boot = time.Unix(1700000000, 0)
+
+	exporter.Latency(packet.Result{Reply: reply("1.1.1.1:443", 6, 2_000_000_000), Latency: 12 * time.Millisecond})
+	exporter.Latency(packet.Result{Reply: reply("1.1.1.1:443", 6, 3_000_000_000), Latency: 30 * time.Millisecond})
+	exporter.Latency(packet.Result{Reply: reply("8.8.8.8:53", 17, 4_000_000_000), Latency: 5 * time.Millisecond})
+	exporter.Latency(packet.Result{Reply: reply("8.8.8.8:123", 17, 5_000_000_000), Latency: 5 * time.Millisecond})
+	exporter.Timeout(reply("1.1.1.1:443", 6, 0).Reverse())
+
+	require.NoError(t, exporter.Export(context.Background()))
+
+	metrics := <-collector.metrics
+	require.Len(t, metrics.ResourceMetrics, 1)
+	require.Equal(t, "node-1", stringValue(metrics.ResourceMetrics[0].Resource.Attributes, "host.name"))
+	require.Equal(t, "eth0", stringValue(metrics.ResourceMetrics[0].Resource.Attributes, "network.interface.name"))
+
+	exported := metrics.ResourceMetrics[0].ScopeMetrics[0].Metrics
+	require.Equal(t, "flat.latency", exported[0].Name)
+	require.Len(t, exported[0].Histogram.DataPoints, 3)
+
+	for _, point := range exported[0].Histogram.DataPoints {
+		if stringValue(point.Attributes, "server.address") != "1.1.1.1:443" {
+			continue
+		}
+
+		require.Equal(t, "tcp", stringValue(point.Attributes, "network.transport"))
+		require.Equal(t, "2", point.Count)
+		require.Equal(t, 42.0, point.Sum)
+		require.Equal(t, 12.0, point.Min)
+		require.Equal(t, 30.0, point.Max)
+		require.Equal(t, latencyBounds, point.ExplicitBounds)
+		require.Equal(t, "1", point.BucketCounts[7]) // (10, 25]
+		require.Equal(t, "1", point.BucketCounts[8]) // (25, 50]
+	}
+
+	require.Equal(t, "flat.timeouts", exported[1].Name)
+	require.Equal(t, "1", exported[1].Sum.DataPoints[0].AsInt)
+
+	// Only the TCP handshakes and the DNS transaction become spans
+	traces := <-collector.traces
+	spans := traces.ResourceSpans[0].ScopeSpans[0].Spans
+	require.Len(t, spans, 3)
+
+	require.Equal(t, "TCP handshake", spans[0].Name)
+	require.Len(t, spans[0].TraceID, 32)
+	require.Len(t, spans[0].SpanID, 16)
+	require.Equal(t, "1700000001988000000", spans[0].StartTimeUnixNano)
+	require.Equal(t, "1700000002000000000", spans[0].EndTimeUnixNano)
+	require.Equal(t, "1.1.1.1", stringValue(spans[0].Attributes, "server.address"))
+	require.Equal(t, "DNS", spans[2].Name)
+
+	// Spans are only exported once, metrics are cumulative
+	require.NoError(t, exporter.Export(context.Background()))
+	metrics = <-collector.metrics
+	require.Len(t, metrics.ResourceMetrics[0].ScopeMetrics[0].Metrics[0].Histogram.DataPoints, 3)
+	require.Empty(t, collector.traces)
+}
+
+func TestSeriesOverflow(t *testing.T) {
+	exporter := New(Config{})
+
+	for i := range maxSeries + 10 {
+		exporter.Latency(packet.Result{Reply: reply(netip.AddrPortFrom(netip.AddrFrom4([4]byte{10, 0, byte(i >> 8), byte(i)}), 443).String(), 6, 0), Latency: time.Millisecond})
+	}
+
+	request := exporter.metrics(time.Now())
+	points := request.ResourceMetrics[0].ScopeMetrics[0].Metrics[0].Histogram.DataPoints
+	require.Len(t, points, maxSeries+1)
+
+	var overflow int
+
+	for _, point := range points {
+		for _, attr := range point.Attributes {
+			if attr.Key == "otel.metric.overflow" {
+				overflow++
+				require.Equal(t, "10", point.Count)
+			}
+		}
+	}
+
+	require.Equal(t, 1, overflow)
+}
+
+func TestExportError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	require.Error(t, New(Config{Endpoint: server.URL}).Export(context.Background()))
+}
@@ -0,0 +1,156 @@
+package output
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pouriyajamshidi/flat/internal/baseline"
+	"github.com/pouriyajamshidi/flat/internal/packet"
+)
+
+// DefaultBuffer is how many results a sink may fall behind by
+// before the results that follow are dropped for it
+const DefaultBuffer = 4096
+
+// queued is a result waiting to be handed to a sink
+type queued struct {
+	kind    Result
+	result  packet.Result
+	request packet.Packet
+	anomaly baseline.Anomaly
+}
+
+type registered struct {
+	name    string
+	sink    Sink
+	queue   chan queued
+	dropped atomic.Uint64
+}
+
+// Fanout hands every result to several sinks. Each sink is fed from its
+// own buffered queue by its own goroutine, so that a slow sink drops
+// results instead of stalling the other sinks or the ringbuf consumer
+type Fanout struct {
+	mu     sync.RWMutex
+	sinks  []*registered
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// NewFanout constructs a new Fanout without any sinks
+func NewFanout() *Fanout {
+	return &Fanout{}
+}
+
+// Register starts feeding a sink from a queue of buffer results
+func (f *Fanout) Register(name string, sink Sink, buffer int) {
+	s := &registered{name: name, sink: sink, queue: make(chan queued, max(buffer, 1))}
+
+	f.mu.Lock()
+	f.sinks = append(f.sinks, s)
+	f.mu.Unlock()
+
+	f.wg.Go(func() {
+		for q := range s.queue {
+			switch q.kind {
+			case Latency:
+				s.sink.Latency(q.result)
+			case Timeout:
+				s.sink.Timeout(q.request)
+			case Refused:
+				s.sink.Refused(q.request)
+			case Anomaly:
+				s.sink.Anomaly(q.result, q.anomaly)
+			}
+		}
+	})
+}
+
+// Len returns the number of registered sinks
+func (f *Fanout) Len() int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return len(f.sinks)
+}
+
+// Dropped returns how many results each sink could not keep up with
+func (f *Fanout) Dropped() map[string]uint64 {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	dropped := make(map[string]uint64, len(f.sinks))
+
+	for _, s := range f.sinks {
+		dropped[s.name] = s.dropped.Load()
+	}
+
+	return dropped
+}
+
+func (f *Fanout) publish(q queued) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if f.closed {
+		return
+	}
+
+	for _, s := range f.sinks {
+		select {
+		case s.queue <- q:
+		default:
+			if s.dropped.Add(1) == 1 {
+				log.Printf("The %v output is falling behind, dropping results", s.name)
+			}
+		}
+	}
+}
+
+// Latency satisfies the Sink interface
+func (f *Fanout) Latency(result packet.Result) {
+	f.publish(queued{kind: Latency, result: result})
+}
+
+// Timeout satisfies the Sink interface
+func (f *Fanout) Timeout(request packet.Packet) {
+	f.publish(queued{kind: Timeout, request: request})
+}
+
+// Refused satisfies the Sink interface
+func (f *Fanout) Refused(request packet.Packet) {
+	f.publish(queued{kind: Refused, request: request})
+}
+
+// Anomaly satisfies the Sink interface
+func (f *Fanout) Anomaly(result packet.Result, anomaly baseline.Anomaly) {
+	f.publish(queued{kind: Anomaly, result: result, anomaly: anomaly})
+}
+
+// Close stops accepting results and waits until every sink
+// has handled the results queued for it
+func (f *Fanout) Close() {
+	f.mu.Lock()
+
+	if f.closed {
+		f.mu.Unlock()
+		return
+	}
+
+	f.closed = true
+
+	for _, s := range f.sinks {
+		close(s.queue)
+	}
+
+	f.mu.Unlock()
+
+	f.wg.Wait()
+
+	for name, dropped := range f.Dropped() {
+		if dropped > 0 {
+			log.Printf("Dropped %d results the %v output could not keep up with", dropped, name)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package output
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pouriyajamshidi/flat/internal/baseline"
+	"github.com/pouriyajamshidi/flat/internal/packet"
+	"github.com/stretchr/testify/require"
+)
+
+// collector records the results it is handed, optionally
+// blocking on every one of them until it is released
+type collector struct {
+	mu      sync.Mutex
+	results []string
+	release chan struct{}
+}
+
+func (c *collector) add(result string) {
+	if c.release != nil {
+		<-c.release
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.results = append(c.results, result)
+}
+
+func (c *collector) Latency(packet.Result)                   { c.add("latency") }
+func (c *collector) Timeout(packet.Packet)                   { c.add("timeout") }
+func (c *collector) Refused(packet.Packet)                   { c.add("refused") }
+func (c *collector) Anomaly(packet.Result, baseline.Anomaly) { c.add("anomaly") }
+
+func TestFanout(t *testing.T) {
+	first, second := &collector{}, &collector{}
+
+	sinks := NewFanout()
+	sinks.Register("first", first, DefaultBuffer)
+	sinks.Register("second", second, DefaultBuffer)
+	require.Equal(t, 2, sinks.Len())
+
+	sinks.Latency(packet.Result{Reply: request.Reverse(), Latency: time.Millisecond})
+	sinks.Timeout(request)
+	sinks.Refused(request)
+	sinks.Anomaly(packet.Result{Reply: request.Reverse(), Latency: time.Second}, baseline.Anomaly{})
+	sinks.Close()
+
+	// Every sink gets every result in order
+	require.Equal(t, []string{"latency", "timeout", "refused", "anomaly"}, first.results)
+	require.Equal(t, []string{"latency", "timeout", "refused", "anomaly"}, second.results)
+
+	// Results after closing are ignored
+	sinks.Timeout(request)
+	require.Len(t, first.results, 4)
+}
+
+func TestFanoutSlowSink(t *testing.T) {
+	slow := &collector{release: make(chan struct{})}
+	fast := &collector{}
+
+	sinks := NewFanout()
+	sinks.Register("slow", slow, 2)
+	sinks.Register("fast", fast, 100)
+
+	// The slow sink blocks on the first result and queues
+	// two more, the rest are dropped without blocking
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		for range 10 {
+			sinks.Timeout(request)
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("a slow sink stalled the fanout")
+	}
+
+	require.Eventually(t, func() bool {
+		fast.mu.Lock()
+		defer fast.mu.Unlock()
+
+		return len(fast.results) == 10
+	}, time.Second, time.Millisecond*10)
+
+	dropped := sinks.Dropped()
+	require.Zero(t, dropped["fast"])
+	require.GreaterOrEqual(t, dropped["slow"], uint64(7))
+
+	close(slow.release)
+	sinks.Close()
+
+	require.Equal(t, uint64(10), uint64(len(slow.results))+dropped["slow"])
+}
+
+func TestSwitch(t *testing.T) {
+	sink := &collector{}
+	s := NewSwitch(sink, false)
+
+	s.Timeout(request)
+	require.Empty(t, sink.results)
+
+	s.Set(true)
+	s.Timeout(request)
+	s.Refused(request)
+	require.Equal(t, []string{"timeout", "refused"}, sink.results)
+}
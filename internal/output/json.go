@@ -0,0 +1,201 @@
+package output
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/pouriyajamshidi/flat/internal/baseline"
+	"github.com/pouriyajamshidi/flat/internal/packet"
+	"github.com/pouriyajamshidi/flat/internal/timer"
+)
+
+// SchemaVersion is bumped whenever a Record field is removed or changes meaning.
+// New optional fields may be added without bumping it
+const SchemaVersion = 1
+
+// Result is the type of a Record
+type Result string
+
+const (
+	// Latency is a request that was answered
+	Latency Result = "latency"
+	// Timeout is a request that was not answered in time
+	Timeout Result = "timeout"
+	// Refused is a TCP SYN that was answered with a RST
+	Refused Result = "refused"
+	// Anomaly is a latency that deviates from its destination's baseline
+	Anomaly Result = "anomaly"
+)
+
+// Direction tells whether the host initiated a flow
+type Direction string
+
+const (
+	// Outbound flows are initiated by an address of the interface
+	Outbound Direction = "outbound"
+	// Inbound flows are initiated by a remote address
+	Inbound Direction = "inbound"
+)
+
+// Record is one JSON line. Src is always the client and Dst the server,
+// whichever packet the measurement was completed by
+type Record struct {
+	Version   int       `json:"version"`
+	Type      Result    `json:"type"`
+	Protocol  string    `json:"protocol"`
+	SrcIP     string    `json:"src_ip"`
+	SrcPort   uint16    `json:"src_port"`
+	DstIP     string    `json:"dst_ip"`
+	DstPort   uint16    `json:"dst_port"`
+	TTL       uint8     `json:"ttl"`          // of the reply, or of the request for timeouts and refusals
+	LatencyNs int64     `json:"latency_ns"`   // zero for timeouts and refusals
+	Monotonic uint64    `json:"monotonic_ns"` // BPF timestamp of the reply, or of the request for timeouts and refusals
+	Time      time.Time `json:"time"`         // wall clock time of Monotonic
+	Interface string    `json:"interface"`
+	Direction Direction `json:"direction"`
+	PID       uint32    `json:"pid,omitempty"`
+	Comm      string    `json:"comm,omitempty"`
+	SrcHost   string    `json:"src_host,omitempty"`
+	DstHost   string    `json:"dst_host,omitempty"`
+	// The baseline an anomaly deviates from, and by how many standard deviations
+	BaselineNs int64   `json:"baseline_ns,omitempty"`
+	StdDevNs   int64   `json:"stddev_ns,omitempty"`
+	Score      float64 `json:"score,omitempty"`
+}
+
+// Records builds the Records of the results seen on an interface
+type Records struct {
+	iface string
+	local map[netip.Addr]bool
+	boot  time.Time // wall clock time of the monotonic clock's zero
+}
+
+// NewRecords constructs a new Records for results seen on the named
+// interface, whose addresses tell inbound and outbound flows apart
+func NewRecords(iface string, local []netip.Addr) *Records {
+	addrs := make(map[netip.Addr]bool)
+
+	for _, addr := range local {
+		addrs[addr.Unmap()] = true
+	}
+
+	return &Records{
+		iface: iface,
+		local: addrs,
+		boot:  timer.BootTime(),
+	}
+}
+
+// SetEpoch sets the wall clock time packet timestamps count from,
+// which defaults to when the monotonic clock started at boot
+func (r *Records) SetEpoch(epoch time.Time) {
+	r.boot = epoch
+}
+
+func (r *Records) record(result Result, request packet.Packet, monotonic uint64) Record {
+	direction := Inbound
+
+	if r.local[request.SrcIP.Unmap()] {
+		direction = Outbound
+	}
+
+	return Record{
+		Version:   SchemaVersion,
+		Type:      result,
+		Protocol:  packet.ProtocolName(request.Protocol),
+		SrcIP:     request.SrcIP.Unmap().String(),
+		SrcPort:   request.SrcPort,
+		DstIP:     request.DstIP.Unmap().String(),
+		DstPort:   request.DstPort,
+		TTL:       request.TTL,
+		Monotonic: monotonic,
+		Time:      r.boot.Add(time.Duration(monotonic)),
+		Interface: r.iface,
+		Direction: direction,
+		PID:       request.PID,
+		Comm:      request.Comm,
+		SrcHost:   request.SrcHost,
+		DstHost:   request.DstHost,
+	}
+}
+
+// Latency builds the Record of the flow completed by a reply packet
+func (r *Records) Latency(result packet.Result) Record {
+	reply, latency := result.Reply, result.Latency
+	record := r.record(Latency, reply.Reverse(), reply.TimeStamp)
+	record.TTL = reply.TTL
+	record.LatencyNs = int64(latency)
+
+	return record
+}
+
+// Anomaly builds the Record of a latency that deviates from its baseline
+func (r *Records) Anomaly(result packet.Result, anomaly baseline.Anomaly) Record {
+	record := r.Latency(result)
+	record.Type = Anomaly
+	record.BaselineNs = int64(anomaly.Mean)
+	record.StdDevNs = int64(anomaly.StdDev)
+	record.Score = anomaly.Score
+
+	return record
+}
+
+// Request builds the Record of a request that timed out or was refused
+func (r *Records) Request(result Result, request packet.Packet) Record {
+	return r.record(result, request, request.TimeStamp)
+}
+
+// JSON writes results as JSON Lines
+type JSON struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+	records *Records
+}
+
+// NewJSON constructs a new JSON writer for results seen on the named
+// interface, whose addresses tell inbound and outbound flows apart
+func NewJSON(w io.Writer, iface string, local []netip.Addr) *JSON {
+	return &JSON{
+		encoder: json.NewEncoder(w),
+		records: NewRecords(iface, local),
+	}
+}
+
+// SetEpoch sets the wall clock time packet timestamps count from,
+// which defaults to when the monotonic clock started at boot
+func (j *JSON) SetEpoch(epoch time.Time) {
+	j.records.SetEpoch(epoch)
+}
+
+func (j *JSON) write(record Record) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := j.encoder.Encode(record); err != nil {
+		log.Printf("Failed writing JSON output: %v", err)
+	}
+}
+
+// Latency writes the latency of the flow completed by a reply packet
+func (j *JSON) Latency(result packet.Result) {
+	j.write(j.records.Latency(result))
+}
+
+// Timeout writes a request that was not answered in time
+func (j *JSON) Timeout(request packet.Packet) {
+	j.write(j.records.Request(Timeout, request))
+}
+
+// Refused writes a TCP SYN that was answered with a RST
+func (j *JSON) Refused(request packet.Packet) {
+	j.write(j.records.Request(Refused, request))
+}
+
+// Anomaly writes a latency that deviates from its destination's baseline
+func (j *JSON) Anomaly(result packet.Result, anomaly baseline.Anomaly) {
+	j.write(j.records.Anomaly(result, anomaly))
+}
@@ -0,0 +1,98 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/pouriyajamshidi/flat/internal/baseline"
+	"github.com/pouriyajamshidi/flat/internal/packet"
+	"github.com/stretchr/testify/require"
+)
+
+var request = packet.Packet{
+	SrcIP:     netip.MustParseAddr("::ffff:192.168.0.156"),
+	DstIP:     netip.MustParseAddr("::ffff:1.1.1.1"),
+	SrcPort:   53264,
+	DstPort:   443,
+	Protocol:  6,
+	TTL:       64,
+	Syn:       true,
+	TimeStamp: 1_000_000_000,
+}
+
+func decode(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	var records []map[string]any
+
+	decoder := json.NewDecoder(buf)
+
+	for decoder.More() {
+		var record map[string]any
+		require.NoError(t, decoder.Decode(&record))
+		records = append(records, record)
+	}
+
+	return records
+}
+
+func TestJSON(t *testing.T) {
+	var buf bytes.Buffer
+
+	writer := NewJSON(&buf, "eth0", []netip.Addr{netip.MustParseAddr("192.168.0.156")})
+	writer.SetEpoch(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	reply := request.Reverse()
+	reply.Syn, reply.Ack = true, true
+	reply.TTL = 57
+	reply.TimeStamp = 1_012_500_000
+
+	writer.Latency(packet.Result{Reply: reply, Latency: 12500 * time.Microsecond})
+	writer.Timeout(request)
+
+	inbound := request.Reverse()
+	writer.Refused(inbound)
+
+	writer.Anomaly(packet.Result{Reply: reply, Latency: 90 * time.Millisecond}, baseline.Anomaly{
+		Latency: 90 * time.Millisecond,
+		Mean:    12 * time.Millisecond,
+		StdDev:  2 * time.Millisecond,
+		Score:   39,
+	})
+
+	records := decode(t, &buf)
+	require.Len(t, records, 4)
+
+	require.Equal(t, map[string]any{
+		"version":      float64(SchemaVersion),
+		"type":         "latency",
+		"protocol":     "TCP",
+		"src_ip":       "192.168.0.156",
+		"src_port":     float64(53264),
+		"dst_ip":       "1.1.1.1",
+		"dst_port":     float64(443),
+		"ttl":          float64(57),
+		"latency_ns":   float64(12_500_000),
+		"monotonic_ns": float64(1_012_500_000),
+		"time":         "2024-01-01T00:00:01.0125Z",
+		"interface":    "eth0",
+		"direction":    "outbound",
+	}, records[0])
+
+	require.Equal(t, "timeout", records[1]["type"])
+	require.Equal(t, float64(64), records[1]["ttl"])
+	require.Equal(t, float64(0), records[1]["latency_ns"])
+	require.Equal(t, "2024-01-01T00:00:01Z", records[1]["time"])
+
+	require.Equal(t, "refused", records[2]["type"])
+	require.Equal(t, "1.1.1.1", records[2]["src_ip"])
+	require.Equal(t, "inbound", records[2]["direction"])
+
+	require.Equal(t, "anomaly", records[3]["type"])
+	require.Equal(t, "1.1.1.1", records[3]["dst_ip"])
+	require.Equal(t, float64(90_000_000), records[3]["latency_ns"])
+	require.Equal(t, float64(12_000_000), records[3]["baseline_ns"])
+	require.Equal(t, float64(2_000_000), records[3]["stddev_ns"])
+	require.Equal(t, float64(39), records[3]["score"])
+}
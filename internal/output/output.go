@@ -0,0 +1,149 @@
+package output
+
+import (
+	"fmt"
+	"net/netip"
+	"sync/atomic"
+
+	"github.com/gookit/color"
+	"github.com/pouriyajamshidi/flat/internal/baseline"
+	"github.com/pouriyajamshidi/flat/internal/packet"
+)
+
+var (
+	colorLightYellow = color.LightYellow.Printf
+	colorCyan        = color.Cyan.Printf
+)
+
+// Sink is an output that is handed every result
+type Sink interface {
+	// Latency handles a request that was answered
+	Latency(result packet.Result)
+	// Timeout handles a request that was not answered in time
+	Timeout(request packet.Packet)
+	// Refused handles a TCP SYN that was answered with a RST
+	Refused(request packet.Packet)
+	// Anomaly handles a latency that deviates from its destination's baseline
+	Anomaly(result packet.Result, anomaly baseline.Anomaly)
+}
+
+// Text prints latencies as coloured lines
+type Text struct{}
+
+// endpoint formats an IP address as "host (ip)" when its hostname is known
+func endpoint(ip netip.Addr, host string) string {
+	if host == "" {
+		return ip.Unmap().String()
+	}
+	return host + " (" + ip.Unmap().String() + ")"
+}
+
+// details formats the optional enrichment columns of a reply packet.
+// Source and destination are swapped to match the initiating direction
+func details(pkt packet.Packet) string {
+	var details string
+
+	if !pkt.DstKube.IsZero() {
+		details += fmt.Sprintf("\tsrc-k8s: %v", pkt.DstKube)
+	}
+
+	if !pkt.SrcKube.IsZero() {
+		details += fmt.Sprintf("\tdst-k8s: %v", pkt.SrcKube)
+	}
+
+	if !pkt.DstGeo.IsZero() {
+		details += fmt.Sprintf("\tsrc-geo: %v", pkt.DstGeo)
+	}
+
+	if !pkt.SrcGeo.IsZero() {
+		details += fmt.Sprintf("\tdst-geo: %v", pkt.SrcGeo)
+	}
+
+	if pkt.PID != 0 {
+		details += fmt.Sprintf("\tpid: %d (%v)", pkt.PID, pkt.Comm)
+	}
+
+	return details
+}
+
+// Latency prints the latency of the flow completed by a reply packet
+func (Text) Latency(result packet.Result) {
+	pkt := result.Reply
+	printf := colorCyan
+
+	if pkt.Protocol == 17 {
+		printf = colorLightYellow
+	}
+
+	printf("(%v) | src: %v:%-7v\tdst: %v:%-9v\tTTL: %-4v\tlatency: %.3f ms%v\n",
+		packet.ProtocolName(pkt.Protocol),
+		endpoint(pkt.DstIP, pkt.DstHost),
+		pkt.DstPort,
+		endpoint(pkt.SrcIP, pkt.SrcHost),
+		pkt.SrcPort,
+		pkt.TTL,
+		float64(result.Latency)/1_000_000,
+		details(pkt),
+	)
+}
+
+// Timeout satisfies the Sink interface, timeouts are not printed
+func (Text) Timeout(packet.Packet) {}
+
+// Refused satisfies the Sink interface, refusals are not printed
+func (Text) Refused(packet.Packet) {}
+
+// Anomaly satisfies the Sink interface, anomalies are logged instead
+func (Text) Anomaly(packet.Result, baseline.Anomaly) {}
+
+// Switch is a Sink that can be turned off and back on while it is handed results
+type Switch struct {
+	sink Sink
+	off  atomic.Bool
+}
+
+// NewSwitch constructs a new Switch in front of a sink
+func NewSwitch(sink Sink, on bool) *Switch {
+	s := &Switch{sink: sink}
+	s.off.Store(!on)
+
+	return s
+}
+
+// Set turns the sink on or off
+func (s *Switch) Set(on bool) {
+	s.off.Store(!on)
+}
+
+// On tells whether the sink is handed results
+func (s *Switch) On() bool {
+	return !s.off.Load()
+}
+
+// Latency satisfies the Sink interface
+func (s *Switch) Latency(result packet.Result) {
+	if s.On() {
+		s.sink.Latency(result)
+	}
+}
+
+// Timeout satisfies the Sink interface
+func (s *Switch) Timeout(request packet.Packet) {
+	if s.On() {
+		s.sink.Timeout(request)
+	}
+}
+
+// Refused satisfies the Sink interface
+func (s *Switch) Refused(request packet.Packet) {
+	if s.On() {
+		s.sink.Refused(request)
+	}
+}
+
+// Anomaly satisfies the Sink interface
+func (s *Switch) Anomaly(result packet.Result, anomaly baseline.Anomaly) {
+	if s.On() {
+		s.sink.Anomaly(result, anomaly)
+	}
+}
@@ -0,0 +1,128 @@
+package output
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pouriyajamshidi/flat/internal/baseline"
+	"github.com/pouriyajamshidi/flat/internal/packet"
+)
+
+const (
+	// maxDatagramSize keeps a batch within a 1500 bytes MTU
+	// once the IPv6 and UDP headers are added
+	maxDatagramSize = 1432
+
+	statsdFlushInterval = time.Second
+)
+
+// StatsD sends results to a StatsD agent, with tags in the DogStatsD format
+type StatsD struct {
+	conn   net.Conn
+	prefix string
+	iface  string
+
+	mu    sync.Mutex
+	batch []byte
+}
+
+// NewStatsD constructs a new StatsD writer sending to addr. Metric names
+// are prefixed with prefix and tagged with the interface name
+func NewStatsD(addr, prefix, iface string) (*StatsD, error) {
+	conn, err := net.Dial("udp", addr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &StatsD{conn: conn, prefix: prefix, iface: iface}, nil
+}
+
+// tags formats the DogStatsD tags of a packet travelling from the client to the server
+func (s *StatsD) tags(request packet.Packet) string {
+	return fmt.Sprintf("#protocol:%v,destination:%v,interface:%v",
+		strings.ToLower(packet.ProtocolName(request.Protocol)),
+		request.DstAddrPort(),
+		s.iface,
+	)
+}
+
+// send adds a metric to the batch, sending the batch first if the metric does not fit
+func (s *StatsD) send(metric string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.batch) > 0 && len(s.batch)+1+len(metric) > maxDatagramSize {
+		s.flush()
+	}
+
+	if len(s.batch) > 0 {
+		s.batch = append(s.batch, '\n')
+	}
+
+	s.batch = append(s.batch, metric...)
+}
+
+// flush sends the batch. Callers must hold the lock
+func (s *StatsD) flush() {
+	if len(s.batch) == 0 {
+		return
+	}
+
+	if _, err := s.conn.Write(s.batch); err != nil {
+		log.Printf("Failed sending to StatsD: %v", err)
+	}
+
+	s.batch = s.batch[:0]
+}
+
+// Flush sends the pending metrics
+func (s *StatsD) Flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.flush()
+}
+
+// Run sends the pending metrics every second until ctx is cancelled
+func (s *StatsD) Run(ctx context.Context) {
+	ticker := time.NewTicker(statsdFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.Flush()
+			s.conn.Close()
+			return
+		case <-ticker.C:
+			s.Flush()
+		}
+	}
+}
+
+// Latency satisfies the Sink interface
+func (s *StatsD) Latency(result packet.Result) {
+	reply, latency := result.Reply, result.Latency
+	s.send(fmt.Sprintf("%vlatency:%.3f|ms|%v", s.prefix, float64(latency)/float64(time.Millisecond), s.tags(reply.Reverse())))
+}
+
+// Timeout satisfies the Sink interface
+func (s *StatsD) Timeout(request packet.Packet) {
+	s.send(fmt.Sprintf("%vtimeouts:1|c|%v", s.prefix, s.tags(request)))
+}
+
+// Refused satisfies the Sink interface
+func (s *StatsD) Refused(request packet.Packet) {
+	s.send(fmt.Sprintf("%vrefused:1|c|%v", s.prefix, s.tags(request)))
+}
+
+// Anomaly satisfies the Sink interface
+func (s *StatsD) Anomaly(result packet.Result, _ baseline.Anomaly) {
+	s.send(fmt.Sprintf("%vanomalies:1|c|%v", s.prefix, s.tags(result.Reply.Reverse())))
+}
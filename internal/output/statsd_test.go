@@ -0,0 +1,77 @@
+package output
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pouriyajamshidi/flat/internal/packet"
+	"github.com/stretchr/testify/require"
+)
+
+func listen(t *testing.T) *net.UDPConn {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
+
+func receive(t *testing.T, conn *net.UDPConn) string {
+	buf := make([]byte, 65536)
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+
+	return string(buf[:n])
+}
+
+func TestStatsD(t *testing.T) {
+	agent := listen(t)
+
+	writer, err := NewStatsD(agent.LocalAddr().String(), "flat.", "eth0")
+	require.NoError(t, err)
+
+	reply := request.Reverse()
+	reply.Syn, reply.Ack = true, true
+
+	writer.Latency(packet.Result{Reply: reply, Latency: 12500 * time.Microsecond})
+	writer.Timeout(request)
+	writer.Refused(request)
+	writer.Flush()
+
+	require.Equal(t, strings.Join([]string{
+		"flat.latency:12.500|ms|#protocol:tcp,destination:1.1.1.1:443,interface:eth0",
+		"flat.timeouts:1|c|#protocol:tcp,destination:1.1.1.1:443,interface:eth0",
+		"flat.refused:1|c|#protocol:tcp,destination:1.1.1.1:443,interface:eth0",
+	}, "\n"), receive(t, agent))
+}
+
+func TestStatsDBatching(t *testing.T) {
+	agent := listen(t)
+
+	writer, err := NewStatsD(agent.LocalAddr().String(), "", "eth0")
+	require.NoError(t, err)
+
+	const metrics = 100
+
+	for range metrics {
+		writer.Timeout(request)
+	}
+
+	writer.Flush()
+
+	var received int
+
+	for received < metrics {
+		datagram := receive(t, agent)
+		require.LessOrEqual(t, len(datagram), maxDatagramSize)
+
+		received += len(strings.Split(datagram, "\n"))
+	}
+
+	require.Equal(t, metrics, received)
+}
@@ -2,17 +2,13 @@ package packet
 
 import (
 	"encoding/binary"
-	"hash/fnv"
-	"log"
+	"fmt"
 	"net/netip"
+	"time"
 
-	"github.com/gookit/color"
 	"github.com/pouriyajamshidi/flat/internal/flowtable"
-)
-
-var (
-	colorLightYellow = color.LightYellow.Printf
-	colorCyan        = color.Cyan.Printf
+	"github.com/pouriyajamshidi/flat/internal/geoip"
+	"github.com/pouriyajamshidi/flat/internal/kube"
 )
 
 // Packet represents a TCP or UDP packet
@@ -26,36 +22,61 @@ type Packet struct {
 	Syn       bool
 	Ack       bool
 	TimeStamp uint64
+	Interface int
+	PID       uint32
+	Comm      string
+	SrcKube   kube.Metadata
+	DstKube   kube.Metadata
+	SrcHost   string
+	DstHost   string
+	SrcGeo    geoip.Location
+	DstGeo    geoip.Location
 }
 
-func hash(value []byte) uint64 {
-	hash := fnv.New64a()
-	hash.Write(value)
-	return hash.Sum64()
+// FlowKey returns the direction independent key of the packet's flow
+func (pkt *Packet) FlowKey() flowtable.FlowKey {
+	return flowtable.NewFlowKey(
+		netip.AddrPortFrom(pkt.SrcIP, pkt.SrcPort),
+		netip.AddrPortFrom(pkt.DstIP, pkt.DstPort),
+		pkt.Protocol,
+		pkt.Interface,
+	)
 }
 
-// Hash hashes the packets based on their 5-tuple hash
-func (pkt *Packet) Hash() uint64 {
-	tmp := make([]byte, 2)
-
-	var src []byte
-	var dst []byte
-	var proto []byte
+// SrcAddrPort returns the unmapped source address and port
+func (pkt *Packet) SrcAddrPort() netip.AddrPort {
+	return netip.AddrPortFrom(pkt.SrcIP.Unmap(), pkt.SrcPort)
+}
 
-	binary.BigEndian.PutUint16(tmp, pkt.SrcPort)
-	src = append(pkt.SrcIP.AsSlice(), tmp...)
+// DstAddrPort returns the unmapped destination address and port
+func (pkt *Packet) DstAddrPort() netip.AddrPort {
+	return netip.AddrPortFrom(pkt.DstIP.Unmap(), pkt.DstPort)
+}
 
-	binary.BigEndian.PutUint16(tmp, pkt.DstPort)
-	dst = append(pkt.DstIP.AsSlice(), tmp...)
+// Reverse swaps the source and destination of the packet along with
+// their enrichments, turning a reply into the direction of its request
+func (pkt Packet) Reverse() Packet {
+	pkt.SrcIP, pkt.DstIP = pkt.DstIP, pkt.SrcIP
+	pkt.SrcPort, pkt.DstPort = pkt.DstPort, pkt.SrcPort
+	pkt.SrcKube, pkt.DstKube = pkt.DstKube, pkt.SrcKube
+	pkt.SrcHost, pkt.DstHost = pkt.DstHost, pkt.SrcHost
+	pkt.SrcGeo, pkt.DstGeo = pkt.DstGeo, pkt.SrcGeo
+	return pkt
+}
 
-	binary.BigEndian.PutUint16(tmp, uint16(pkt.Protocol))
-	proto = append(proto, tmp...)
+// Size is the size of the packet_t struct of the eBPF program
+const Size = 48
 
-	return hash(src) + hash(dst) + hash(proto)
-}
+// SchemaVersion is bumped whenever the layout of packet_t changes,
+// so that recorded events are not decoded with the wrong layout
+const SchemaVersion = 1
 
 // UnmarshalBinary builds and fills up the Packet struct coming from eBPF map
 func UnmarshalBinary(in []byte) (Packet, bool) {
+	if len(in) < Size {
+		return Packet{}, false
+	}
+
 	srcIP, ok := netip.AddrFromSlice(in[0:16])
 
 	if !ok {
@@ -86,50 +107,56 @@ var ipProtoNums = map[uint8]string{
 	17: "UDP",
 }
 
-// CalcLatency calculates and displays flow latencies
-func CalcLatency(pkt Packet, table *flowtable.FlowTable) {
+// ProtocolName returns the name of an IP protocol number
+func ProtocolName(protocol uint8) string {
+	if proto, ok := ipProtoNums[protocol]; ok {
+		return proto
+	}
+	return fmt.Sprint(protocol)
+}
+
+// Result is a request paired with the reply that completed its flow
+type Result struct {
+	Request Packet
+	Reply   Packet
+	Latency time.Duration
+}
+
+// CalcLatency pairs packets with the pending flows they complete.
+// It returns the result when pkt completes a pending flow
+func CalcLatency(pkt Packet, table *flowtable.FlowTable[Packet]) (Result, bool) {
 	proto, ok := ipProtoNums[pkt.Protocol]
 
+	// The eBPF program only sends TCP and UDP
 	if !ok {
-		log.Print("Failed fetching protocol number: ", pkt.Protocol)
-		return
+		return Result{}, false
 	}
 
-	pktHash := pkt.Hash()
+	flowKey := pkt.FlowKey()
 
-	ts, ok := table.Get(pktHash)
+	initiator, ok := table.Get(flowKey)
 
 	if !ok && pkt.Syn {
-		table.Insert(pktHash, pkt.TimeStamp)
-		return
+		table.Insert(flowKey, pkt.TimeStamp, pkt)
+		return Result{}, false
 	} else if !ok && proto == "UDP" {
-		table.Insert(pktHash, pkt.TimeStamp)
-		return
+		table.Insert(flowKey, pkt.TimeStamp, pkt)
+		return Result{}, false
 	} else if !ok {
-		return
+		return Result{}, false
 	}
 
-	if pkt.Ack {
-		colorCyan("(%v) | src: %v:%-7v\tdst: %v:%-9v\tTTL: %-4v\tlatency: %.3f ms\n",
-			proto,
-			pkt.DstIP.Unmap().String(),
-			pkt.DstPort,
-			pkt.SrcIP.Unmap().String(),
-			pkt.SrcPort,
-			pkt.TTL,
-			(float64(pkt.TimeStamp)-float64(ts))/1_000_000,
-		)
-		table.Remove(pktHash)
-	} else if proto == "UDP" {
-		colorLightYellow("(%v) | src: %v:%-7v\tdst: %v:%-9v\tTTL: %-4v\tlatency: %.3f ms\n",
-			proto,
-			pkt.DstIP.Unmap().String(),
-			pkt.DstPort,
-			pkt.SrcIP.Unmap().String(),
-			pkt.SrcPort,
-			pkt.TTL,
-			(float64(pkt.TimeStamp)-float64(ts))/1_000_000,
-		)
-		table.Remove(pktHash)
+	if pkt.Ack || proto == "UDP" {
+		table.Remove(flowKey)
+
+		// Captures merged from several queues or interfaces can be slightly
+		// out of order, a reply seen before its request has no latency
+		if pkt.TimeStamp < initiator.TimeStamp {
+			return Result{}, false
+		}
+
+		return Result{Request: initiator, Reply: pkt, Latency: time.Duration(pkt.TimeStamp - initiator.TimeStamp)}, true
 	}
+
+	return Result{}, false
 }
@@ -1,9 +1,14 @@
 package packet
 
 import (
+	"math/rand"
 	"net/netip"
+	"reflect"
 	"testing"
+	"testing/quick"
+	"time"
 
+	"github.com/pouriyajamshidi/flat/internal/flowtable"
 	"github.com/stretchr/testify/require"
 )
 
@@ -21,5 +26,132 @@ func TestHashReverseCollision(t *testing.T) {
 		DstPort: 53264,
 	}
 
-	require.Equal(t, pakcetOutgoing.Hash(), pakcetIncoming.Hash())
+	require.Equal(t, pakcetOutgoing.FlowKey(), pakcetIncoming.FlowKey())
+}
+
+func TestFlowKeySwappedPorts(t *testing.T) {
+	first := Packet{
+		SrcIP:    netip.MustParseAddr("10.0.0.1"),
+		DstIP:    netip.MustParseAddr("10.0.0.2"),
+		SrcPort:  1000,
+		DstPort:  2000,
+		Protocol: 6,
+	}
+	second := first
+	second.SrcPort, second.DstPort = first.DstPort, first.SrcPort
+
+	require.NotEqual(t, first.FlowKey(), second.FlowKey())
+}
+
+func randomAddr(rand *rand.Rand) netip.Addr {
+	// Draw from a small pool so that related flows are generated often
+	if rand.Intn(2) == 0 {
+		return netip.AddrFrom4([4]byte{10, 0, 0, byte(rand.Intn(4))})
+	}
+
+	return netip.AddrFrom16([16]byte{0xfd, 15: byte(rand.Intn(4))})
+}
+
+// Generate satisfies quick.Generator and builds random packets
+func (Packet) Generate(rand *rand.Rand, _ int) reflect.Value {
+	return reflect.ValueOf(Packet{
+		SrcIP:     randomAddr(rand),
+		DstIP:     randomAddr(rand),
+		SrcPort:   uint16(rand.Intn(4)),
+		DstPort:   uint16(rand.Intn(4)),
+		Protocol:  []uint8{6, 17}[rand.Intn(2)],
+		Interface: rand.Intn(2),
+	})
+}
+
+func sameFlow(a, b Packet) bool {
+	if a.Protocol != b.Protocol || a.Interface != b.Interface {
+		return false
+	}
+
+	forward := a.SrcIP == b.SrcIP && a.SrcPort == b.SrcPort && a.DstIP == b.DstIP && a.DstPort == b.DstPort
+	backward := a.SrcIP == b.DstIP && a.SrcPort == b.DstPort && a.DstIP == b.SrcIP && a.DstPort == b.SrcPort
+
+	return forward || backward
+}
+
+func TestFlowKeyDirectionSymmetry(t *testing.T) {
+	symmetric := func(pkt Packet) bool {
+		reply := pkt.Reverse()
+		return pkt.FlowKey() == reply.FlowKey() && reflect.DeepEqual(pkt, reply.Reverse())
+	}
+
+	require.NoError(t, quick.Check(symmetric, &quick.Config{MaxCount: 10_000}))
+}
+
+func TestFlowKeyNoCollisions(t *testing.T) {
+	collisionFree := func(a, b Packet) bool {
+		return (a.FlowKey() == b.FlowKey()) == sameFlow(a, b)
+	}
+
+	require.NoError(t, quick.Check(collisionFree, &quick.Config{MaxCount: 100_000}))
+}
+
+func TestFlowKeyMappedAddresses(t *testing.T) {
+	mapped := Packet{
+		SrcIP:   netip.MustParseAddr("::ffff:192.168.0.156"),
+		DstIP:   netip.MustParseAddr("::ffff:1.1.1.1"),
+		SrcPort: 53264,
+		DstPort: 53,
+	}
+	plain := Packet{
+		SrcIP:   netip.MustParseAddr("1.1.1.1"),
+		DstIP:   netip.MustParseAddr("192.168.0.156"),
+		SrcPort: 53,
+		DstPort: 53264,
+	}
+
+	require.Equal(t, mapped.FlowKey(), plain.FlowKey())
+}
+
+func TestCalcLatency(t *testing.T) {
+	table := flowtable.NewFlowTable[Packet](flowtable.DefaultConfig())
+
+	syn := Packet{
+		SrcIP:     netip.MustParseAddr("::ffff:192.168.0.156"),
+		DstIP:     netip.MustParseAddr("::ffff:1.1.1.1"),
+		SrcPort:   53264,
+		DstPort:   443,
+		Protocol:  6,
+		Syn:       true,
+		TimeStamp: 1_000_000,
+	}
+
+	_, ok := CalcLatency(syn, table)
+	require.False(t, ok)
+	require.Equal(t, 1, table.Entries())
+
+	synAck := syn.Reverse()
+	synAck.Ack = true
+	synAck.TimeStamp = 3_500_000
+
+	result, ok := CalcLatency(synAck, table)
+	require.True(t, ok)
+	require.Equal(t, time.Microsecond*2500, result.Latency)
+	require.Equal(t, syn, result.Request)
+	require.Equal(t, synAck, result.Reply)
+	require.Equal(t, 0, table.Entries())
+
+	// Segments without a pending SYN are ignored
+	segment := synAck
+	segment.Syn = false
+
+	_, ok = CalcLatency(segment, table)
+	require.False(t, ok)
+	require.Equal(t, 0, table.Entries())
+
+	// A reply stamped before its request completes the flow without a latency
+	_, ok = CalcLatency(syn, table)
+	require.False(t, ok)
+
+	synAck.TimeStamp = 500_000
+
+	_, ok = CalcLatency(synAck, table)
+	require.False(t, ok)
+	require.Equal(t, 0, table.Entries())
 }
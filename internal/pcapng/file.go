@@ -0,0 +1,145 @@
+package pcapng
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// countingWriter keeps track of the size of the current file
+type countingWriter struct {
+	w    *bufio.Writer
+	size int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.size += int64(n)
+	return n, err
+}
+
+// rotateRetry is how long the current file is kept
+// after it could not be rotated, before trying again
+const rotateRetry = time.Minute
+
+type iface struct {
+	name    string
+	snapLen uint32
+}
+
+// File is a pcapng file that is rotated by size or age. Rotated files are
+// renamed after the time they were opened at, e.g. out-20240101T150405.000.pcapng
+type File struct {
+	path    string
+	maxSize int64
+	maxAge  time.Duration
+
+	file       *os.File
+	counter    *countingWriter
+	writer     *Writer
+	opened     time.Time
+	failed     time.Time // of the last rotation that failed
+	interfaces []iface
+}
+
+// Create creates a pcapng file at path, rotating it once it exceeds
+// maxSize bytes or maxAge. Either limit is disabled when zero
+func Create(path string, maxSize int64, maxAge time.Duration) (*File, error) {
+	file := &File{path: path, maxSize: maxSize, maxAge: maxAge}
+
+	if err := file.open(); err != nil {
+		return nil, err
+	}
+
+	return file, nil
+}
+
+func (file *File) open() error {
+	f, err := os.Create(file.path)
+
+	if err != nil {
+		return err
+	}
+
+	file.file = f
+	file.counter = &countingWriter{w: bufio.NewWriter(f)}
+	file.opened = time.Now()
+
+	file.writer, err = NewWriter(file.counter)
+
+	if err != nil {
+		return err
+	}
+
+	for _, current := range file.interfaces {
+		if _, err := file.writer.AddInterface(current.name, current.snapLen); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rotatedPath names a rotated file after the time it was opened at
+func (file *File) rotatedPath() string {
+	ext := filepath.Ext(file.path)
+	return strings.TrimSuffix(file.path, ext) + "-" + file.opened.Format("20060102T150405.000") + ext
+}
+
+// rotate renames the current file and opens a new one. The file is renamed
+// while still open, so that it is kept and written to when that fails
+func (file *File) rotate() error {
+	if err := os.Rename(file.path, file.rotatedPath()); err != nil {
+		return err
+	}
+
+	return errors.Join(file.Close(), file.open())
+}
+
+// AddInterface describes an Ethernet interface in this and every rotated file
+func (file *File) AddInterface(name string, snapLen uint32) (uint32, error) {
+	file.interfaces = append(file.interfaces, iface{name: name, snapLen: snapLen})
+
+	return file.writer.AddInterface(name, snapLen)
+}
+
+// WritePacket writes a frame, rotating the file first if needed. When the
+// rotation fails, the frame is still written to the current file
+func (file *File) WritePacket(iface uint32, timestamp time.Time, data []byte, length int, comment string) error {
+	var rotateErr error
+
+	if file.due() {
+		if rotateErr = file.rotate(); rotateErr != nil {
+			file.failed = time.Now()
+		}
+	}
+
+	return errors.Join(rotateErr, file.writer.WritePacket(iface, timestamp, data, length, comment))
+}
+
+// due tells whether the file has to be rotated
+func (file *File) due() bool {
+	if time.Since(file.failed) < rotateRetry {
+		return false
+	}
+
+	return (file.maxSize > 0 && file.counter.size >= file.maxSize) || (file.maxAge > 0 && time.Since(file.opened) >= file.maxAge)
+}
+
+// Flush writes the buffered packets to disk
+func (file *File) Flush() error {
+	return file.counter.w.Flush()
+}
+
+// Close flushes and closes the file
+func (file *File) Close() error {
+	if err := file.Flush(); err != nil {
+		file.file.Close()
+		return err
+	}
+
+	return file.file.Close()
+}
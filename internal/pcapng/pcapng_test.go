@@ -0,0 +1,277 @@
+package pcapng
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/pouriyajamshidi/flat/internal/packet"
+	"github.com/stretchr/testify/require"
+)
+
+func tcpFrame(t *testing.T, src, dst netip.AddrPort, tcp *layers.TCP) []byte {
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 5},
+		DstMAC:       net.HardwareAddr{5, 4, 3, 2, 1, 0},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip := &layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		SrcIP:    src.Addr().AsSlice(),
+		DstIP:    dst.Addr().AsSlice(),
+		Protocol: layers.IPProtocolTCP,
+	}
+	tcp.SrcPort = layers.TCPPort(src.Port())
+	tcp.DstPort = layers.TCPPort(dst.Port())
+	tcp.Window = 64240
+	tcp.Options = []layers.TCPOption{{OptionType: layers.TCPOptionKindMSS, OptionLength: 4, OptionData: []byte{0x05, 0xb4}}}
+	require.NoError(t, tcp.SetNetworkLayerForChecksum(ip))
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	require.NoError(t, gopacket.SerializeLayers(buf, opts, eth, ip, tcp, gopacket.Payload(make([]byte, 200))))
+
+	return buf.Bytes()
+}
+
+func read(t *testing.T, path string) ([]gopacket.CaptureInfo, [][]byte, *pcapgo.NgReader) {
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { f.Close() })
+
+	reader, err := pcapgo.NewNgReader(f, pcapgo.DefaultNgReaderOptions)
+	require.NoError(t, err)
+
+	var infos []gopacket.CaptureInfo
+	var frames [][]byte
+
+	for {
+		data, info, err := reader.ReadPacketData()
+		if err != nil {
+			break
+		}
+
+		infos = append(infos, info)
+		frames = append(frames, data)
+	}
+
+	return infos, frames, reader
+}
+
+func TestWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.pcapng")
+
+	file, err := Create(path, 0, 0)
+	require.NoError(t, err)
+
+	id, err := file.AddInterface("eth0", SnapLen)
+	require.NoError(t, err)
+
+	frame := tcpFrame(t, netip.MustParseAddrPort("192.168.0.156:53264"), netip.MustParseAddrPort("1.1.1.1:443"), &layers.TCP{SYN: true})
+	timestamp := time.Unix(1700000000, 123456789)
+
+	require.NoError(t, file.WritePacket(id, timestamp, frame[:SnapLen], len(frame), "flat: request"))
+	require.NoError(t, file.Close())
+
+	infos, frames, reader := read(t, path)
+	require.Len(t, infos, 1)
+	require.Equal(t, timestamp, infos[0].Timestamp.In(time.Local))
+	require.Equal(t, SnapLen, infos[0].CaptureLength)
+	require.Equal(t, len(frame), infos[0].Length)
+	require.Equal(t, frame[:SnapLen], frames[0])
+
+	iface, err := reader.Interface(0)
+	require.NoError(t, err)
+	require.Equal(t, "eth0", iface.Name)
+	require.Equal(t, layers.LinkTypeEthernet, iface.LinkType)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.True(t, bytes.Contains(data, []byte("flat: request")))
+}
+
+func TestRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "out.pcapng")
+
+	file, err := Create(path, 512, 0)
+	require.NoError(t, err)
+
+	id, err := file.AddInterface("eth0", SnapLen)
+	require.NoError(t, err)
+
+	frame := make([]byte, SnapLen)
+
+	for i := range 10 {
+		file.opened = file.opened.Add(-time.Duration(i) * time.Second) // keep rotated names unique
+		require.NoError(t, file.WritePacket(id, time.Now(), frame, len(frame), ""))
+	}
+
+	require.NoError(t, file.Close())
+
+	rotated, err := filepath.Glob(filepath.Join(dir, "out-*.pcapng"))
+	require.NoError(t, err)
+	require.NotEmpty(t, rotated)
+
+	// Every file starts with its own section and interface blocks
+	var total int
+
+	for _, name := range append(rotated, path) {
+		infos, _, reader := read(t, name)
+		total += len(infos)
+
+		iface, err := reader.Interface(0)
+		require.NoError(t, err)
+		require.Equal(t, "eth0", iface.Name)
+	}
+
+	require.Equal(t, 10, total)
+}
+
+func TestRotationReadOnlyDirectory(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root can write to read-only directories")
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "out.pcapng")
+
+	file, err := Create(path, 1, 0)
+	require.NoError(t, err)
+
+	id, err := file.AddInterface("eth0", SnapLen)
+	require.NoError(t, err)
+
+	require.NoError(t, os.Chmod(dir, 0o555))
+	defer os.Chmod(dir, 0o755)
+
+	frame := make([]byte, SnapLen)
+
+	// The file cannot be renamed, the packets keep going to it
+	// and the rotation is not tried again for every packet
+	require.Error(t, file.WritePacket(id, time.Now(), frame, len(frame), ""))
+	require.NoError(t, file.WritePacket(id, time.Now(), frame, len(frame), ""))
+	require.NoError(t, file.Close())
+
+	infos, _, _ := read(t, path)
+	require.Len(t, infos, 2)
+}
+
+func TestRecorder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.pcapng")
+
+	file, err := Create(path, 0, 0)
+	require.NoError(t, err)
+
+	recorder, err := NewRecorder(file, "eth0", 1, time.Minute)
+	require.NoError(t, err)
+
+	client := netip.MustParseAddrPort("192.168.0.156:53264")
+	server := netip.MustParseAddrPort("1.1.1.1:443")
+
+	syn := tcpFrame(t, client, server, &layers.TCP{SYN: true})
+	synAck := tcpFrame(t, server, client, &layers.TCP{SYN: true, ACK: true})
+	other := tcpFrame(t, netip.MustParseAddrPort("192.168.0.156:53265"), server, &layers.TCP{SYN: true})
+
+	recorder.HandleFrame(syn)
+	recorder.HandleFrame(other)
+	recorder.HandleFrame(synAck)
+
+	reply := packet.Packet{
+		SrcIP:     netip.AddrFrom16(server.Addr().As16()),
+		DstIP:     netip.AddrFrom16(client.Addr().As16()),
+		SrcPort:   server.Port(),
+		DstPort:   client.Port(),
+		Protocol:  6,
+		Syn:       true,
+		Ack:       true,
+		TimeStamp: 5_012_000_000,
+	}
+
+	request := reply.Reverse()
+	request.Ack = false
+	request.TimeStamp = 5_000_000_000
+
+	recorder.boot = time.Unix(1700000000, 0)
+	recorder.Latency(packet.Result{Request: request, Reply: reply, Latency: 12 * time.Millisecond})
+
+	// Nothing is written before the capture had time to catch up
+	recorder.resolve(time.Now(), false)
+	require.Len(t, recorder.pending, 1)
+
+	recorder.resolve(time.Now().Add(matchDelay), false)
+	require.NoError(t, file.Close())
+
+	infos, frames, _ := read(t, path)
+	require.Len(t, infos, 2)
+	require.Equal(t, syn[:SnapLen], frames[0])
+	require.Equal(t, synAck[:SnapLen], frames[1])
+	require.Equal(t, len(syn), infos[0].Length)
+	require.Equal(t, time.Unix(1700000005, 0).UnixNano(), infos[0].Timestamp.UnixNano())
+	require.Equal(t, time.Unix(1700000005, 12_000_000).UnixNano(), infos[1].Timestamp.UnixNano())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.True(t, bytes.Contains(data, []byte("flat: TCP latency 12.000 ms")))
+}
+
+func TestRecorderLoopback(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("capturing requires root")
+	}
+
+	lo, err := net.InterfaceByName("lo")
+	require.NoError(t, err)
+
+	file, err := Create(filepath.Join(t.TempDir(), "out.pcapng"), 0, 0)
+	require.NoError(t, err)
+
+	recorder, err := NewRecorder(file, "lo", lo.Index, time.Minute)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		recorder.Run(ctx)
+	}()
+
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	server := netip.MustParseAddrPort(listener.Addr().String())
+
+	// The SYN and SYN/ACK of a handshake are kept, its other segments are filtered out
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp4", listener.Addr().String())
+		require.NoError(t, err)
+		conn.Write([]byte("data"))
+		client := netip.MustParseAddrPort(conn.LocalAddr().String())
+		conn.Close()
+
+		time.Sleep(time.Millisecond * 50)
+
+		recorder.mu.Lock()
+		defer recorder.mu.Unlock()
+
+		_, syn := recorder.frames[frameKey{src: client, dst: server, protocol: 6, syn: true}]
+		_, synAck := recorder.frames[frameKey{src: server, dst: client, protocol: 6, syn: true, ack: true}]
+		_, data := recorder.frames[frameKey{src: client, dst: server, protocol: 6, ack: true}]
+
+		return syn && synAck && !data
+	}, time.Second*5, time.Millisecond*100)
+
+	cancel()
+	<-done
+}
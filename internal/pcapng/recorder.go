@@ -0,0 +1,309 @@
+package pcapng
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/pouriyajamshidi/flat/internal/baseline"
+	"github.com/pouriyajamshidi/flat/internal/capture"
+	"github.com/pouriyajamshidi/flat/internal/packet"
+	"github.com/pouriyajamshidi/flat/internal/timer"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// SnapLen keeps the Ethernet, IP and TCP headers including options
+	SnapLen = 128
+
+	// maxFrameKeys and framesPerKey bound the memory used by captured frames
+	maxFrameKeys = 16384
+	framesPerKey = 4
+
+	// matchDelay gives the capture socket time to catch up
+	// with the eBPF events before frames are looked up
+	matchDelay = time.Millisecond * 250
+
+	resolveInterval = time.Millisecond * 100
+)
+
+// tcpSYNOrUDP is a classic BPF program matching "tcp[tcpflags] & tcp-syn != 0 or udp"
+// for both IPv4 and IPv6 Ethernet frames, truncated to SnapLen
+var tcpSYNOrUDP = []unix.SockFilter{
+	{Code: unix.BPF_LD | unix.BPF_H | unix.BPF_ABS, K: 12},                     // 0: ethertype
+	{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, Jt: 0, Jf: 5, K: 0x86dd},  // 1: IPv6?
+	{Code: unix.BPF_LD | unix.BPF_B | unix.BPF_ABS, K: 20},                     // 2: IPv6 next header
+	{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, Jt: 12, Jf: 0, K: 17},     // 3: UDP?
+	{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, Jt: 0, Jf: 12, K: 6},      // 4: TCP?
+	{Code: unix.BPF_LD | unix.BPF_B | unix.BPF_ABS, K: 67},                     // 5: TCP flags
+	{Code: unix.BPF_JMP | unix.BPF_JSET | unix.BPF_K, Jt: 9, Jf: 10, K: 0x02},  // 6: SYN?
+	{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, Jt: 0, Jf: 9, K: 0x0800},  // 7: IPv4?
+	{Code: unix.BPF_LD | unix.BPF_H | unix.BPF_ABS, K: 20},                     // 8: fragment offset
+	{Code: unix.BPF_JMP | unix.BPF_JSET | unix.BPF_K, Jt: 7, Jf: 0, K: 0x1fff}, // 9: fragmented?
+	{Code: unix.BPF_LD | unix.BPF_B | unix.BPF_ABS, K: 23},                     // 10: IPv4 protocol
+	{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, Jt: 4, Jf: 0, K: 17},      // 11: UDP?
+	{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, Jt: 0, Jf: 4, K: 6},       // 12: TCP?
+	{Code: unix.BPF_LDX | unix.BPF_B | unix.BPF_MSH, K: 14},                    // 13: IPv4 header length
+	{Code: unix.BPF_LD | unix.BPF_B | unix.BPF_IND, K: 27},                     // 14: TCP flags
+	{Code: unix.BPF_JMP | unix.BPF_JSET | unix.BPF_K, Jt: 0, Jf: 1, K: 0x02},   // 15: SYN?
+	{Code: unix.BPF_RET | unix.BPF_K, K: SnapLen},                              // 16: accept
+	{Code: unix.BPF_RET | unix.BPF_K, K: 0},                                    // 17: drop
+}
+
+// frameKey identifies the frames of one direction of a flow with the
+// same TCP flags, the way the eBPF program describes a packet
+type frameKey struct {
+	src      netip.AddrPort
+	dst      netip.AddrPort
+	protocol uint8
+	syn      bool
+	ack      bool
+}
+
+func packetKey(pkt packet.Packet) frameKey {
+	return frameKey{src: pkt.SrcAddrPort(), dst: pkt.DstAddrPort(), protocol: pkt.Protocol, syn: pkt.Syn, ack: pkt.Ack}
+}
+
+type frame struct {
+	data   []byte
+	length int
+	seen   time.Time
+}
+
+type wanted struct {
+	key       frameKey
+	timestamp uint64
+	comment   string
+}
+
+type pending struct {
+	packets []wanted
+	due     time.Time
+}
+
+// Recorder captures the frames of an interface and writes those
+// that contributed to a reported result to a pcapng file
+type Recorder struct {
+	file      *File
+	ifaceID   uint32
+	ifindex   int
+	retention time.Duration
+	boot      time.Time
+
+	mu      sync.Mutex
+	frames  map[frameKey][]frame
+	pending []pending
+}
+
+// NewRecorder constructs a new Recorder for an interface. Frames are kept
+// for retention, which should exceed the flow table timeouts so that
+// the requests of timed out flows can still be written
+func NewRecorder(file *File, ifaceName string, ifindex int, retention time.Duration) (*Recorder, error) {
+	id, err := file.AddInterface(ifaceName, SnapLen)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Recorder{
+		file:      file,
+		ifaceID:   id,
+		ifindex:   ifindex,
+		retention: retention,
+		boot:      timer.BootTime(),
+		frames:    make(map[frameKey][]frame),
+	}, nil
+}
+
+// HandleFrame keeps a snapshot of a TCP SYN or UDP Ethernet frame
+func (r *Recorder) HandleFrame(data []byte) {
+	pkt := gopacket.NewPacket(data, layers.LayerTypeEthernet, gopacket.DecodeOptions{Lazy: true, NoCopy: true})
+
+	var key frameKey
+	var srcIP, dstIP netip.Addr
+	var length int
+
+	switch network := pkt.NetworkLayer().(type) {
+	case *layers.IPv4:
+		srcIP, _ = netip.AddrFromSlice(network.SrcIP.To4())
+		dstIP, _ = netip.AddrFromSlice(network.DstIP.To4())
+		length = 14 + int(network.Length)
+	case *layers.IPv6:
+		srcIP, _ = netip.AddrFromSlice(network.SrcIP)
+		dstIP, _ = netip.AddrFromSlice(network.DstIP)
+		length = 14 + 40 + int(network.Length)
+	default:
+		return
+	}
+
+	switch transport := pkt.TransportLayer().(type) {
+	case *layers.TCP:
+		key = frameKey{
+			src:      netip.AddrPortFrom(srcIP, uint16(transport.SrcPort)),
+			dst:      netip.AddrPortFrom(dstIP, uint16(transport.DstPort)),
+			protocol: 6,
+			syn:      transport.SYN,
+			ack:      transport.ACK,
+		}
+	case *layers.UDP:
+		key = frameKey{
+			src:      netip.AddrPortFrom(srcIP, uint16(transport.SrcPort)),
+			dst:      netip.AddrPortFrom(dstIP, uint16(transport.DstPort)),
+			protocol: 17,
+		}
+	default:
+		return
+	}
+
+	snapshot := frame{data: append([]byte(nil), data[:min(len(data), SnapLen)]...), length: length, seen: time.Now()}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	frames, ok := r.frames[key]
+
+	if !ok && len(r.frames) >= maxFrameKeys {
+		return
+	}
+
+	if len(frames) >= framesPerKey {
+		frames = frames[1:]
+	}
+
+	r.frames[key] = append(frames, snapshot)
+}
+
+func (r *Recorder) enqueue(packets ...wanted) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.pending = append(r.pending, pending{packets: packets, due: time.Now().Add(matchDelay)})
+}
+
+// Latency satisfies the output.Sink interface
+func (r *Recorder) Latency(result packet.Result) {
+	r.enqueue(
+		wanted{key: packetKey(result.Request), timestamp: result.Request.TimeStamp, comment: "flat: request"},
+		wanted{key: packetKey(result.Reply), timestamp: result.Reply.TimeStamp, comment: fmt.Sprintf("flat: %v latency %.3f ms",
+			packet.ProtocolName(result.Reply.Protocol), float64(result.Latency)/float64(time.Millisecond))},
+	)
+}
+
+// Timeout satisfies the output.Sink interface
+func (r *Recorder) Timeout(request packet.Packet) {
+	r.enqueue(wanted{key: packetKey(request), timestamp: request.TimeStamp, comment: "flat: request timed out"})
+}
+
+// Refused satisfies the output.Sink interface
+func (r *Recorder) Refused(request packet.Packet) {
+	r.enqueue(wanted{key: packetKey(request), timestamp: request.TimeStamp, comment: "flat: request refused with a RST"})
+}
+
+// Anomaly satisfies the output.Sink interface, the packets
+// of an anomaly are already written as its latency
+func (r *Recorder) Anomaly(packet.Result, baseline.Anomaly) {}
+
+// closest returns the frame seen closest to when the eBPF program saw it.
+// Callers must hold the lock
+func (r *Recorder) closest(key frameKey, at time.Time) (frame, bool) {
+	var best frame
+	var found bool
+
+	for _, candidate := range r.frames[key] {
+		if !found || candidate.seen.Sub(at).Abs() < best.seen.Sub(at).Abs() {
+			best = candidate
+			found = true
+		}
+	}
+
+	return best, found
+}
+
+// resolve writes the frames of the results that are due and forgets old frames
+func (r *Recorder) resolve(now time.Time, all bool) {
+	r.mu.Lock()
+
+	var due []pending
+
+	for len(r.pending) > 0 && (all || !r.pending[0].due.After(now)) {
+		due = append(due, r.pending[0])
+		r.pending = r.pending[1:]
+	}
+
+	type match struct {
+		frame
+		wanted
+	}
+
+	var matches []match
+
+	for _, result := range due {
+		for _, want := range result.packets {
+			at := r.boot.Add(time.Duration(want.timestamp))
+
+			if found, ok := r.closest(want.key, at); ok {
+				matches = append(matches, match{found, want})
+			}
+		}
+	}
+
+	for key, frames := range r.frames {
+		for len(frames) > 0 && now.Sub(frames[0].seen) > r.retention {
+			frames = frames[1:]
+		}
+
+		if len(frames) == 0 {
+			delete(r.frames, key)
+		} else {
+			r.frames[key] = frames
+		}
+	}
+
+	r.mu.Unlock()
+
+	for _, m := range matches {
+		at := r.boot.Add(time.Duration(m.timestamp))
+
+		if err := r.file.WritePacket(r.ifaceID, at, m.data, m.length, m.comment); err != nil {
+			log.Printf("Failed writing to pcapng file: %v", err)
+		}
+	}
+
+	if len(matches) > 0 {
+		if err := r.file.Flush(); err != nil {
+			log.Printf("Failed writing to pcapng file: %v", err)
+		}
+	}
+}
+
+// Run captures frames and writes the matching ones until ctx is
+// cancelled, then writes what is left and closes the file
+func (r *Recorder) Run(ctx context.Context) {
+	go func() {
+		if err := capture.Capture(ctx, r.ifindex, tcpSYNOrUDP, r.HandleFrame); err != nil {
+			log.Printf("Failed capturing packets for pcapng file: %v", err)
+		}
+	}()
+
+	ticker := time.NewTicker(resolveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			r.resolve(time.Now(), true)
+
+			if err := r.file.Close(); err != nil {
+				log.Printf("Failed closing pcapng file: %v", err)
+			}
+			return
+		case now := <-ticker.C:
+			r.resolve(now, false)
+		}
+	}
+}
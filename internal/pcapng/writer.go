@@ -0,0 +1,129 @@
+package pcapng
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+// Block types and options of the pcapng format, see
+// https://www.ietf.org/archive/id/draft-ietf-opsawg-pcapng-01.html
+const (
+	blockSectionHeader    = 0x0a0d0d0a
+	blockInterface        = 0x00000001
+	blockEnhancedPacket   = 0x00000006
+	byteOrderMagic        = 0x1a2b3c4d
+	linkTypeEthernet      = 1
+	optionEnd             = 0
+	optionComment         = 1
+	optionInterfaceName   = 2
+	optionTimestampResol  = 9
+	nanosecondResolution  = 9
+	sectionLengthUnknown  = 0xffffffffffffffff
+	blockHeaderAndTrailer = 12
+)
+
+type option struct {
+	code  uint16
+	value []byte
+}
+
+func pad(length int) int {
+	return (4 - length%4) % 4
+}
+
+// encodeOptions encodes options followed by the end of options marker
+func encodeOptions(options []option) []byte {
+	if len(options) == 0 {
+		return nil
+	}
+
+	var buf []byte
+
+	for _, opt := range options {
+		buf = binary.LittleEndian.AppendUint16(buf, opt.code)
+		buf = binary.LittleEndian.AppendUint16(buf, uint16(len(opt.value)))
+		buf = append(buf, opt.value...)
+		buf = append(buf, make([]byte, pad(len(opt.value)))...)
+	}
+
+	buf = binary.LittleEndian.AppendUint16(buf, optionEnd)
+	buf = binary.LittleEndian.AppendUint16(buf, 0)
+
+	return buf
+}
+
+// Writer writes Ethernet frames in the pcapng format
+type Writer struct {
+	w          io.Writer
+	interfaces uint32
+}
+
+// NewWriter writes the section header and constructs a new Writer
+func NewWriter(w io.Writer) (*Writer, error) {
+	writer := &Writer{w: w}
+
+	body := binary.LittleEndian.AppendUint32(nil, byteOrderMagic)
+	body = binary.LittleEndian.AppendUint16(body, 1) // major version
+	body = binary.LittleEndian.AppendUint16(body, 0) // minor version
+	body = binary.LittleEndian.AppendUint64(body, sectionLengthUnknown)
+
+	if err := writer.writeBlock(blockSectionHeader, body); err != nil {
+		return nil, err
+	}
+
+	return writer, nil
+}
+
+func (writer *Writer) writeBlock(blockType uint32, body []byte) error {
+	length := uint32(len(body) + blockHeaderAndTrailer)
+
+	block := binary.LittleEndian.AppendUint32(nil, blockType)
+	block = binary.LittleEndian.AppendUint32(block, length)
+	block = append(block, body...)
+	block = binary.LittleEndian.AppendUint32(block, length)
+
+	_, err := writer.w.Write(block)
+
+	return err
+}
+
+// AddInterface describes an Ethernet interface with nanosecond
+// timestamps and returns the ID packets refer to it by
+func (writer *Writer) AddInterface(name string, snapLen uint32) (uint32, error) {
+	body := binary.LittleEndian.AppendUint16(nil, linkTypeEthernet)
+	body = binary.LittleEndian.AppendUint16(body, 0) // reserved
+	body = binary.LittleEndian.AppendUint32(body, snapLen)
+	body = append(body, encodeOptions([]option{
+		{code: optionInterfaceName, value: []byte(name)},
+		{code: optionTimestampResol, value: []byte{nanosecondResolution}},
+	})...)
+
+	if err := writer.writeBlock(blockInterface, body); err != nil {
+		return 0, err
+	}
+
+	writer.interfaces++
+
+	return writer.interfaces - 1, nil
+}
+
+// WritePacket writes a frame captured on an interface. length is the
+// size of the frame on the wire, which may exceed the captured data
+func (writer *Writer) WritePacket(iface uint32, timestamp time.Time, data []byte, length int, comment string) error {
+	ts := uint64(timestamp.UnixNano())
+
+	body := binary.LittleEndian.AppendUint32(nil, iface)
+	body = binary.LittleEndian.AppendUint32(body, uint32(ts>>32))
+	body = binary.LittleEndian.AppendUint32(body, uint32(ts))
+	body = binary.LittleEndian.AppendUint32(body, uint32(len(data)))
+	body = binary.LittleEndian.AppendUint32(body, uint32(max(length, len(data))))
+	body = append(body, data...)
+	body = append(body, make([]byte, pad(len(data)))...)
+
+	if comment != "" {
+		body = append(body, encodeOptions([]option{{code: optionComment, value: []byte(comment)}})...)
+	}
+
+	return writer.writeBlock(blockEnhancedPacket, body)
+}
@@ -0,0 +1,50 @@
+package pending
+
+import (
+	"cmp"
+	"slices"
+
+	"github.com/pouriyajamshidi/flat/internal/flowtable"
+	"github.com/pouriyajamshidi/flat/internal/packet"
+)
+
+// Flow is a request waiting for a reply, as listed by the API and flat ctl
+type Flow struct {
+	Protocol  string `json:"protocol"`
+	SrcIP     string `json:"src_ip"`
+	SrcPort   uint16 `json:"src_port"`
+	DstIP     string `json:"dst_ip"`
+	DstPort   uint16 `json:"dst_port"`
+	PendingNs int64  `json:"pending_ns"` // how long the request has been waiting for a reply
+	PID       uint32 `json:"pid,omitempty"`
+	Comm      string `json:"comm,omitempty"`
+}
+
+// Flows lists the pending requests of a flow table at the
+// monotonic time now, the longest waiting first
+func Flows(flowTable *flowtable.FlowTable[packet.Packet], now uint64) []Flow {
+	pending := flowTable.Flows()
+
+	slices.SortFunc(pending, func(x, y flowtable.Flow[packet.Packet]) int {
+		return cmp.Compare(x.Timestamp, y.Timestamp)
+	})
+
+	flows := make([]Flow, 0, len(pending))
+
+	for _, flow := range pending {
+		request := flow.Value
+
+		flows = append(flows, Flow{
+			Protocol:  packet.ProtocolName(request.Protocol),
+			SrcIP:     request.SrcIP.Unmap().String(),
+			SrcPort:   request.SrcPort,
+			DstIP:     request.DstIP.Unmap().String(),
+			DstPort:   request.DstPort,
+			PendingNs: int64(now - min(now, flow.Timestamp)),
+			PID:       request.PID,
+			Comm:      request.Comm,
+		})
+	}
+
+	return flows
+}
@@ -0,0 +1,43 @@
+package pending
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/pouriyajamshidi/flat/internal/flowtable"
+	"github.com/pouriyajamshidi/flat/internal/packet"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFlows(t *testing.T) {
+	flowTable := flowtable.NewFlowTable[packet.Packet](flowtable.DefaultConfig())
+
+	recent := packet.Packet{
+		SrcIP:     netip.MustParseAddr("::ffff:192.168.0.156"),
+		DstIP:     netip.MustParseAddr("::ffff:1.1.1.1"),
+		SrcPort:   53264,
+		DstPort:   443,
+		Protocol:  6,
+		Syn:       true,
+		TimeStamp: 3_000_000_000,
+		PID:       42,
+		Comm:      "curl",
+	}
+
+	oldest := recent
+	oldest.SrcPort = 53265
+	oldest.SrcIP = netip.MustParseAddr("2001:db8::2")
+	oldest.DstIP = netip.MustParseAddr("2001:db8::1")
+	oldest.TimeStamp = 1_000_000_000
+
+	flowTable.Insert(recent.FlowKey(), recent.TimeStamp, recent)
+	flowTable.Insert(oldest.FlowKey(), oldest.TimeStamp, oldest)
+
+	flows := Flows(flowTable, 4_000_000_000)
+
+	// The longest waiting request comes first
+	require.Equal(t, []Flow{
+		{Protocol: "TCP", SrcIP: "2001:db8::2", SrcPort: 53265, DstIP: "2001:db8::1", DstPort: 443, PendingNs: 3_000_000_000, PID: 42, Comm: "curl"},
+		{Protocol: "TCP", SrcIP: "192.168.0.156", SrcPort: 53264, DstIP: "1.1.1.1", DstPort: 443, PendingNs: 1_000_000_000, PID: 42, Comm: "curl"},
+	}, flows)
+}
@@ -0,0 +1,179 @@
+package probe
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/netip"
+	"os"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/pouriyajamshidi/flat/internal/packet"
+	"github.com/pouriyajamshidi/flat/internal/reset"
+	"github.com/pouriyajamshidi/flat/internal/types"
+)
+
+// tcpHeaderLen is the size of a TCP header without options, which the
+// eBPF program expects to fit after the IP header of UDP datagrams too
+const tcpHeaderLen = 20
+
+// pcapngMagic starts the section header block of a pcapng file
+var pcapngMagic = []byte{0x0a, 0x0d, 0x0d, 0x0a}
+
+// captureFile is implemented by the pcap and pcapng readers
+type captureFile interface {
+	ReadPacketData() ([]byte, gopacket.CaptureInfo, error)
+	LinkType() layers.LinkType
+}
+
+// openCapture reads a pcap or pcapng file, whichever its magic number says
+func openCapture(r io.Reader) (captureFile, string, error) {
+	buffered := bufio.NewReader(r)
+
+	magic, err := buffered.Peek(len(pcapngMagic))
+	if err != nil {
+		return nil, "", err
+	}
+
+	if !bytes.Equal(magic, pcapngMagic) {
+		reader, err := pcapgo.NewReader(buffered)
+		return reader, "", err
+	}
+
+	reader, err := pcapgo.NewNgReader(buffered, pcapgo.DefaultNgReaderOptions)
+	if err != nil {
+		return nil, "", err
+	}
+
+	iface, err := reader.Interface(0)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return reader, iface.Name, nil
+}
+
+// decode builds a packet from an Ethernet frame the way the eBPF program
+// does. Only unicast TCP and UDP over IPv4 or IPv6 are kept, addresses are
+// IPv4-mapped and the flags of TCP segments are only set on SYN and SYN/ACK
+func decode(frame []byte) (packet.Packet, bool) {
+	decoded := gopacket.NewPacket(frame, layers.LayerTypeEthernet, gopacket.DecodeOptions{Lazy: true, NoCopy: true})
+
+	// Broadcast and multicast addresses have the group bit set
+	eth, ok := decoded.LinkLayer().(*layers.Ethernet)
+	if !ok || eth.DstMAC[0]&1 == 1 {
+		return packet.Packet{}, false
+	}
+
+	var pkt packet.Packet
+	var srcIP, dstIP netip.Addr
+	var payload []byte
+
+	switch network := decoded.NetworkLayer().(type) {
+	case *layers.IPv4:
+		srcIP, _ = netip.AddrFromSlice(network.SrcIP)
+		dstIP, _ = netip.AddrFromSlice(network.DstIP)
+		pkt.Protocol = uint8(network.Protocol)
+		pkt.TTL = network.TTL
+		payload = network.Payload
+	case *layers.IPv6:
+		srcIP, _ = netip.AddrFromSlice(network.SrcIP)
+		dstIP, _ = netip.AddrFromSlice(network.DstIP)
+		pkt.Protocol = uint8(network.NextHeader)
+		pkt.TTL = network.HopLimit
+		payload = network.Payload
+	default:
+		return packet.Packet{}, false
+	}
+
+	if len(payload) < tcpHeaderLen {
+		return packet.Packet{}, false
+	}
+
+	pkt.SrcIP = netip.AddrFrom16(srcIP.As16())
+	pkt.DstIP = netip.AddrFrom16(dstIP.As16())
+
+	switch transport := decoded.TransportLayer().(type) {
+	case *layers.TCP:
+		pkt.SrcPort = uint16(transport.SrcPort)
+		pkt.DstPort = uint16(transport.DstPort)
+
+		if transport.SYN {
+			pkt.Syn = true
+			pkt.Ack = transport.ACK
+		}
+	case *layers.UDP:
+		pkt.SrcPort = uint16(transport.SrcPort)
+		pkt.DstPort = uint16(transport.DstPort)
+	default:
+		return packet.Packet{}, false
+	}
+
+	return pkt, true
+}
+
+// Analyze calculates the flow latencies of a pcap or pcapng file
+// the way Run does, timing packets by their capture timestamps
+func Analyze(ctx context.Context, userInput types.UserInput) error {
+	f, err := os.Open(userInput.ReadFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	capture, ifaceName, err := openCapture(f)
+	if err != nil {
+		log.Printf("Failed reading %v: %v", userInput.ReadFile, err)
+		return err
+	}
+
+	return analyze(ctx, userInput, capture, ifaceName, os.Stdout)
+}
+
+func analyze(ctx context.Context, userInput types.UserInput, capture captureFile, ifaceName string, w io.Writer) error {
+	if capture.LinkType() != layers.LinkTypeEthernet {
+		return fmt.Errorf("unsupported link type %v, only Ethernet captures can be analyzed", capture.LinkType())
+	}
+
+	pipeline := newOffline(userInput, ifaceName, time.Unix(0, 0), w)
+
+	var frames int
+
+	for ctx.Err() == nil {
+		data, info, err := capture.ReadPacketData()
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				log.Printf("Failed reading packet %d of %v: %v", frames+1, userInput.ReadFile, err)
+			}
+			break
+		}
+
+		frames++
+		pipeline.advance(uint64(info.Timestamp.UnixNano()))
+
+		if src, dst, ok := reset.Parse(data); ok {
+			pipeline.refused(src, dst, info.InterfaceIndex)
+		}
+
+		packetAttrs, ok := decode(data)
+		if !ok {
+			continue
+		}
+
+		packetAttrs.TimeStamp = pipeline.now
+		packetAttrs.Interface = info.InterfaceIndex
+
+		pipeline.process(packetAttrs)
+	}
+
+	log.Printf("Analyzed %d packets, %d flows were still pending at the end of the capture", frames, pipeline.flowTable.Entries())
+
+	return pipeline.finish(w)
+}
@@ -0,0 +1,210 @@
+package probe
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/pouriyajamshidi/flat/internal/flowtable"
+	"github.com/pouriyajamshidi/flat/internal/output"
+	"github.com/pouriyajamshidi/flat/internal/types"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	unicastMAC   = net.HardwareAddr{0, 5, 4, 3, 2, 1}
+	broadcastMAC = net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+)
+
+// frame serializes a TCP or UDP segment between two endpoints into an Ethernet frame
+func frame(t *testing.T, dstMAC net.HardwareAddr, src, dst netip.AddrPort, transport gopacket.SerializableLayer) []byte {
+	eth := &layers.Ethernet{SrcMAC: net.HardwareAddr{0, 1, 2, 3, 4, 5}, DstMAC: dstMAC}
+
+	var network gopacket.NetworkLayer
+
+	proto := layers.IPProtocolUDP
+	if _, ok := transport.(*layers.TCP); ok {
+		proto = layers.IPProtocolTCP
+	}
+
+	if src.Addr().Is4() {
+		eth.EthernetType = layers.EthernetTypeIPv4
+		network = &layers.IPv4{Version: 4, TTL: 64, Protocol: proto, SrcIP: src.Addr().AsSlice(), DstIP: dst.Addr().AsSlice()}
+	} else {
+		eth.EthernetType = layers.EthernetTypeIPv6
+		network = &layers.IPv6{Version: 6, HopLimit: 64, NextHeader: proto, SrcIP: src.Addr().AsSlice(), DstIP: dst.Addr().AsSlice()}
+	}
+
+	switch segment := transport.(type) {
+	case *layers.TCP:
+		segment.SrcPort, segment.DstPort = layers.TCPPort(src.Port()), layers.TCPPort(dst.Port())
+		require.NoError(t, segment.SetNetworkLayerForChecksum(network))
+	case *layers.UDP:
+		segment.SrcPort, segment.DstPort = layers.UDPPort(src.Port()), layers.UDPPort(dst.Port())
+		require.NoError(t, segment.SetNetworkLayerForChecksum(network))
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	require.NoError(t, gopacket.SerializeLayers(buf, opts, eth, network.(gopacket.SerializableLayer), transport, gopacket.Payload(make([]byte, 32))))
+
+	return buf.Bytes()
+}
+
+func TestDecode(t *testing.T) {
+	client := netip.MustParseAddrPort("192.168.0.156:53264")
+	server := netip.MustParseAddrPort("1.1.1.1:443")
+
+	pkt, ok := decode(frame(t, unicastMAC, server, client, &layers.TCP{SYN: true, ACK: true}))
+	require.True(t, ok)
+	require.Equal(t, netip.MustParseAddr("::ffff:1.1.1.1"), pkt.SrcIP)
+	require.Equal(t, netip.MustParseAddr("::ffff:192.168.0.156"), pkt.DstIP)
+	require.Equal(t, server.Port(), pkt.SrcPort)
+	require.Equal(t, client.Port(), pkt.DstPort)
+	require.Equal(t, uint8(6), pkt.Protocol)
+	require.Equal(t, uint8(64), pkt.TTL)
+	require.True(t, pkt.Syn)
+	require.True(t, pkt.Ack)
+
+	// Like the eBPF program, only SYN segments carry their flags
+	pkt, ok = decode(frame(t, unicastMAC, client, server, &layers.TCP{ACK: true}))
+	require.True(t, ok)
+	require.False(t, pkt.Syn)
+	require.False(t, pkt.Ack)
+
+	pkt, ok = decode(frame(t, unicastMAC, netip.MustParseAddrPort("[2001:db8::1]:5353"), netip.MustParseAddrPort("[2001:db8::53]:53"), &layers.UDP{}))
+	require.True(t, ok)
+	require.Equal(t, netip.MustParseAddr("2001:db8::1"), pkt.SrcIP)
+	require.Equal(t, uint8(17), pkt.Protocol)
+
+	_, ok = decode(frame(t, broadcastMAC, client, netip.MustParseAddrPort("192.168.0.255:137"), &layers.UDP{}))
+	require.False(t, ok)
+}
+
+func TestAnalyze(t *testing.T) {
+	client := netip.MustParseAddrPort("192.168.0.156:53264")
+	server := netip.MustParseAddrPort("1.1.1.1:443")
+	refusing := netip.MustParseAddrPort("1.1.1.1:8443")
+	silent := netip.MustParseAddrPort("1.1.1.1:22")
+	resolver := netip.MustParseAddrPort("9.9.9.9:53")
+	start := time.Unix(1700000000, 0)
+
+	var capture bytes.Buffer
+
+	writer := pcapgo.NewWriterNanos(&capture)
+	require.NoError(t, writer.WriteFileHeader(65536, layers.LinkTypeEthernet))
+
+	write := func(offset time.Duration, data []byte) {
+		info := gopacket.CaptureInfo{Timestamp: start.Add(offset), CaptureLength: len(data), Length: len(data)}
+		require.NoError(t, writer.WritePacket(info, data))
+	}
+
+	write(0, frame(t, unicastMAC, client, silent, &layers.TCP{SYN: true}))
+	write(time.Millisecond, frame(t, unicastMAC, client, server, &layers.TCP{SYN: true}))
+	write(13*time.Millisecond, frame(t, unicastMAC, server, client, &layers.TCP{SYN: true, ACK: true}))
+	write(14*time.Millisecond, frame(t, unicastMAC, client, server, &layers.TCP{ACK: true}))
+	write(20*time.Millisecond, frame(t, unicastMAC, client, resolver, &layers.UDP{}))
+	write(25*time.Millisecond, frame(t, unicastMAC, resolver, client, &layers.UDP{}))
+	write(30*time.Millisecond, frame(t, unicastMAC, client, refusing, &layers.TCP{SYN: true}))
+	write(31*time.Millisecond, frame(t, unicastMAC, refusing, client, &layers.TCP{RST: true, ACK: true}))
+	write(2*time.Second, frame(t, broadcastMAC, client, netip.MustParseAddrPort("192.168.0.255:137"), &layers.UDP{}))
+
+	reader, err := pcapgo.NewReader(&capture)
+	require.NoError(t, err)
+
+	userInput := types.UserInput{Output: "json", FlowTable: flowtable.DefaultConfig()}
+	userInput.FlowTable.TCPTimeout = time.Second
+	userInput.FlowTable.PruneInterval = time.Second
+
+	var out bytes.Buffer
+	require.NoError(t, analyze(context.Background(), userInput, reader, "", &out))
+
+	var records []output.Record
+
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		var record output.Record
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		records = append(records, record)
+	}
+
+	require.Len(t, records, 4)
+
+	require.Equal(t, output.Latency, records[0].Type)
+	require.Equal(t, "TCP", records[0].Protocol)
+	require.Equal(t, int64(12*time.Millisecond), records[0].LatencyNs)
+	require.Equal(t, client.Addr().String(), records[0].SrcIP)
+	require.Equal(t, server.Port(), records[0].DstPort)
+	require.True(t, start.Add(13*time.Millisecond).Equal(records[0].Time))
+
+	require.Equal(t, output.Latency, records[1].Type)
+	require.Equal(t, "UDP", records[1].Protocol)
+	require.Equal(t, int64(5*time.Millisecond), records[1].LatencyNs)
+
+	require.Equal(t, output.Refused, records[2].Type)
+	require.Equal(t, refusing.Port(), records[2].DstPort)
+
+	// The silent SYN expires once the capture's clock passes its timeout
+	require.Equal(t, output.Timeout, records[3].Type)
+	require.Equal(t, silent.Port(), records[3].DstPort)
+	require.True(t, start.Equal(records[3].Time))
+}
+
+func TestAnalyzeOutOfOrder(t *testing.T) {
+	client := netip.MustParseAddrPort("192.168.0.156:53264")
+	server := netip.MustParseAddrPort("1.1.1.1:443")
+	start := time.Unix(1700000000, 0)
+
+	var capture bytes.Buffer
+
+	writer := pcapgo.NewWriterNanos(&capture)
+	require.NoError(t, writer.WriteFileHeader(65536, layers.LinkTypeEthernet))
+
+	write := func(offset time.Duration, data []byte) {
+		info := gopacket.CaptureInfo{Timestamp: start.Add(offset), CaptureLength: len(data), Length: len(data)}
+		require.NoError(t, writer.WritePacket(info, data))
+	}
+
+	// The SYN/ACK was captured on another queue and stamped before its SYN
+	write(10*time.Millisecond, frame(t, unicastMAC, client, server, &layers.TCP{SYN: true}))
+	write(9*time.Millisecond, frame(t, unicastMAC, server, client, &layers.TCP{SYN: true, ACK: true}))
+
+	reader, err := pcapgo.NewReader(&capture)
+	require.NoError(t, err)
+
+	userInput := types.UserInput{Output: "json", FlowTable: flowtable.DefaultConfig()}
+
+	var out bytes.Buffer
+	require.NoError(t, analyze(context.Background(), userInput, reader, "", &out))
+
+	// The reply answers the SYN, but has no latency to report
+	require.Empty(t, out.String())
+}
+
+func TestOpenCapture(t *testing.T) {
+	var capture bytes.Buffer
+
+	writer, err := pcapgo.NewNgWriterInterface(&capture, pcapgo.NgInterface{Name: "eth0", LinkType: layers.LinkTypeEthernet}, pcapgo.DefaultNgWriterOptions)
+	require.NoError(t, err)
+
+	data := frame(t, unicastMAC, netip.MustParseAddrPort("192.168.0.156:53264"), netip.MustParseAddrPort("1.1.1.1:443"), &layers.TCP{SYN: true})
+	require.NoError(t, writer.WritePacket(gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: len(data), Length: len(data)}, data))
+	require.NoError(t, writer.Flush())
+
+	reader, name, err := openCapture(&capture)
+	require.NoError(t, err)
+	require.Equal(t, "eth0", name)
+	require.Equal(t, layers.LinkTypeEthernet, reader.LinkType())
+
+	read, _, err := reader.ReadPacketData()
+	require.NoError(t, err)
+	require.Equal(t, data, read)
+}
@@ -0,0 +1,184 @@
+package probe
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/pouriyajamshidi/flat/internal/control"
+	"github.com/pouriyajamshidi/flat/internal/flowtable"
+	"github.com/pouriyajamshidi/flat/internal/output"
+	"github.com/pouriyajamshidi/flat/internal/packet"
+	"github.com/pouriyajamshidi/flat/internal/pending"
+	"github.com/pouriyajamshidi/flat/internal/source"
+	"github.com/pouriyajamshidi/flat/internal/stats"
+	"github.com/pouriyajamshidi/flat/internal/timer"
+	"github.com/pouriyajamshidi/flat/internal/types"
+)
+
+// command is a control request waiting for the main loop to answer it
+type command struct {
+	request control.Request
+	reply   chan control.Response
+}
+
+// serveControl hands the requests sent to the control socket to the
+// main loop through commands, so that it is the only one to change its state
+func serveControl(ctx context.Context, path string, commands chan<- command) {
+	err := control.Serve(ctx, path, func(request control.Request) control.Response {
+		c := command{request: request, reply: make(chan control.Response, 1)}
+
+		select {
+		case commands <- c:
+		case <-ctx.Done():
+			return control.Response{Error: "shutting down"}
+		}
+
+		return <-c.reply
+	})
+	if err != nil {
+		log.Printf("Failed serving the control socket: %v", err)
+	}
+}
+
+// controller answers the control requests of a running probe
+type controller struct {
+	attachedAt time.Time
+	flowTable  *flowtable.FlowTable[packet.Packet]
+	statistics *stats.Stats[stats.Destination]
+	sinks      *output.Fanout
+	stdout     *output.Switch // nil when results are not printed
+	source     *source.Source
+}
+
+// handle answers a request, changing the filters of userInput in place
+func (c *controller) handle(userInput *types.UserInput, request control.Request) control.Response {
+	switch request.Command {
+	case control.Status:
+		return control.Response{State: c.state(userInput)}
+
+	case control.Filters:
+		return control.Response{Filters: filterSet(userInput)}
+
+	case control.AddFilter, control.RemoveFilter:
+		if err := changeFilters(userInput, request); err != nil {
+			return control.Response{Error: err.Error()}
+		}
+
+		return control.Response{Filters: filterSet(userInput)}
+
+	case control.Verbosity:
+		if c.stdout == nil {
+			return control.Response{Error: "results are not printed"}
+		}
+
+		switch request.Verbosity {
+		case control.Normal:
+			c.stdout.Set(true)
+		case control.Quiet:
+			c.stdout.Set(false)
+		default:
+			return control.Response{Error: fmt.Sprintf("unknown verbosity %q, expected %v or %v", request.Verbosity, control.Normal, control.Quiet)}
+		}
+
+		log.Printf("Changed the verbosity to %v", request.Verbosity)
+
+		return control.Response{State: c.state(userInput)}
+
+	case control.Flows:
+		return control.Response{Flows: pending.Flows(c.flowTable, timer.GetNanosecSinceBoot())}
+
+	case control.ResetStats:
+		c.statistics.Reset()
+
+		log.Println("Reset the latency statistics")
+
+		return control.Response{}
+	}
+
+	return control.Response{Error: fmt.Sprintf("unknown command %q", request.Command)}
+}
+
+func (c *controller) state(userInput *types.UserInput) *control.State {
+	state := &control.State{
+		Interface:  userInput.Interface.Attrs().Name,
+		Index:      userInput.Interface.Attrs().Index,
+		AttachedAt: c.attachedAt,
+		Filters:    *filterSet(userInput),
+		Verbosity:  control.Quiet,
+		FlowTable:  c.flowTable.Stats(),
+		Dropped:    c.sinks.Dropped(),
+	}
+
+	if c.stdout != nil && c.stdout.On() {
+		state.Verbosity = control.Normal
+	}
+
+	if c.source != nil {
+		state.RingbufPending = c.source.AvailableBytes()
+		state.RingbufSize = c.source.BufferSize()
+	}
+
+	return state
+}
+
+func filterSet(userInput *types.UserInput) *control.FilterSet {
+	return &control.FilterSet{
+		IPs:   slices.Clone(userInput.IPs),
+		Ports: slices.Clone(userInput.Ports),
+	}
+}
+
+// changeFilters adds or removes the IP or port of a request
+func changeFilters(userInput *types.UserInput, request control.Request) error {
+	add := request.Command == control.AddFilter
+
+	switch {
+	// Filters match flows to or from any of them, a request with both
+	// could be taken as the flows to that IP and port only
+	case request.IP.IsValid() && request.Port != 0:
+		return fmt.Errorf("a filter takes either an IP or a port, not both")
+
+	case request.IP.IsValid():
+		ip := request.IP.Unmap()
+		i := slices.Index(userInput.IPs, ip)
+
+		if add && i < 0 {
+			userInput.IPs = append(userInput.IPs, ip)
+			log.Printf("Filtering results on IP %v", ip)
+		}
+
+		if !add {
+			if i < 0 {
+				return fmt.Errorf("not filtering on IP %v", ip)
+			}
+
+			userInput.IPs = slices.Delete(userInput.IPs, i, i+1)
+			log.Printf("No longer filtering results on IP %v", ip)
+		}
+
+	case request.Port != 0:
+		i := slices.Index(userInput.Ports, request.Port)
+
+		if add && i < 0 {
+			userInput.Ports = append(userInput.Ports, request.Port)
+			log.Printf("Filtering results on port %d", request.Port)
+		}
+
+		if !add {
+			if i < 0 {
+				return fmt.Errorf("not filtering on port %d", request.Port)
+			}
+
+			userInput.Ports = slices.Delete(userInput.Ports, i, i+1)
+			log.Printf("No longer filtering results on port %d", request.Port)
+		}
+
+	default:
+		return fmt.Errorf("a filter needs an IP or a port")
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package probe
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/pouriyajamshidi/flat/internal/control"
+	"github.com/pouriyajamshidi/flat/internal/flowtable"
+	"github.com/pouriyajamshidi/flat/internal/output"
+	"github.com/pouriyajamshidi/flat/internal/packet"
+	"github.com/pouriyajamshidi/flat/internal/stats"
+	"github.com/pouriyajamshidi/flat/internal/types"
+	"github.com/stretchr/testify/require"
+	"github.com/vishvananda/netlink"
+)
+
+func newTestController() (*controller, *types.UserInput) {
+	userInput := &types.UserInput{
+		Interface: &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: "eth0", Index: 2}},
+		Ports:     []uint16{443},
+	}
+
+	return &controller{
+		flowTable:  flowtable.NewFlowTable[packet.Packet](flowtable.DefaultConfig()),
+		statistics: stats.New[stats.Destination](stats.DefaultConfig()),
+		sinks:      output.NewFanout(),
+		stdout:     output.NewSwitch(output.Text{}, true),
+	}, userInput
+}
+
+func TestControlFilters(t *testing.T) {
+	ctl, userInput := newTestController()
+	ip := netip.MustParseAddr("1.1.1.1")
+
+	response := ctl.handle(userInput, control.Request{Command: control.AddFilter, IP: netip.MustParseAddr("::ffff:1.1.1.1")})
+	require.Empty(t, response.Error)
+	require.Equal(t, []netip.Addr{ip}, response.Filters.IPs)
+
+	// Adding a filter twice keeps a single one
+	ctl.handle(userInput, control.Request{Command: control.AddFilter, IP: ip})
+	require.Equal(t, []netip.Addr{ip}, userInput.IPs)
+
+	response = ctl.handle(userInput, control.Request{Command: control.RemoveFilter, Port: 443})
+	require.Empty(t, response.Error)
+	require.Empty(t, userInput.Ports)
+
+	response = ctl.handle(userInput, control.Request{Command: control.RemoveFilter, Port: 443})
+	require.Equal(t, "not filtering on port 443", response.Error)
+
+	response = ctl.handle(userInput, control.Request{Command: control.AddFilter})
+	require.NotEmpty(t, response.Error)
+
+	// A request with both an IP and a port changes neither
+	response = ctl.handle(userInput, control.Request{Command: control.AddFilter, IP: netip.MustParseAddr("8.8.8.8"), Port: 53})
+	require.NotEmpty(t, response.Error)
+	require.Equal(t, []netip.Addr{ip}, userInput.IPs)
+	require.Empty(t, userInput.Ports)
+
+	// The changed filters apply to the packets that follow
+	pkt := packet.Packet{SrcIP: netip.MustParseAddr("::ffff:10.0.0.1"), DstIP: netip.MustParseAddr("::ffff:1.1.1.1"), DstPort: 80}
+	require.True(t, shouldTrack(*userInput, pkt))
+
+	pkt.DstIP = netip.MustParseAddr("::ffff:8.8.8.8")
+	require.False(t, shouldTrack(*userInput, pkt))
+}
+
+func TestControlVerbosityAndStats(t *testing.T) {
+	ctl, userInput := newTestController()
+	ctl.statistics.Record(stats.Destination{}, time.Millisecond)
+
+	response := ctl.handle(userInput, control.Request{Command: control.Verbosity, Verbosity: control.Quiet})
+	require.Empty(t, response.Error)
+	require.False(t, ctl.stdout.On())
+	require.Equal(t, control.Quiet, response.State.Verbosity)
+
+	response = ctl.handle(userInput, control.Request{Command: control.Verbosity, Verbosity: "loud"})
+	require.NotEmpty(t, response.Error)
+
+	response = ctl.handle(userInput, control.Request{Command: control.ResetStats})
+	require.Empty(t, response.Error)
+	require.Zero(t, ctl.statistics.Len())
+
+	response = ctl.handle(userInput, control.Request{Command: control.Status})
+	require.Equal(t, "eth0", response.State.Interface)
+	require.Equal(t, []uint16{443}, response.State.Filters.Ports)
+
+	response = ctl.handle(userInput, control.Request{Command: "detach"})
+	require.Equal(t, `unknown command "detach"`, response.Error)
+}
@@ -0,0 +1,106 @@
+package probe
+
+import (
+	"io"
+	"net/netip"
+	"time"
+
+	"github.com/pouriyajamshidi/flat/internal/flowtable"
+	"github.com/pouriyajamshidi/flat/internal/output"
+	"github.com/pouriyajamshidi/flat/internal/packet"
+	"github.com/pouriyajamshidi/flat/internal/stats"
+	"github.com/pouriyajamshidi/flat/internal/types"
+	"golang.org/x/sys/unix"
+)
+
+// offline calculates the latencies of packets read from a file rather
+// than the eBPF program. Flows expire by the timestamps of the packets.
+// Sinks are called directly, there is no ringbuf to keep up with
+type offline struct {
+	userInput  types.UserInput
+	flowTable  *flowtable.FlowTable[packet.Packet]
+	statistics *stats.Stats[stats.Destination]
+	sinks      []output.Sink
+	now        uint64
+	lastPrune  uint64
+}
+
+// newOffline writes results to w, timestamped relative to epoch
+func newOffline(userInput types.UserInput, ifaceName string, epoch time.Time, w io.Writer) *offline {
+	o := &offline{
+		userInput:  userInput,
+		flowTable:  flowtable.NewFlowTable[packet.Packet](userInput.FlowTable),
+		statistics: stats.New[stats.Destination](stats.DefaultConfig()),
+	}
+
+	o.flowTable.SetClock(func() uint64 { return o.now })
+
+	switch userInput.Output {
+	case "json":
+		writer := output.NewJSON(w, ifaceName, nil)
+		writer.SetEpoch(epoch)
+		o.sinks = append(o.sinks, writer)
+	case "text":
+		o.sinks = append(o.sinks, output.Text{})
+	}
+
+	o.flowTable.OnExpire(func(_ flowtable.FlowKey, initiator packet.Packet) {
+		for _, sink := range o.sinks {
+			sink.Timeout(initiator)
+		}
+	})
+
+	return o
+}
+
+// advance moves the clock to the timestamp of the next packet,
+// expiring the flows that timed out in the meantime
+func (o *offline) advance(timestamp uint64) {
+	o.now = timestamp
+
+	if o.now > o.lastPrune && o.now-o.lastPrune >= uint64(o.userInput.FlowTable.PruneInterval) {
+		o.flowTable.Prune()
+		o.lastPrune = o.now
+	}
+}
+
+// refused reports a pending SYN answered by a RST from dst to src
+func (o *offline) refused(src, dst netip.AddrPort, iface int) {
+	key := flowtable.NewFlowKey(src, dst, unix.IPPROTO_TCP, iface)
+
+	// Only a RST answering a pending SYN is a refused connection
+	if initiator, ok := o.flowTable.Get(key); ok && initiator.Syn {
+		o.flowTable.Remove(key)
+
+		for _, sink := range o.sinks {
+			sink.Refused(initiator)
+		}
+	}
+}
+
+// process calculates and reports the latency of a packet
+func (o *offline) process(pkt packet.Packet) {
+	if !shouldTrack(o.userInput, pkt) {
+		return
+	}
+
+	result, ok := packet.CalcLatency(pkt, o.flowTable)
+	if !ok {
+		return
+	}
+
+	o.statistics.Record(destination(pkt), result.Latency)
+
+	for _, sink := range o.sinks {
+		sink.Latency(result)
+	}
+}
+
+// finish prints the statistics table if requested
+func (o *offline) finish(w io.Writer) error {
+	if o.userInput.Stats {
+		return stats.WriteTable(w, o.statistics.Entries(), destinationName)
+	}
+
+	return nil
+}
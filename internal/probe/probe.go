@@ -2,249 +2,672 @@ package probe
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"time"
 
 	"github.com/cilium/ebpf/ringbuf"
-	"github.com/pouriyajamshidi/flat/clsact"
+	"github.com/pouriyajamshidi/flat/internal/alert"
+	"github.com/pouriyajamshidi/flat/internal/api"
+	"github.com/pouriyajamshidi/flat/internal/baseline"
+	"github.com/pouriyajamshidi/flat/internal/dnssnoop"
 	"github.com/pouriyajamshidi/flat/internal/flowtable"
+	"github.com/pouriyajamshidi/flat/internal/geoip"
+	"github.com/pouriyajamshidi/flat/internal/history"
+	"github.com/pouriyajamshidi/flat/internal/ipfix"
+	"github.com/pouriyajamshidi/flat/internal/kube"
+	"github.com/pouriyajamshidi/flat/internal/metrics"
+	"github.com/pouriyajamshidi/flat/internal/otlp"
+	"github.com/pouriyajamshidi/flat/internal/output"
 	"github.com/pouriyajamshidi/flat/internal/packet"
+	"github.com/pouriyajamshidi/flat/internal/pcapng"
+	"github.com/pouriyajamshidi/flat/internal/process"
+	"github.com/pouriyajamshidi/flat/internal/record"
+	"github.com/pouriyajamshidi/flat/internal/report"
+	"github.com/pouriyajamshidi/flat/internal/reset"
+	"github.com/pouriyajamshidi/flat/internal/resolve"
+	"github.com/pouriyajamshidi/flat/internal/source"
+	"github.com/pouriyajamshidi/flat/internal/stats"
+	"github.com/pouriyajamshidi/flat/internal/timer"
+	"github.com/pouriyajamshidi/flat/internal/tui"
 	"github.com/pouriyajamshidi/flat/internal/types"
 	"github.com/vishvananda/netlink"
 	"golang.org/x/sys/unix"
 )
 
-//go:generate go run github.com/cilium/ebpf/cmd/bpf2go probe ../../bpf/flat.c - -O2  -Wall -Werror -Wno-address-of-packed-member
+const resolveWorkers = 8
 
-const tenMegaBytes = 1024 * 1024 * 10
-const twentyMegaBytes = tenMegaBytes * 2
-const fortyMegaBytes = twentyMegaBytes * 2
+// attributeProcess fills in the PID and command of the local process owning the packet's socket
+func attributeProcess(pkt *packet.Packet, resolver *process.Resolver) {
+	proc, ok := resolver.Lookup(
+		pkt.Protocol,
+		netip.AddrPortFrom(pkt.SrcIP, pkt.SrcPort),
+		netip.AddrPortFrom(pkt.DstIP, pkt.DstPort),
+	)
 
-type probe struct {
-	iface      netlink.Link
-	handle     *netlink.Handle
-	qdisc      *clsact.ClsAct
-	bpfObjects *probeObjects
-	filters    []*netlink.BpfFilter
+	if !ok {
+		return
+	}
+
+	pkt.PID = proc.PID
+	pkt.Comm = proc.Comm
 }
 
-func setRlimit() error {
-	log.Printf("Setting rlimit - soft: %v | hard: %v\n", twentyMegaBytes, fortyMegaBytes)
+// hostname labels an IP address with the name the host resolved it from,
+// falling back to reverse DNS
+func hostname(ip netip.Addr, dnsTable *dnssnoop.Table, hostResolver *resolve.Resolver) string {
+	if dnsTable != nil {
+		if name, ok := dnsTable.Lookup(ip); ok {
+			return name
+		}
+	}
 
-	return unix.Setrlimit(unix.RLIMIT_MEMLOCK, &unix.Rlimit{
-		Cur: tenMegaBytes,
-		Max: twentyMegaBytes,
-	})
+	if hostResolver != nil {
+		name, _ := hostResolver.Name(ip)
+		return name
+	}
+
+	return ""
 }
 
-func (p *probe) loadObjects() error {
-	log.Printf("Loading probe object into kernel")
+// destination returns the remote end of the flow completed by a reply packet
+func destination(pkt packet.Packet) stats.Destination {
+	return stats.Destination{
+		IP:       pkt.SrcIP.Unmap(),
+		Port:     pkt.SrcPort,
+		Protocol: pkt.Protocol,
+	}
+}
 
-	objs := probeObjects{}
+func destinationName(dest stats.Destination) string {
+	return fmt.Sprintf("(%v) %v", packet.ProtocolName(dest.Protocol), dest)
+}
 
-	if err := loadProbeObjects(&objs, nil); err != nil {
-		return err
+// viewName labels the destination of a reply packet as "host (ip:port)"
+func viewName(pkt packet.Packet) string {
+	name := pkt.SrcAddrPort().String()
+
+	if pkt.SrcHost != "" {
+		return pkt.SrcHost + " (" + name + ")"
+	}
+
+	return name
+}
+
+// localAddrs lists the addresses of an interface
+func localAddrs(link netlink.Link) []netip.Addr {
+	addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+
+	if err != nil {
+		log.Printf("Failed listing the addresses of %v: %v", link.Attrs().Name, err)
+		return nil
+	}
+
+	var local []netip.Addr
+
+	for _, addr := range addrs {
+		if ip, ok := netip.AddrFromSlice(addr.IP); ok {
+			local = append(local, ip.Unmap())
+		}
+	}
+
+	return local
+}
+
+// shouldTrack checks whether a packet matches any of the user provided filters
+func shouldTrack(userInput types.UserInput, pkt packet.Packet) bool {
+	// user has not provided any filters
+	if len(userInput.IPs) == 0 && len(userInput.Ports) == 0 && userInput.KubePod == "" && userInput.KubeService == "" &&
+		userInput.Country == "" && userInput.ASN == 0 {
+		return true
+	}
+
+	if slices.Contains(userInput.IPs, pkt.DstIP.Unmap()) || slices.Contains(userInput.IPs, pkt.SrcIP.Unmap()) {
+		return true
+	}
+
+	if slices.Contains(userInput.Ports, pkt.DstPort) || slices.Contains(userInput.Ports, pkt.SrcPort) {
+		return true
 	}
 
-	p.bpfObjects = &objs
+	if userInput.KubePod != "" && (pkt.SrcKube.MatchesPod(userInput.KubePod) || pkt.DstKube.MatchesPod(userInput.KubePod)) {
+		return true
+	}
 
-	return nil
+	if userInput.KubeService != "" && (pkt.SrcKube.MatchesService(userInput.KubeService) || pkt.DstKube.MatchesService(userInput.KubeService)) {
+		return true
+	}
+
+	if userInput.Country != "" && (pkt.SrcGeo.Country == userInput.Country || pkt.DstGeo.Country == userInput.Country) {
+		return true
+	}
+
+	if userInput.ASN != 0 && (pkt.SrcGeo.ASN == userInput.ASN || pkt.DstGeo.ASN == userInput.ASN) {
+		return true
+	}
+
+	return false
 }
 
-func (p *probe) createQdisc() error {
-	log.Printf("Creating clsact qdisc")
+// Run attaches the probe, reads from the eBPF map
+// as well as calculating and displaying the flow latencies
+func Run(ctx context.Context, userInput types.UserInput) error {
+	log.Println("Starting up the probe")
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	flowTable := flowtable.NewFlowTable[packet.Packet](userInput.FlowTable)
+
+	statistics := stats.New[stats.Destination](userInput.Statistics)
 
-	p.qdisc = clsact.NewClsAct(&netlink.QdiscAttrs{
-		LinkIndex: p.iface.Attrs().Index,
-		Handle:    netlink.MakeHandle(0xffff, 0),
-		Parent:    netlink.HANDLE_CLSACT,
-	})
+	var reporter *report.Reporter
+	var groupKey func(packet.Packet) string
 
-	if err := p.handle.QdiscAdd(p.qdisc); err != nil {
-		if err := p.handle.QdiscReplace(p.qdisc); err != nil {
+	refusals := make(chan [2]netip.AddrPort)
+
+	if userInput.Interval > 0 {
+		keyFunc, err := report.KeyFunc(userInput.GroupBy)
+		if err != nil {
 			return err
 		}
+
+		groupKey = keyFunc
+		reporter = report.New(userInput.GroupBy, userInput.Interval)
+
+		go reporter.Run(ctx, os.Stdout)
 	}
 
-	return nil
-}
+	// sinks are handed every latency, timeout and refusal. The sinks that
+	// run in the background keep running until they have handled them all
+	sinks := output.NewFanout()
 
-func (p *probe) createFilters() error {
-	log.Printf("Creating qdisc ingress/egress filters")
+	sinkCtx, cancelSinks := context.WithCancel(context.Background())
+	defer cancelSinks()
 
-	addFilter := func(attrs netlink.FilterAttrs) {
-		p.filters = append(p.filters, &netlink.BpfFilter{
-			FilterAttrs:  attrs,
-			Fd:           p.bpfObjects.probePrograms.Flat.FD(),
-			DirectAction: true,
-		})
+	// stdout can be silenced and turned back on through the control socket
+	var stdout *output.Switch
+
+	if userInput.Output == "json" {
+		stdout = output.NewSwitch(output.NewJSON(os.Stdout, userInput.Interface.Attrs().Name, localAddrs(userInput.Interface)), true)
+		sinks.Register("json", stdout, output.DefaultBuffer)
 	}
 
-	addFilter(netlink.FilterAttrs{
-		LinkIndex: p.iface.Attrs().Index,
-		Handle:    netlink.MakeHandle(0xffff, 0),
-		Parent:    netlink.HANDLE_MIN_INGRESS,
-		Protocol:  unix.ETH_P_IP,
-	})
-
-	addFilter(netlink.FilterAttrs{
-		LinkIndex: p.iface.Attrs().Index,
-		Handle:    netlink.MakeHandle(0xffff, 0),
-		Parent:    netlink.HANDLE_MIN_EGRESS,
-		Protocol:  unix.ETH_P_IP,
-	})
-
-	addFilter(netlink.FilterAttrs{
-		LinkIndex: p.iface.Attrs().Index,
-		Handle:    netlink.MakeHandle(0xffff, 0),
-		Parent:    netlink.HANDLE_MIN_INGRESS,
-		Protocol:  unix.ETH_P_IPV6,
-	})
-
-	addFilter(netlink.FilterAttrs{
-		LinkIndex: p.iface.Attrs().Index,
-		Handle:    netlink.MakeHandle(0xffff, 0),
-		Parent:    netlink.HANDLE_MIN_EGRESS,
-		Protocol:  unix.ETH_P_IPV6,
-	})
-
-	for _, filter := range p.filters {
-		if err := p.handle.FilterAdd(filter); err != nil {
-			if err := p.handle.FilterReplace(filter); err != nil {
-				return err
+	if userInput.JSONFile != "" {
+		f, err := os.OpenFile(userInput.JSONFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			log.Printf("Failed opening JSON file: %v", err)
+			return err
+		}
+		defer f.Close()
+
+		sinks.Register("json-file", output.NewJSON(f, userInput.Interface.Attrs().Name, localAddrs(userInput.Interface)), output.DefaultBuffer)
+	}
+
+	if userInput.API.Addr != "" {
+		server := api.New(userInput.API, flowTable, statistics, output.NewRecords(userInput.Interface.Attrs().Name, localAddrs(userInput.Interface)))
+
+		listener, err := server.Listen()
+		if err != nil {
+			log.Printf("Failed listening for the API: %v", err)
+			return err
+		}
+
+		sinks.Register("api", server, output.DefaultBuffer)
+
+		go func() {
+			if err := server.Serve(ctx, listener); err != nil {
+				log.Printf("Failed serving the API: %v", err)
 			}
+		}()
+	}
+
+	var promMetrics *metrics.Metrics
+
+	if userInput.MetricsAddr != "" {
+		listener, err := net.Listen("tcp", userInput.MetricsAddr)
+		if err != nil {
+			log.Printf("Failed listening for metrics: %v", err)
+			return err
 		}
+
+		promMetrics = metrics.New(userInput.Metrics)
+		promMetrics.RegisterFlowTable(flowTable.Stats)
+		sinks.Register("metrics", promMetrics, output.DefaultBuffer)
+
+		go func() {
+			if err := promMetrics.Serve(ctx, listener); err != nil {
+				log.Printf("Failed serving metrics: %v", err)
+			}
+		}()
 	}
 
-	return nil
-}
+	otlpDone := make(chan struct{})
 
-func newProbe(iface netlink.Link) (*probe, error) {
-	log.Println("Creating a new probe")
+	if userInput.OTLP.Endpoint != "" {
+		otlpExporter := otlp.New(userInput.OTLP)
+		sinks.Register("otlp", otlpExporter, output.DefaultBuffer)
 
-	handle, err := netlink.NewHandle(unix.NETLINK_ROUTE)
+		go func() {
+			defer close(otlpDone)
+			otlpExporter.Run(sinkCtx)
+		}()
+	} else {
+		close(otlpDone)
+	}
 
-	if err != nil {
-		log.Printf("Failed getting netlink handle: %v", err)
-		return nil, err
+	statsdDone := make(chan struct{})
+
+	if userInput.StatsDAddr != "" {
+		statsd, err := output.NewStatsD(userInput.StatsDAddr, userInput.StatsDPrefix, userInput.Interface.Attrs().Name)
+		if err != nil {
+			log.Printf("Failed connecting to StatsD: %v", err)
+			return err
+		}
+
+		sinks.Register("statsd", statsd, output.DefaultBuffer)
+
+		go func() {
+			defer close(statsdDone)
+			statsd.Run(sinkCtx)
+		}()
+	} else {
+		close(statsdDone)
 	}
 
-	prbe := probe{
-		iface:  iface,
-		handle: handle,
+	ipfixDone := make(chan struct{})
+
+	if userInput.IPFIX.Addr != "" {
+		exporter, err := ipfix.New(userInput.IPFIX, userInput.Interface.Attrs().Index)
+		if err != nil {
+			log.Printf("Failed connecting to the IPFIX collector: %v", err)
+			return err
+		}
+
+		sinks.Register("ipfix", exporter, output.DefaultBuffer)
+
+		go func() {
+			defer close(ipfixDone)
+			exporter.Run(sinkCtx)
+		}()
+	} else {
+		close(ipfixDone)
 	}
 
-	if err := prbe.loadObjects(); err != nil {
-		log.Printf("Failed loading probe objects: %v", err)
-		return nil, err
+	historyDone := make(chan struct{})
+
+	if userInput.History.Path != "" {
+		store, err := history.Open(userInput.History.Path)
+		if err != nil {
+			log.Printf("Failed opening the history file: %v", err)
+			return err
+		}
+
+		recorder := history.NewRecorder(store, userInput.History, userInput.Interface.Attrs().Name)
+
+		sinks.Register("history", recorder, output.DefaultBuffer)
+
+		go func() {
+			defer close(historyDone)
+			recorder.Run(sinkCtx)
+		}()
+	} else {
+		close(historyDone)
 	}
 
-	if err := prbe.createQdisc(); err != nil {
-		log.Printf("Failed creating qdisc: %v", err)
-		return nil, err
+	pcapDone := make(chan struct{})
+
+	if userInput.PcapFile != "" {
+		file, err := pcapng.Create(userInput.PcapFile, userInput.PcapMaxSize, userInput.PcapMaxAge)
+		if err != nil {
+			log.Printf("Failed creating pcapng file: %v", err)
+			return err
+		}
+
+		// Keep the frames of a request until it can no longer time out
+		retention := 2 * max(userInput.FlowTable.TCPTimeout, userInput.FlowTable.UDPTimeout, userInput.FlowTable.PruneInterval)
+
+		recorder, err := pcapng.NewRecorder(file, userInput.Interface.Attrs().Name, userInput.Interface.Attrs().Index, retention)
+		if err != nil {
+			file.Close()
+			log.Printf("Failed creating pcapng file: %v", err)
+			return err
+		}
+
+		sinks.Register("pcap", recorder, output.DefaultBuffer)
+
+		go func() {
+			defer close(pcapDone)
+			recorder.Run(sinkCtx)
+		}()
+	} else {
+		close(pcapDone)
 	}
 
-	if err := prbe.createFilters(); err != nil {
-		log.Printf("Failed creating qdisc filters: %v", err)
-		return nil, err
+	// The text output ignores timeouts and refusals,
+	// so only watch for them when something else reports them
+	watchOutcomes := reporter != nil || sinks.Len() > 0
+
+	if userInput.Output == "text" && !userInput.TUI {
+		stdout = output.NewSwitch(output.Text{}, !userInput.SummaryOnly)
+		sinks.Register("text", stdout, output.DefaultBuffer)
 	}
 
-	return &prbe, nil
-}
+	if watchOutcomes {
+		flowTable.OnExpire(func(_ flowtable.FlowKey, initiator packet.Packet) {
+			if reporter != nil {
+				reporter.Timeout(groupKey(initiator))
+			}
 
-func (p *probe) Close() error {
-	log.Println("Removing qdisc")
-	if err := p.handle.QdiscDel(p.qdisc); err != nil {
-		log.Println("Failed deleting qdisc")
-		return err
+			sinks.Timeout(initiator)
+		})
+
+		go func() {
+			err := reset.Watch(ctx, userInput.Interface.Attrs().Index, func(src, dst netip.AddrPort) {
+				select {
+				case refusals <- [2]netip.AddrPort{src, dst}:
+				case <-ctx.Done():
+				}
+			})
+			if err != nil {
+				log.Printf("Failed watching for refused connections: %v", err)
+			}
+		}()
 	}
 
-	// log.Println("Removing qdisc filters")
+	go flowTable.Run(ctx)
 
-	// for _, filter := range p.filters {
-	// 	if err := p.handle.FilterDel(filter); err != nil {
-	// 		log.Println("Failed deleting qdisc filters")
-	// 		return err
-	// 	}
-	// }
+	var view *tui.Model
 
-	log.Println("Deleting handle")
-	p.handle.Delete()
+	viewDone := make(chan struct{})
 
-	log.Println("Closing eBPF object")
-	if err := p.bpfObjects.Close(); err != nil {
-		log.Println("Failed closing eBPF object")
-		return err
+	if userInput.TUI {
+		view = tui.NewModel()
+
+		go func() {
+			defer close(viewDone)
+
+			if err := tui.Run(ctx, view, cancel); err != nil {
+				log.Printf("Failed running the interactive view: %v", err)
+				cancel()
+			}
+		}()
+	} else {
+		close(viewDone)
 	}
 
-	return nil
-}
+	var resolver *process.Resolver
 
-// Run attaches the probe, reads from the eBPF map
-// as well as calculating and displaying the flow latencies
-func Run(ctx context.Context, userInput types.UserInput) error {
-	log.Println("Starting up the probe")
+	if userInput.Process {
+		resolver = process.NewResolver(localAddrs(userInput.Interface))
 
-	if err := setRlimit(); err != nil {
-		log.Printf("Failed setting rlimit: %v", err)
-		return err
+		go resolver.Run(ctx)
 	}
 
-	flowtable := flowtable.NewFlowTable()
+	var kubeStore *kube.Store
 
-	go func() {
-		for range flowtable.Ticker.C {
-			flowtable.Prune()
+	if userInput.KubeSnapshot != "" {
+		store, err := kube.NewStore(userInput.KubeSnapshot, userInput.KubeRefresh)
+		if err != nil {
+			log.Printf("Failed loading Kubernetes snapshot: %v", err)
+			return err
 		}
-	}()
 
-	probe, err := newProbe(userInput.Interface)
+		go store.Run(ctx)
+
+		kubeStore = store
+	}
+
+	var hostResolver *resolve.Resolver
+
+	if userInput.Resolve {
+		hostResolver = resolve.NewResolver(userInput.ResolveServer, resolveWorkers, userInput.ResolveTTL)
+
+		go hostResolver.Run(ctx)
+	}
+
+	var dnsTable *dnssnoop.Table
+
+	if userInput.DNSSnoop {
+		dnsTable = dnssnoop.NewTable()
+
+		go func() {
+			if err := dnsTable.Snoop(ctx, userInput.Interface.Attrs().Index); err != nil {
+				log.Printf("Failed snooping DNS responses: %v", err)
+			}
+		}()
+	}
+
+	var geoDB *geoip.Database
+
+	if userInput.GeoIPDatabase != "" || userInput.ASNDatabase != "" {
+		db, err := geoip.Open(userInput.GeoIPDatabase, userInput.ASNDatabase)
+		if err != nil {
+			log.Printf("Failed opening GeoIP databases: %v", err)
+			return err
+		}
+		defer db.Close()
+
+		geoDB = db
+	}
+
+	var alerter *alert.Alerter
+
+	if userInput.AlertRules != "" {
+		rules, actions, err := alert.LoadConfig(userInput.AlertRules)
+		if err != nil {
+			log.Printf("Failed loading alert rules: %v", err)
+			return err
+		}
+
+		alerter = alert.New(rules, actions)
+		go alerter.Run(ctx)
+	}
+
+	var detector *baseline.Detector
+
+	if userInput.Anomaly {
+		detector = baseline.NewDetector(userInput.Baseline)
+
+		if userInput.BaselineFile != "" {
+			if err := detector.Load(userInput.BaselineFile); err != nil {
+				log.Printf("Failed loading baselines: %v", err)
+				return err
+			}
+
+			go detector.Run(ctx, userInput.BaselineFile)
+		}
+	}
+
+	var recording *record.Writer
+
+	if userInput.RecordFile != "" {
+		header := record.Header{Schema: packet.SchemaVersion, Boot: timer.BootTime(), Interface: userInput.Interface.Attrs().Name}
+
+		writer, err := record.Create(userInput.RecordFile, header)
+		if err != nil {
+			log.Printf("Failed creating recording: %v", err)
+			return err
+		}
+
+		defer func() {
+			if err := writer.Close(); err != nil {
+				log.Printf("Failed closing recording: %v", err)
+			}
+		}()
+
+		recording = writer
+	}
 
+	ringbufSource, err := source.Attach(userInput.Interface, log.Default())
 	if err != nil {
 		return err
 	}
 
-	pipe := probe.bpfObjects.probeMaps.Pipe
+	if promMetrics != nil {
+		promMetrics.RegisterRingbuf(ringbufSource.AvailableBytes, ringbufSource.BufferSize(), ringbufSource.Dropped)
+	}
 
-	reader, err := ringbuf.NewReader(pipe)
-	if err != nil {
-		log.Fatalf("opening ringbuf reader: %s", err)
+	commands := make(chan command)
+
+	ctl := &controller{
+		attachedAt: time.Now(),
+		flowTable:  flowTable,
+		statistics: statistics,
+		sinks:      sinks,
+		stdout:     stdout,
+		source:     ringbufSource,
+	}
+
+	if userInput.ControlSocket != "" {
+		go serveControl(ctx, userInput.ControlSocket, commands)
 	}
-	defer reader.Close()
 
 	eventChan := make(chan []byte)
 
 	go func() {
 		for {
-			event, err := reader.Read()
+			event, err := ringbufSource.Read()
+			if errors.Is(err, ringbuf.ErrClosed) {
+				return
+			}
+
 			if err != nil {
 				log.Printf("Failed reading from ringbuf: %v", err)
 				return
 			}
 
-			eventChan <- event.RawSample
+			eventChan <- event
 		}
 	}()
 
 	for {
 		select {
 		case <-ctx.Done():
-			flowtable.Ticker.Stop()
-			return probe.Close()
+			// Give the terminal back before printing anything
+			<-viewDone
+			sinks.Close()
+			cancelSinks()
+
+			<-otlpDone
+			<-statsdDone
+			<-ipfixDone
+			<-historyDone
+			<-pcapDone
+
+			log.Printf("Flow table stats: %+v", flowTable.Stats())
+
+			if detector != nil && userInput.BaselineFile != "" {
+				if err := detector.Save(userInput.BaselineFile); err != nil {
+					log.Printf("Failed saving baselines: %v", err)
+				}
+			}
+
+			if userInput.Stats {
+				stats.WriteTable(os.Stdout, statistics.Entries(), destinationName)
+			}
+
+			return ringbufSource.Close()
 
 		case pkt := <-eventChan:
+			if promMetrics != nil {
+				promMetrics.Event()
+			}
+
+			if recording != nil {
+				if err := recording.Write(time.Now(), pkt); err != nil {
+					log.Printf("Failed writing to recording: %v", err)
+				}
+			}
+
 			packetAttrs, ok := packet.UnmarshalBinary(pkt)
 			if !ok {
+				if promMetrics != nil {
+					promMetrics.Malformed()
+				}
+
 				log.Printf("Could not unmarshall packet: %+v", pkt)
 				continue
 			}
 
-			// user has not provided and IP or port to filter on
-			if !userInput.IP.IsValid() && userInput.Port == 0 {
-				packet.CalcLatency(packetAttrs, flowtable)
-			} else if userInput.IP == packetAttrs.DstIP.Unmap() || userInput.IP == packetAttrs.SrcIP.Unmap() {
-				packet.CalcLatency(packetAttrs, flowtable)
-			} else if userInput.Port == packetAttrs.DstPort || userInput.Port == packetAttrs.SrcPort {
-				packet.CalcLatency(packetAttrs, flowtable)
+			packetAttrs.Interface = userInput.Interface.Attrs().Index
+
+			if resolver != nil {
+				attributeProcess(&packetAttrs, resolver)
+			}
+
+			if kubeStore != nil {
+				packetAttrs.SrcKube, _ = kubeStore.Lookup(packetAttrs.SrcIP)
+				packetAttrs.DstKube, _ = kubeStore.Lookup(packetAttrs.DstIP)
+			}
+
+			if geoDB != nil {
+				packetAttrs.SrcGeo, _ = geoDB.Lookup(packetAttrs.SrcIP)
+				packetAttrs.DstGeo, _ = geoDB.Lookup(packetAttrs.DstIP)
+			}
+
+			if !shouldTrack(userInput, packetAttrs) {
+				continue
+			}
+
+			packetAttrs.SrcHost = hostname(packetAttrs.SrcIP, dnsTable, hostResolver)
+			packetAttrs.DstHost = hostname(packetAttrs.DstIP, dnsTable, hostResolver)
+
+			result, ok := packet.CalcLatency(packetAttrs, flowTable)
+			if !ok {
+				continue
+			}
+
+			latency := result.Latency
+
+			statistics.Record(destination(packetAttrs), latency)
+
+			if reporter != nil {
+				reporter.Record(groupKey(packetAttrs.Reverse()), latency)
+			}
+
+			if alerter != nil {
+				alerter.Observe(packetAttrs.Reverse(), latency, time.Now())
+			}
+
+			if detector != nil {
+				if anomaly, flagged := detector.Observe(destination(packetAttrs), latency); flagged {
+					log.Printf("Anomaly: %v", anomaly)
+
+					if alerter != nil {
+						alerter.Anomaly(packetAttrs.Reverse(), anomaly, time.Now())
+					}
+
+					sinks.Anomaly(result, anomaly)
+				}
+			}
+
+			if view != nil {
+				view.Record(destination(packetAttrs), viewName(packetAttrs), latency)
+			}
+
+			sinks.Latency(result)
+
+		case c := <-commands:
+			c.reply <- ctl.handle(&userInput, c.request)
+
+		case refusal := <-refusals:
+			key := flowtable.NewFlowKey(refusal[0], refusal[1], unix.IPPROTO_TCP, userInput.Interface.Attrs().Index)
+
+			// Only a RST answering a pending SYN is a refused connection
+			if initiator, ok := flowTable.Get(key); ok && initiator.Syn {
+				flowTable.Remove(key)
+
+				if reporter != nil {
+					reporter.Refused(groupKey(initiator))
+				}
+
+				sinks.Refused(initiator)
 			}
 		}
 	}
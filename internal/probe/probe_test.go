@@ -1,47 +1,39 @@
 package probe
 
 import (
+	"net/netip"
 	"testing"
 
-	"github.com/pouriyajamshidi/flat/internal/packets"
+	"github.com/pouriyajamshidi/flat/internal/geoip"
+	"github.com/pouriyajamshidi/flat/internal/kube"
+	"github.com/pouriyajamshidi/flat/internal/packet"
+	"github.com/pouriyajamshidi/flat/internal/types"
 	"github.com/stretchr/testify/require"
 )
 
-func TestTCPv4SYNPacket(t *testing.T) {
-	prbe := probe{}
-	err := prbe.loadObjects()
-	require.NoError(t, err)
-
-	in := packets.TCPv4SYN()
-	res, out, err := prbe.bpfObjects.Flat.Test(in)
-
-	require.NoError(t, err)
-	require.Equal(t, uint32(0), res)
-	require.Equal(t, in, out)
-}
-
-func TestTCPv4ACKPacket(t *testing.T) {
-	prbe := probe{}
-	err := prbe.loadObjects()
-	require.NoError(t, err)
-
-	in := packets.TCPv4ACK()
-	res, out, err := prbe.bpfObjects.Flat.Test(in)
-
-	require.NoError(t, err)
-	require.Equal(t, uint32(0), res)
-	require.Equal(t, in, out)
-}
-
-func TestTCPv4SYNACKPacket(t *testing.T) {
-	prbe := probe{}
-	err := prbe.loadObjects()
-	require.NoError(t, err)
-
-	in := packets.TCPv4SYNACK()
-	res, out, err := prbe.bpfObjects.Flat.Test(in)
-
-	require.NoError(t, err)
-	require.Equal(t, uint32(0), res)
-	require.Equal(t, in, out)
+func TestShouldTrack(t *testing.T) {
+	pkt := packet.Packet{
+		SrcIP:   netip.MustParseAddr("::ffff:10.244.1.5"),
+		DstIP:   netip.MustParseAddr("::ffff:10.96.0.20"),
+		SrcPort: 40000,
+		DstPort: 80,
+		SrcKube: kube.Metadata{Namespace: "shop", Pod: "web-7d4f"},
+		DstKube: kube.Metadata{Namespace: "shop", Service: "web"},
+		DstGeo:  geoip.Location{Country: "AU", ASN: 13335},
+	}
+
+	require.True(t, shouldTrack(types.UserInput{}, pkt))
+	require.True(t, shouldTrack(types.UserInput{IPs: []netip.Addr{netip.MustParseAddr("10.96.0.20")}}, pkt))
+	require.True(t, shouldTrack(types.UserInput{Ports: []uint16{80}}, pkt))
+	require.True(t, shouldTrack(types.UserInput{Ports: []uint16{443, 80}}, pkt))
+	require.True(t, shouldTrack(types.UserInput{KubePod: "shop/web-7d4f"}, pkt))
+	require.True(t, shouldTrack(types.UserInput{KubeService: "shop/web"}, pkt))
+	require.True(t, shouldTrack(types.UserInput{Country: "AU"}, pkt))
+	require.True(t, shouldTrack(types.UserInput{ASN: 13335}, pkt))
+
+	require.False(t, shouldTrack(types.UserInput{Ports: []uint16{443}}, pkt))
+	require.False(t, shouldTrack(types.UserInput{KubePod: "shop/web"}, pkt))
+	require.False(t, shouldTrack(types.UserInput{KubeService: "default/web"}, pkt))
+	require.False(t, shouldTrack(types.UserInput{Country: "US"}, pkt))
+	require.False(t, shouldTrack(types.UserInput{ASN: 15169}, pkt))
 }
@@ -0,0 +1,88 @@
+package probe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/pouriyajamshidi/flat/internal/packet"
+	"github.com/pouriyajamshidi/flat/internal/record"
+	"github.com/pouriyajamshidi/flat/internal/types"
+)
+
+// Replay feeds the ringbuf events of a recording through the latency
+// calculation, as fast as possible or paced the way they were recorded
+func Replay(ctx context.Context, userInput types.UserInput) error {
+	f, err := os.Open(userInput.ReadFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader, err := record.NewReader(f)
+	if err != nil {
+		log.Printf("Failed reading %v: %v", userInput.ReadFile, err)
+		return err
+	}
+
+	return replay(ctx, userInput, reader, os.Stdout)
+}
+
+func replay(ctx context.Context, userInput types.UserInput, reader *record.Reader, w io.Writer) error {
+	header := reader.Header()
+
+	if header.Schema != packet.SchemaVersion {
+		return fmt.Errorf("recorded with packet_t schema %d, this build decodes schema %d", header.Schema, packet.SchemaVersion)
+	}
+
+	pipeline := newOffline(userInput, header.Interface, header.Boot, w)
+
+	// first and started relate the recording's clock to the wall clock
+	var first, started time.Time
+	var events, malformed int
+
+	for ctx.Err() == nil {
+		event, err := reader.Next()
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				log.Printf("Failed reading event %d of %v: %v", events+1, userInput.ReadFile, err)
+			}
+			break
+		}
+
+		if userInput.Realtime {
+			if first.IsZero() {
+				first, started = event.Received, time.Now()
+			}
+
+			if wait := event.Received.Sub(first) - time.Since(started); wait > 0 {
+				select {
+				case <-ctx.Done():
+					continue
+				case <-time.After(wait):
+				}
+			}
+		}
+
+		events++
+
+		packetAttrs, ok := packet.UnmarshalBinary(event.Sample)
+		if !ok {
+			malformed++
+			log.Printf("Could not unmarshall packet: %+v", event.Sample)
+			continue
+		}
+
+		pipeline.advance(packetAttrs.TimeStamp)
+		pipeline.process(packetAttrs)
+	}
+
+	log.Printf("Replayed %d events (%d malformed), %d flows were still pending at the end of the recording",
+		events, malformed, pipeline.flowTable.Entries())
+
+	return pipeline.finish(w)
+}
@@ -0,0 +1,127 @@
+package probe
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pouriyajamshidi/flat/internal/flowtable"
+	"github.com/pouriyajamshidi/flat/internal/output"
+	"github.com/pouriyajamshidi/flat/internal/packet"
+	"github.com/pouriyajamshidi/flat/internal/record"
+	"github.com/pouriyajamshidi/flat/internal/types"
+	"github.com/stretchr/testify/require"
+)
+
+// sample encodes a packet the way the eBPF program fills packet_t
+func sample(src, dst netip.AddrPort, protocol uint8, syn, ack bool, timestamp uint64) []byte {
+	srcIP, dstIP := netip.AddrFrom16(src.Addr().As16()).As16(), netip.AddrFrom16(dst.Addr().As16()).As16()
+
+	buf := append(srcIP[:], dstIP[:]...)
+	buf = binary.BigEndian.AppendUint16(buf, src.Port())
+	buf = binary.BigEndian.AppendUint16(buf, dst.Port())
+
+	var flags [2]byte
+
+	if syn {
+		flags[0] = 1
+	}
+
+	if ack {
+		flags[1] = 1
+	}
+
+	buf = append(buf, protocol, 64, flags[0], flags[1])
+
+	return binary.LittleEndian.AppendUint64(buf, timestamp)
+}
+
+func recording(t *testing.T, events ...record.Event) *record.Reader {
+	path := filepath.Join(t.TempDir(), "events.bin")
+
+	writer, err := record.Create(path, record.Header{Schema: packet.SchemaVersion, Boot: time.Unix(1700000000, 0), Interface: "eth0"})
+	require.NoError(t, err)
+
+	for _, event := range events {
+		require.NoError(t, writer.Write(event.Received, event.Sample))
+	}
+
+	require.NoError(t, writer.Close())
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { f.Close() })
+
+	reader, err := record.NewReader(f)
+	require.NoError(t, err)
+
+	return reader
+}
+
+func TestReplay(t *testing.T) {
+	client := netip.MustParseAddrPort("192.168.0.156:53264")
+	server := netip.MustParseAddrPort("1.1.1.1:443")
+	received := time.Now()
+
+	reader := recording(t,
+		record.Event{Received: received, Sample: sample(client, server, 6, true, false, 5_000_000_000)},
+		record.Event{Received: received, Sample: []byte{1, 2, 3}},
+		record.Event{Received: received, Sample: sample(server, client, 6, true, true, 5_012_000_000)},
+	)
+
+	userInput := types.UserInput{Output: "json", FlowTable: flowtable.DefaultConfig()}
+
+	var out bytes.Buffer
+	require.NoError(t, replay(context.Background(), userInput, reader, &out))
+
+	var result output.Record
+	require.NoError(t, json.Unmarshal(out.Bytes(), &result))
+	require.Equal(t, output.Latency, result.Type)
+	require.Equal(t, int64(12*time.Millisecond), result.LatencyNs)
+	require.Equal(t, "eth0", result.Interface)
+	require.Equal(t, client.Addr().String(), result.SrcIP)
+	require.True(t, time.Unix(1700000005, 12_000_000).Equal(result.Time))
+}
+
+func TestReplayRealtime(t *testing.T) {
+	client := netip.MustParseAddrPort("192.168.0.156:53264")
+	server := netip.MustParseAddrPort("1.1.1.1:443")
+	received := time.Now()
+
+	reader := recording(t,
+		record.Event{Received: received, Sample: sample(client, server, 6, true, false, 5_000_000_000)},
+		record.Event{Received: received.Add(100 * time.Millisecond), Sample: sample(server, client, 6, true, true, 5_100_000_000)},
+	)
+
+	userInput := types.UserInput{Output: "json", FlowTable: flowtable.DefaultConfig(), Realtime: true}
+
+	start := time.Now()
+
+	var out bytes.Buffer
+	require.NoError(t, replay(context.Background(), userInput, reader, &out))
+	require.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+	require.NotEmpty(t, out.String())
+}
+
+func TestReplaySchemaMismatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.bin")
+
+	writer, err := record.Create(path, record.Header{Schema: packet.SchemaVersion + 1})
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	reader, err := record.NewReader(f)
+	require.NoError(t, err)
+
+	require.Error(t, replay(context.Background(), types.UserInput{Output: "json"}, reader, &bytes.Buffer{}))
+}
@@ -0,0 +1,344 @@
+package process
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"log"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultProcRoot = "/proc"
+
+// minScanInterval limits how often /proc is rescanned on lookup misses
+const minScanInterval = time.Millisecond * 250
+
+// socket tables to read from procfs along with their IP protocol numbers
+var socketTables = []struct {
+	file     string
+	protocol uint8
+}{
+	{"net/tcp", 6},
+	{"net/tcp6", 6},
+	{"net/udp", 17},
+	{"net/udp6", 17},
+}
+
+// Process represents the local process owning a socket
+type Process struct {
+	PID  uint32
+	Comm string
+}
+
+type socketKey struct {
+	protocol uint8
+	local    netip.AddrPort
+	remote   netip.AddrPort
+}
+
+type portKey struct {
+	protocol uint8
+	port     uint16
+}
+
+// Resolver maps socket 4-tuples to the processes that own them
+// using the socket tables and file descriptors exposed in procfs.
+// Lookups are served from the last scan, which Run refreshes
+type Resolver struct {
+	procRoot string
+	local    []netip.Addr
+	rescan   chan struct{}
+
+	mu      sync.RWMutex
+	sockets map[socketKey]uint64
+	ports   map[portKey]uint64
+	inodes  map[uint64]Process
+}
+
+// NewResolver constructs a new Resolver reading from /proc for a host
+// with the given addresses
+func NewResolver(local []netip.Addr) *Resolver {
+	return newResolver(defaultProcRoot, local)
+}
+
+func newResolver(procRoot string, local []netip.Addr) *Resolver {
+	unmapped := make([]netip.Addr, len(local))
+
+	for i, addr := range local {
+		unmapped[i] = addr.Unmap()
+	}
+
+	return &Resolver{
+		procRoot: procRoot,
+		local:    unmapped,
+		rescan:   make(chan struct{}, 1),
+		sockets:  make(map[socketKey]uint64),
+		ports:    make(map[portKey]uint64),
+		inodes:   make(map[uint64]Process),
+	}
+}
+
+// Lookup finds the local process for a packet travelling from src to dst.
+// Either end can be the local one, so both orientations are tried.
+// A miss on a flow to or from this host asks Run for a rescan instead of
+// waiting for one. Forwarded flows have no local process to find
+func (r *Resolver) Lookup(protocol uint8, src, dst netip.AddrPort) (Process, bool) {
+	src = unmap(src)
+	dst = unmap(dst)
+
+	r.mu.RLock()
+	proc, ok := r.lookup(protocol, src, dst)
+	r.mu.RUnlock()
+
+	if !ok && (r.isLocal(src.Addr()) || r.isLocal(dst.Addr())) {
+		select {
+		case r.rescan <- struct{}{}:
+		default:
+		}
+	}
+
+	return proc, ok
+}
+
+// isLocal tells whether an address belongs to this host
+func (r *Resolver) isLocal(addr netip.Addr) bool {
+	return addr.IsLoopback() || slices.Contains(r.local, addr)
+}
+
+// lookup finds the process of a packet in the last scan. Callers must hold the lock
+func (r *Resolver) lookup(protocol uint8, src, dst netip.AddrPort) (Process, bool) {
+	inode, ok := r.sockets[socketKey{protocol, src, dst}]
+
+	if !ok {
+		inode, ok = r.sockets[socketKey{protocol, dst, src}]
+	}
+
+	// Unconnected and listening sockets only have a local port,
+	// which says nothing about the remote end of a packet
+	if !ok && r.isLocal(src.Addr()) {
+		inode, ok = r.ports[portKey{protocol, src.Port()}]
+	}
+
+	if !ok && r.isLocal(dst.Addr()) {
+		inode, ok = r.ports[portKey{protocol, dst.Port()}]
+	}
+
+	if !ok {
+		return Process{}, false
+	}
+
+	proc, ok := r.inodes[inode]
+
+	return proc, ok
+}
+
+// Run scans procfs, then rescans it whenever a lookup misses, at most
+// every minScanInterval, until ctx is cancelled
+func (r *Resolver) Run(ctx context.Context) {
+	r.scan()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.rescan:
+		}
+
+		r.scan()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(minScanInterval):
+		}
+	}
+}
+
+// scan rebuilds the socket and inode caches from procfs,
+// only holding the lock to swap them in
+func (r *Resolver) scan() {
+	sockets := make(map[socketKey]uint64)
+	ports := make(map[portKey]uint64)
+
+	for _, table := range socketTables {
+		if err := readSocketTable(filepath.Join(r.procRoot, table.file), table.protocol, sockets, ports); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed reading socket table %v: %v", table.file, err)
+		}
+	}
+
+	r.mu.RLock()
+	inodes := r.inodes
+	r.mu.RUnlock()
+
+	// Listening and unconnected sockets only show up in ports
+	if !known(inodes, sockets) || !known(inodes, ports) {
+		inodes = r.readInodes()
+	}
+
+	r.mu.Lock()
+	r.sockets = sockets
+	r.ports = ports
+	r.inodes = inodes
+	r.mu.Unlock()
+}
+
+// known tells whether the owners of all the socket inodes have been read
+func known[K comparable](inodes map[uint64]Process, sockets map[K]uint64) bool {
+	for _, inode := range sockets {
+		if _, ok := inodes[inode]; !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// readSocketTable parses a /proc/net/{tcp,udp}{,6} file
+func readSocketTable(path string, protocol uint8, sockets map[socketKey]uint64, ports map[portKey]uint64) error {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Scan() // skip the header line
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+
+		if len(fields) < 10 {
+			continue
+		}
+
+		local, ok := parseAddrPort(fields[1])
+		if !ok {
+			continue
+		}
+
+		remote, ok := parseAddrPort(fields[2])
+		if !ok {
+			continue
+		}
+
+		inode, err := strconv.ParseUint(fields[9], 10, 64)
+		if err != nil || inode == 0 {
+			continue
+		}
+
+		if remote.Port() == 0 {
+			ports[portKey{protocol, local.Port()}] = inode
+			continue
+		}
+
+		sockets[socketKey{protocol, local, remote}] = inode
+	}
+
+	return scanner.Err()
+}
+
+// parseAddrPort decodes the hex encoded "ADDR:PORT" notation used in procfs.
+// Addresses are printed as 32 bit words in host byte order
+func parseAddrPort(value string) (netip.AddrPort, bool) {
+	addrHex, portHex, found := strings.Cut(value, ":")
+
+	if !found {
+		return netip.AddrPort{}, false
+	}
+
+	raw, err := hex.DecodeString(addrHex)
+
+	if err != nil || (len(raw) != 4 && len(raw) != 16) {
+		return netip.AddrPort{}, false
+	}
+
+	for i := 0; i < len(raw); i += 4 {
+		binary.NativeEndian.PutUint32(raw[i:i+4], binary.BigEndian.Uint32(raw[i:i+4]))
+	}
+
+	addr, _ := netip.AddrFromSlice(raw)
+
+	port, err := strconv.ParseUint(portHex, 16, 16)
+
+	if err != nil {
+		return netip.AddrPort{}, false
+	}
+
+	return netip.AddrPortFrom(addr.Unmap(), uint16(port)), true
+}
+
+// readInodes maps socket inodes to their owning processes
+// by walking the file descriptors of every process
+func (r *Resolver) readInodes() map[uint64]Process {
+	inodes := make(map[uint64]Process)
+
+	entries, err := os.ReadDir(r.procRoot)
+
+	if err != nil {
+		log.Printf("Failed reading %v: %v", r.procRoot, err)
+		return inodes
+	}
+
+	for _, entry := range entries {
+		pid, err := strconv.ParseUint(entry.Name(), 10, 32)
+
+		if err != nil {
+			continue
+		}
+
+		fdDir := filepath.Join(r.procRoot, entry.Name(), "fd")
+
+		fds, err := os.ReadDir(fdDir)
+
+		if err != nil {
+			continue // process exited or we lack permissions
+		}
+
+		var comm string
+
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+
+			if err != nil || !strings.HasPrefix(link, "socket:[") {
+				continue
+			}
+
+			inode, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]"), 10, 64)
+
+			if err != nil {
+				continue
+			}
+
+			if comm == "" {
+				comm = readComm(filepath.Join(r.procRoot, entry.Name(), "comm"))
+			}
+
+			inodes[inode] = Process{PID: uint32(pid), Comm: comm}
+		}
+	}
+
+	return inodes
+}
+
+func readComm(path string) string {
+	comm, err := os.ReadFile(path)
+
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(comm))
+}
+
+func unmap(addrPort netip.AddrPort) netip.AddrPort {
+	return netip.AddrPortFrom(addrPort.Addr().Unmap(), addrPort.Port())
+}
@@ -0,0 +1,154 @@
+package process
+
+import (
+	"context"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const tcpTable = `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 9C00A8C0:D010 01010101:01BB 02 00000000:00000000 00:00000000 00000000  1000        0 4242 1 0000000000000000 100 0 0 10 0
+`
+
+const udpTable = `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode ref pointer drops
+ 1: 00000000:0035 00000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 5353 2 0000000000000000 0
+`
+
+func writeProcFixture(t *testing.T) string {
+	root := t.TempDir()
+
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "net"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "net", "tcp"), []byte(tcpTable), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "net", "udp"), []byte(udpTable), 0o644))
+
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "1234", "fd"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "1234", "comm"), []byte("curl\n"), 0o644))
+	require.NoError(t, os.Symlink("socket:[4242]", filepath.Join(root, "1234", "fd", "3")))
+
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "53", "fd"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "53", "comm"), []byte("dnsmasq\n"), 0o644))
+	require.NoError(t, os.Symlink("socket:[5353]", filepath.Join(root, "53", "fd", "7")))
+
+	return root
+}
+
+func TestParseAddrPort(t *testing.T) {
+	addrPort, ok := parseAddrPort("0100007F:0035")
+	require.True(t, ok)
+	require.Equal(t, netip.MustParseAddrPort("127.0.0.1:53"), addrPort)
+
+	addrPort, ok = parseAddrPort("0000000000000000FFFF00000100007F:01BB")
+	require.True(t, ok)
+	require.Equal(t, netip.MustParseAddrPort("127.0.0.1:443"), addrPort)
+
+	_, ok = parseAddrPort("garbage")
+	require.False(t, ok)
+}
+
+// scanned returns a Resolver of the fixture that has already scanned it
+func scanned(t *testing.T) *Resolver {
+	resolver := newResolver(writeProcFixture(t), []netip.Addr{
+		netip.MustParseAddr("192.168.0.156"),
+		netip.MustParseAddr("10.0.0.1"),
+	})
+	resolver.scan()
+
+	return resolver
+}
+
+func TestLookupConnectedSocket(t *testing.T) {
+	resolver := scanned(t)
+
+	synAck := []netip.AddrPort{
+		netip.MustParseAddrPort("[::ffff:1.1.1.1]:443"),
+		netip.MustParseAddrPort("[::ffff:192.168.0.156]:53264"),
+	}
+
+	proc, ok := resolver.Lookup(6, synAck[0], synAck[1])
+	require.True(t, ok)
+	require.Equal(t, Process{PID: 1234, Comm: "curl"}, proc)
+
+	// Same socket seen in the opposite direction
+	proc, ok = resolver.Lookup(6, synAck[1], synAck[0])
+	require.True(t, ok)
+	require.Equal(t, uint32(1234), proc.PID)
+}
+
+func TestLookupUnconnectedSocket(t *testing.T) {
+	resolver := scanned(t)
+
+	proc, ok := resolver.Lookup(17,
+		netip.MustParseAddrPort("10.0.0.2:40000"),
+		netip.MustParseAddrPort("10.0.0.1:53"),
+	)
+	require.True(t, ok)
+	require.Equal(t, Process{PID: 53, Comm: "dnsmasq"}, proc)
+
+	_, ok = resolver.Lookup(6,
+		netip.MustParseAddrPort("10.0.0.2:40000"),
+		netip.MustParseAddrPort("10.0.0.1:53"),
+	)
+	require.False(t, ok)
+
+	// A query from this host to a remote DNS server is not the local listener's
+	_, ok = resolver.Lookup(17,
+		netip.MustParseAddrPort("10.0.0.1:40000"),
+		netip.MustParseAddrPort("9.9.9.9:53"),
+	)
+	require.False(t, ok)
+
+	_, ok = resolver.Lookup(17,
+		netip.MustParseAddrPort("9.9.9.9:53"),
+		netip.MustParseAddrPort("10.0.0.1:40000"),
+	)
+	require.False(t, ok)
+}
+
+func TestScanNewListener(t *testing.T) {
+	resolver := scanned(t)
+
+	// Only the UDP table gains a socket, the connected ones are all known
+	root := resolver.procRoot
+	listener := udpTable + ` 2: 00000000:0202 00000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 6161 2 0000000000000000 0
+`
+	require.NoError(t, os.WriteFile(filepath.Join(root, "net", "udp"), []byte(listener), 0o644))
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "514", "fd"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "514", "comm"), []byte("rsyslogd\n"), 0o644))
+	require.NoError(t, os.Symlink("socket:[6161]", filepath.Join(root, "514", "fd", "4")))
+
+	resolver.scan()
+
+	proc, ok := resolver.Lookup(17,
+		netip.MustParseAddrPort("10.0.0.2:40000"),
+		netip.MustParseAddrPort("10.0.0.1:514"),
+	)
+	require.True(t, ok)
+	require.Equal(t, Process{PID: 514, Comm: "rsyslogd"}, proc)
+}
+
+func TestLookupMissRescans(t *testing.T) {
+	resolver := newResolver(writeProcFixture(t), []netip.Addr{netip.MustParseAddr("192.168.0.156")})
+
+	// A forwarded flow has no local process, so it does not ask for a rescan
+	_, ok := resolver.Lookup(6, netip.MustParseAddrPort("10.0.0.2:40000"), netip.MustParseAddrPort("1.1.1.1:443"))
+	require.False(t, ok)
+	require.Empty(t, resolver.rescan)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go resolver.Run(ctx)
+
+	src := netip.MustParseAddrPort("192.168.0.156:53264")
+	dst := netip.MustParseAddrPort("1.1.1.1:443")
+
+	require.Eventually(t, func() bool {
+		proc, ok := resolver.Lookup(6, src, dst)
+		return ok && proc.PID == 1234
+	}, time.Second, time.Millisecond*10)
+}
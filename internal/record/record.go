@@ -0,0 +1,170 @@
+package record
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Version is bumped whenever the framing of the file changes
+const Version = 1
+
+// maxSampleSize guards against reading a corrupt length
+const maxSampleSize = 1 << 16
+
+// magic starts every recording
+var magic = [8]byte{'F', 'L', 'A', 'T', 'R', 'E', 'C', '\n'}
+
+// Header describes a recording. All integers are little endian:
+//
+//	magic [8]byte | version uint16 | schema uint16 | boot int64 | name length uint16 | name
+//
+// and every event that follows is framed as
+//
+//	length uint32 | received int64 | sample [length]byte
+type Header struct {
+	Schema    uint16    // packet_t layout of the samples
+	Boot      time.Time // wall clock time of the monotonic clock's zero
+	Interface string
+}
+
+// Event is a raw ringbuf sample and when it was read
+type Event struct {
+	Received time.Time
+	Sample   []byte
+}
+
+// Writer appends ringbuf samples to a recording
+type Writer struct {
+	mu     sync.Mutex
+	file   *os.File
+	writer *bufio.Writer
+}
+
+// Create creates a recording at path and writes its header
+func Create(path string, header Header) (*Writer, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Writer{file: f, writer: bufio.NewWriter(f)}
+
+	buf := append([]byte(nil), magic[:]...)
+	buf = binary.LittleEndian.AppendUint16(buf, Version)
+	buf = binary.LittleEndian.AppendUint16(buf, header.Schema)
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(header.Boot.UnixNano()))
+	buf = binary.LittleEndian.AppendUint16(buf, uint16(len(header.Interface)))
+	buf = append(buf, header.Interface...)
+
+	if _, err := w.writer.Write(buf); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// Write appends a sample read at received
+func (w *Writer) Write(received time.Time, sample []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	buf := binary.LittleEndian.AppendUint32(nil, uint32(len(sample)))
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(received.UnixNano()))
+	buf = append(buf, sample...)
+
+	_, err := w.writer.Write(buf)
+
+	return err
+}
+
+// Close flushes and closes the recording
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.writer.Flush(); err != nil {
+		w.file.Close()
+		return err
+	}
+
+	return w.file.Close()
+}
+
+// Reader reads the events of a recording
+type Reader struct {
+	reader *bufio.Reader
+	header Header
+}
+
+// NewReader reads and validates the header of a recording
+func NewReader(r io.Reader) (*Reader, error) {
+	reader := &Reader{reader: bufio.NewReader(r)}
+
+	var fixed [22]byte
+
+	if _, err := io.ReadFull(reader.reader, fixed[:]); err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+
+	if [8]byte(fixed[:8]) != magic {
+		return nil, errors.New("not a flat recording")
+	}
+
+	if version := binary.LittleEndian.Uint16(fixed[8:10]); version != Version {
+		return nil, fmt.Errorf("unsupported recording version %d, expected %d", version, Version)
+	}
+
+	reader.header.Schema = binary.LittleEndian.Uint16(fixed[10:12])
+	reader.header.Boot = time.Unix(0, int64(binary.LittleEndian.Uint64(fixed[12:20])))
+
+	name := make([]byte, binary.LittleEndian.Uint16(fixed[20:22]))
+
+	if _, err := io.ReadFull(reader.reader, name); err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+
+	reader.header.Interface = string(name)
+
+	return reader, nil
+}
+
+// Header returns the header of the recording
+func (r *Reader) Header() Header {
+	return r.header
+}
+
+// Next returns the next event, or io.EOF at the end of the recording
+func (r *Reader) Next() (Event, error) {
+	var frame [12]byte
+
+	if _, err := io.ReadFull(r.reader, frame[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return Event{}, fmt.Errorf("truncated event: %w", err)
+		}
+		return Event{}, err
+	}
+
+	length := binary.LittleEndian.Uint32(frame[:4])
+
+	if length > maxSampleSize {
+		return Event{}, fmt.Errorf("corrupt event of %d bytes", length)
+	}
+
+	event := Event{
+		Received: time.Unix(0, int64(binary.LittleEndian.Uint64(frame[4:12]))),
+		Sample:   make([]byte, length),
+	}
+
+	if _, err := io.ReadFull(r.reader, event.Sample); err != nil {
+		return Event{}, fmt.Errorf("truncated event: %w", err)
+	}
+
+	return event, nil
+}
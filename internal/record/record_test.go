@@ -0,0 +1,86 @@
+package record
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.bin")
+	header := Header{Schema: 1, Boot: time.Unix(1700000000, 123), Interface: "eth0"}
+
+	writer, err := Create(path, header)
+	require.NoError(t, err)
+
+	events := []Event{
+		{Received: time.Unix(1700000100, 1), Sample: make([]byte, 48)},
+		{Received: time.Unix(1700000100, 2), Sample: []byte{1, 2, 3}},
+	}
+
+	for _, event := range events {
+		require.NoError(t, writer.Write(event.Received, event.Sample))
+	}
+
+	require.NoError(t, writer.Close())
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	reader, err := NewReader(f)
+	require.NoError(t, err)
+	require.Equal(t, header.Schema, reader.Header().Schema)
+	require.True(t, header.Boot.Equal(reader.Header().Boot))
+	require.Equal(t, header.Interface, reader.Header().Interface)
+
+	for _, want := range events {
+		event, err := reader.Next()
+		require.NoError(t, err)
+		require.True(t, want.Received.Equal(event.Received))
+		require.Equal(t, want.Sample, event.Sample)
+	}
+
+	_, err = reader.Next()
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestTruncated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.bin")
+
+	writer, err := Create(path, Header{Schema: 1})
+	require.NoError(t, err)
+	require.NoError(t, writer.Write(time.Now(), make([]byte, 48)))
+	require.NoError(t, writer.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, data[:len(data)-1], 0o644))
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	reader, err := NewReader(f)
+	require.NoError(t, err)
+
+	_, err = reader.Next()
+	require.Error(t, err)
+	require.NotErrorIs(t, err, io.EOF)
+}
+
+func TestNotARecording(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.pcap")
+	require.NoError(t, os.WriteFile(path, make([]byte, 64), 0o644))
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	_, err = NewReader(f)
+	require.Error(t, err)
+}
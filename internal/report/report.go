@@ -0,0 +1,208 @@
+package report
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pouriyajamshidi/flat/internal/packet"
+	"github.com/pouriyajamshidi/flat/internal/stats"
+)
+
+// GroupBy names the supported aggregation keys
+var GroupBy = []string{"destination", "flow", "host", "pod", "service", "country", "asn"}
+
+// KeyFunc returns the function that derives the aggregation key of a
+// packet travelling from the client to the server
+func KeyFunc(groupBy string) (func(packet.Packet) string, error) {
+	switch groupBy {
+	case "destination":
+		return func(pkt packet.Packet) string {
+			return fmt.Sprintf("(%v) %v", packet.ProtocolName(pkt.Protocol), pkt.DstAddrPort())
+		}, nil
+	case "flow":
+		return func(pkt packet.Packet) string {
+			return fmt.Sprintf("(%v) %v -> %v", packet.ProtocolName(pkt.Protocol), pkt.SrcAddrPort(), pkt.DstAddrPort())
+		}, nil
+	case "host":
+		return func(pkt packet.Packet) string {
+			return cmp.Or(pkt.DstHost, pkt.DstIP.Unmap().String())
+		}, nil
+	case "pod":
+		return func(pkt packet.Packet) string {
+			if pkt.DstKube.Pod == "" {
+				return "-"
+			}
+			return pkt.DstKube.Namespace + "/" + pkt.DstKube.Pod
+		}, nil
+	case "service":
+		return func(pkt packet.Packet) string {
+			if pkt.DstKube.Service == "" {
+				return "-"
+			}
+			return pkt.DstKube.Namespace + "/" + pkt.DstKube.Service
+		}, nil
+	case "country":
+		return func(pkt packet.Packet) string {
+			return cmp.Or(pkt.DstGeo.Country, "-")
+		}, nil
+	case "asn":
+		return func(pkt packet.Packet) string {
+			if pkt.DstGeo.ASN == 0 {
+				return "-"
+			}
+			return fmt.Sprintf("AS%d %v", pkt.DstGeo.ASN, pkt.DstGeo.Org)
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown group %q, expected one of %v", groupBy, GroupBy)
+	}
+}
+
+type row struct {
+	sketch   *stats.Sketch
+	timeouts uint64
+	refused  uint64
+}
+
+// Row holds the aggregated results of one key over an interval
+type Row struct {
+	Key      string
+	Summary  stats.Summary
+	Timeouts uint64
+	Refused  uint64
+}
+
+// Reporter aggregates results and periodically prints them as a table
+type Reporter struct {
+	groupBy  string
+	interval time.Duration
+	mu       sync.Mutex
+	rows     map[string]*row
+}
+
+// New constructs a new Reporter
+func New(groupBy string, interval time.Duration) *Reporter {
+	return &Reporter{
+		groupBy:  groupBy,
+		interval: interval,
+		rows:     make(map[string]*row),
+	}
+}
+
+// row returns the row of key, creating it if needed. Callers must hold the lock
+func (r *Reporter) row(key string) *row {
+	current, ok := r.rows[key]
+
+	if !ok {
+		current = &row{sketch: stats.NewSketch(stats.DefaultRelativeAccuracy)}
+		r.rows[key] = current
+	}
+
+	return current
+}
+
+// Record adds a latency sample for key
+func (r *Reporter) Record(key string, latency time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.row(key).sketch.Add(float64(latency))
+}
+
+// Timeout counts a flow of key that never got a reply
+func (r *Reporter) Timeout(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.row(key).timeouts++
+}
+
+// Refused counts a connection attempt of key that was reset
+func (r *Reporter) Refused(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.row(key).refused++
+}
+
+// Rotate returns the rows of the current interval, busiest first,
+// and starts a new interval
+func (r *Reporter) Rotate() []Row {
+	r.mu.Lock()
+	current := r.rows
+	r.rows = make(map[string]*row)
+	r.mu.Unlock()
+
+	rows := make([]Row, 0, len(current))
+
+	for key, aggregate := range current {
+		rows = append(rows, Row{
+			Key:      key,
+			Summary:  stats.Summarize(aggregate.sketch),
+			Timeouts: aggregate.timeouts,
+			Refused:  aggregate.refused,
+		})
+	}
+
+	slices.SortFunc(rows, func(a, b Row) int {
+		return cmp.Or(
+			cmp.Compare(b.Summary.Count+b.Timeouts+b.Refused, a.Summary.Count+a.Timeouts+a.Refused),
+			cmp.Compare(a.Key, b.Key),
+		)
+	})
+
+	return rows
+}
+
+func millis(d time.Duration) string {
+	return fmt.Sprintf("%.3f", float64(d)/float64(time.Millisecond))
+}
+
+// Write prints the rows of an interval ending at end as an aligned table
+func (r *Reporter) Write(w io.Writer, end time.Time, rows []Row) error {
+	fmt.Fprintf(w, "\n%v - %v\n", end.Add(-r.interval).Format(time.TimeOnly), end.Format(time.TimeOnly))
+
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+
+	fmt.Fprintf(table, "%v\tcount\ttimeouts\trefused\tmin (ms)\tmean\tp50\tp90\tp99\tmax\t\n", r.groupBy)
+
+	for _, row := range rows {
+		fmt.Fprintf(table, "%v\t%d\t%d\t%d\t%v\t%v\t%v\t%v\t%v\t%v\t\n",
+			row.Key,
+			row.Summary.Count,
+			row.Timeouts,
+			row.Refused,
+			millis(row.Summary.Min),
+			millis(row.Summary.Mean),
+			millis(row.Summary.P50),
+			millis(row.Summary.P90),
+			millis(row.Summary.P99),
+			millis(row.Summary.Max),
+		)
+	}
+
+	return table.Flush()
+}
+
+// Run prints a table every interval until ctx is cancelled
+func (r *Reporter) Run(ctx context.Context, w io.Writer) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := r.Write(w, now, r.Rotate()); err != nil {
+				log.Printf("Failed writing interval report: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package report
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pouriyajamshidi/flat/internal/geoip"
+	"github.com/pouriyajamshidi/flat/internal/packet"
+	"github.com/stretchr/testify/require"
+)
+
+var request = packet.Packet{
+	SrcIP:    netip.MustParseAddr("::ffff:192.168.0.156"),
+	DstIP:    netip.MustParseAddr("::ffff:1.1.1.1"),
+	SrcPort:  53264,
+	DstPort:  443,
+	Protocol: 6,
+	DstHost:  "one.one.one.one",
+	DstGeo:   geoip.Location{Country: "AU", ASN: 13335, Org: "CLOUDFLARENET"},
+}
+
+func TestKeyFunc(t *testing.T) {
+	expected := map[string]string{
+		"destination": "(TCP) 1.1.1.1:443",
+		"flow":        "(TCP) 192.168.0.156:53264 -> 1.1.1.1:443",
+		"host":        "one.one.one.one",
+		"pod":         "-",
+		"country":     "AU",
+		"asn":         "AS13335 CLOUDFLARENET",
+	}
+
+	for groupBy, key := range expected {
+		keyFunc, err := KeyFunc(groupBy)
+		require.NoError(t, err)
+		require.Equal(t, key, keyFunc(request), groupBy)
+	}
+
+	_, err := KeyFunc("port")
+	require.Error(t, err)
+}
+
+func TestRotate(t *testing.T) {
+	reporter := New("destination", time.Second*10)
+
+	for i := 1; i <= 10; i++ {
+		reporter.Record("(TCP) 1.1.1.1:443", time.Duration(i)*time.Millisecond)
+	}
+
+	reporter.Timeout("(UDP) 10.0.0.1:53")
+	reporter.Refused("(TCP) 10.0.0.2:5432")
+	reporter.Refused("(TCP) 10.0.0.2:5432")
+
+	rows := reporter.Rotate()
+	require.Len(t, rows, 3)
+
+	require.Equal(t, "(TCP) 1.1.1.1:443", rows[0].Key)
+	require.Equal(t, uint64(10), rows[0].Summary.Count)
+	require.Equal(t, time.Millisecond*10, rows[0].Summary.Max)
+
+	require.Equal(t, "(TCP) 10.0.0.2:5432", rows[1].Key)
+	require.Equal(t, uint64(2), rows[1].Refused)
+
+	require.Equal(t, uint64(1), rows[2].Timeouts)
+
+	var out strings.Builder
+	require.NoError(t, reporter.Write(&out, time.Now(), rows))
+	require.Contains(t, out.String(), "destination")
+	require.Contains(t, out.String(), "(TCP) 10.0.0.2:5432")
+
+	// The next interval starts empty
+	require.Empty(t, reporter.Rotate())
+}
@@ -0,0 +1,68 @@
+package reset
+
+import (
+	"context"
+	"net/netip"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/pouriyajamshidi/flat/internal/capture"
+	"golang.org/x/sys/unix"
+)
+
+// tcpRST is a classic BPF program matching "tcp[tcpflags] & tcp-rst != 0"
+// for both IPv4 and IPv6 Ethernet frames
+var tcpRST = []unix.SockFilter{
+	{Code: unix.BPF_LD | unix.BPF_H | unix.BPF_ABS, K: 12},                     // 0: ethertype
+	{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, Jt: 0, Jf: 4, K: 0x86dd},  // 1: IPv6?
+	{Code: unix.BPF_LD | unix.BPF_B | unix.BPF_ABS, K: 20},                     // 2: IPv6 next header
+	{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, Jt: 0, Jf: 11, K: 6},      // 3: TCP?
+	{Code: unix.BPF_LD | unix.BPF_B | unix.BPF_ABS, K: 67},                     // 4: TCP flags
+	{Code: unix.BPF_JMP | unix.BPF_JSET | unix.BPF_K, Jt: 8, Jf: 9, K: 0x04},   // 5: RST?
+	{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, Jt: 0, Jf: 8, K: 0x0800},  // 6: IPv4?
+	{Code: unix.BPF_LD | unix.BPF_B | unix.BPF_ABS, K: 23},                     // 7: IPv4 protocol
+	{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, Jt: 0, Jf: 6, K: 6},       // 8: TCP?
+	{Code: unix.BPF_LD | unix.BPF_H | unix.BPF_ABS, K: 20},                     // 9: fragment offset
+	{Code: unix.BPF_JMP | unix.BPF_JSET | unix.BPF_K, Jt: 4, Jf: 0, K: 0x1fff}, // 10: fragmented?
+	{Code: unix.BPF_LDX | unix.BPF_B | unix.BPF_MSH, K: 14},                    // 11: IPv4 header length
+	{Code: unix.BPF_LD | unix.BPF_B | unix.BPF_IND, K: 27},                     // 12: TCP flags
+	{Code: unix.BPF_JMP | unix.BPF_JSET | unix.BPF_K, Jt: 0, Jf: 1, K: 0x04},   // 13: RST?
+	{Code: unix.BPF_RET | unix.BPF_K, K: capture.SnapLen},                      // 14: accept
+	{Code: unix.BPF_RET | unix.BPF_K, K: 0},                                    // 15: drop
+}
+
+// Parse extracts the endpoints of a TCP RST segment from an Ethernet frame
+func Parse(frame []byte) (src, dst netip.AddrPort, ok bool) {
+	pkt := gopacket.NewPacket(frame, layers.LayerTypeEthernet, gopacket.DecodeOptions{Lazy: true, NoCopy: true})
+
+	tcp, ok := pkt.Layer(layers.LayerTypeTCP).(*layers.TCP)
+
+	if !ok || !tcp.RST {
+		return netip.AddrPort{}, netip.AddrPort{}, false
+	}
+
+	var srcIP, dstIP netip.Addr
+
+	switch network := pkt.NetworkLayer().(type) {
+	case *layers.IPv4:
+		srcIP, _ = netip.AddrFromSlice(network.SrcIP.To4())
+		dstIP, _ = netip.AddrFromSlice(network.DstIP.To4())
+	case *layers.IPv6:
+		srcIP, _ = netip.AddrFromSlice(network.SrcIP)
+		dstIP, _ = netip.AddrFromSlice(network.DstIP)
+	default:
+		return netip.AddrPort{}, netip.AddrPort{}, false
+	}
+
+	return netip.AddrPortFrom(srcIP, uint16(tcp.SrcPort)), netip.AddrPortFrom(dstIP, uint16(tcp.DstPort)), true
+}
+
+// Watch reports the endpoints of every TCP RST seen on an interface
+// until ctx is cancelled. A RST answering a pending SYN is a refused connection
+func Watch(ctx context.Context, ifaceIndex int, handle func(src, dst netip.AddrPort)) error {
+	return capture.Capture(ctx, ifaceIndex, tcpRST, func(frame []byte) {
+		if src, dst, ok := Parse(frame); ok {
+			handle(src, dst)
+		}
+	})
+}
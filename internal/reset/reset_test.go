@@ -0,0 +1,43 @@
+package reset
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/require"
+)
+
+func tcpFrame(t *testing.T, tcp *layers.TCP) []byte {
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 5},
+		DstMAC:       net.HardwareAddr{5, 4, 3, 2, 1, 0},
+		EthernetType: layers.EthernetTypeIPv6,
+	}
+	ip := &layers.IPv6{
+		Version:    6,
+		HopLimit:   64,
+		SrcIP:      net.ParseIP("fd00::2"),
+		DstIP:      net.ParseIP("fd00::1"),
+		NextHeader: layers.IPProtocolTCP,
+	}
+	require.NoError(t, tcp.SetNetworkLayerForChecksum(ip))
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	require.NoError(t, gopacket.SerializeLayers(buf, opts, eth, ip, tcp))
+
+	return buf.Bytes()
+}
+
+func TestParse(t *testing.T) {
+	src, dst, ok := Parse(tcpFrame(t, &layers.TCP{SrcPort: 5432, DstPort: 40000, RST: true, ACK: true}))
+	require.True(t, ok)
+	require.Equal(t, netip.MustParseAddrPort("[fd00::2]:5432"), src)
+	require.Equal(t, netip.MustParseAddrPort("[fd00::1]:40000"), dst)
+
+	_, _, ok = Parse(tcpFrame(t, &layers.TCP{SrcPort: 5432, DstPort: 40000, SYN: true, ACK: true}))
+	require.False(t, ok)
+}
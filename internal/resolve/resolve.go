@@ -0,0 +1,164 @@
+package resolve
+
+import (
+	"container/list"
+	"context"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	lookupTimeout = time.Second * 2
+	negativeTTL   = time.Minute
+	queueSize     = 1024
+	maxEntries    = 65536
+)
+
+type entry struct {
+	ip      netip.Addr
+	name    string
+	expires time.Time
+	pending bool
+}
+
+// Resolver performs reverse DNS lookups in the background
+// and caches the resulting hostnames for a configurable TTL.
+// The least recently used address is evicted when the cache is full
+type Resolver struct {
+	resolver   *net.Resolver
+	workers    int
+	ttl        time.Duration
+	maxEntries int
+	mu         sync.Mutex
+	cache      map[netip.Addr]*list.Element
+	lru        *list.List // of *entry, most recently used first
+	queue      chan netip.Addr
+}
+
+// NewResolver constructs a new Resolver. If server is not empty,
+// PTR queries are sent to it instead of the system resolvers
+func NewResolver(server string, workers int, ttl time.Duration) *Resolver {
+	resolver := net.DefaultResolver
+
+	if server != "" {
+		resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, network, server)
+			},
+		}
+	}
+
+	return &Resolver{
+		resolver:   resolver,
+		workers:    workers,
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		cache:      make(map[netip.Addr]*list.Element),
+		lru:        list.New(),
+		queue:      make(chan netip.Addr, queueSize),
+	}
+}
+
+// Run starts the lookup workers and blocks until ctx is cancelled
+func (r *Resolver) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+
+	for range r.workers {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case ip := <-r.queue:
+					r.resolve(ctx, ip)
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+// Name returns the cached hostname of an IP address without blocking.
+// Unknown or expired addresses are queued for resolution
+func (r *Resolver) Name(ip netip.Addr) (string, bool) {
+	ip = ip.Unmap()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var cached *entry
+
+	if element, ok := r.cache[ip]; ok {
+		r.lru.MoveToFront(element)
+		cached = element.Value.(*entry)
+
+		if cached.pending || time.Now().Before(cached.expires) {
+			return cached.name, cached.name != ""
+		}
+	} else {
+		cached = r.insert(ip)
+	}
+
+	select {
+	case r.queue <- ip:
+		cached.pending = true
+	default:
+		// Queue is full, try again on the next packet
+	}
+
+	// Serve the stale name, if any, until the lookup completes
+	return cached.name, cached.name != ""
+}
+
+func (r *Resolver) resolve(ctx context.Context, ip netip.Addr) {
+	ctx, cancel := context.WithTimeout(ctx, lookupTimeout)
+	defer cancel()
+
+	var name string
+
+	ttl := negativeTTL
+
+	names, err := r.resolver.LookupAddr(ctx, ip.String())
+
+	if err == nil && len(names) > 0 {
+		name = strings.TrimSuffix(names[0], ".")
+		ttl = r.ttl
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cached := r.insert(ip)
+	cached.name = name
+	cached.expires = time.Now().Add(ttl)
+	cached.pending = false
+}
+
+// insert returns the entry of an address, adding it and evicting the least
+// recently used one if it is not cached. Callers must hold the lock
+func (r *Resolver) insert(ip netip.Addr) *entry {
+	if element, ok := r.cache[ip]; ok {
+		return element.Value.(*entry)
+	}
+
+	if r.lru.Len() >= r.maxEntries {
+		oldest := r.lru.Back()
+		r.lru.Remove(oldest)
+		delete(r.cache, oldest.Value.(*entry).ip)
+	}
+
+	cached := &entry{ip: ip}
+	r.cache[ip] = r.lru.PushFront(cached)
+
+	return cached
+}
@@ -0,0 +1,153 @@
+package resolve
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/require"
+)
+
+// stubServer answers PTR queries for 1.1.1.1 and NXDOMAIN for everything else
+func stubServer(t *testing.T, queries *atomic.Int32) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 1500)
+
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			var query layers.DNS
+			if err := query.DecodeFromBytes(buf[:n], gopacket.NilDecodeFeedback); err != nil || len(query.Questions) == 0 {
+				continue
+			}
+
+			queries.Add(1)
+
+			reply := layers.DNS{
+				ID:           query.ID,
+				QR:           true,
+				OpCode:       layers.DNSOpCodeQuery,
+				RD:           query.RD,
+				RA:           true,
+				Questions:    query.Questions,
+				ResponseCode: layers.DNSResponseCodeNXDomain,
+			}
+
+			if string(query.Questions[0].Name) == "1.1.1.1.in-addr.arpa" {
+				reply.ResponseCode = layers.DNSResponseCodeNoErr
+				reply.Answers = []layers.DNSResourceRecord{{
+					Name:  query.Questions[0].Name,
+					Type:  layers.DNSTypePTR,
+					Class: layers.DNSClassIN,
+					TTL:   300,
+					PTR:   []byte("one.one.one.one"),
+				}}
+			}
+
+			out := gopacket.NewSerializeBuffer()
+			if err := reply.SerializeTo(out, gopacket.SerializeOptions{FixLengths: true}); err != nil {
+				continue
+			}
+
+			conn.WriteTo(out.Bytes(), addr)
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+func TestResolverName(t *testing.T) {
+	var queries atomic.Int32
+
+	resolver := NewResolver(stubServer(t, &queries), 2, time.Minute)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go resolver.Run(ctx)
+
+	ip := netip.MustParseAddr("::ffff:1.1.1.1")
+
+	// The first call must never block on the lookup
+	_, ok := resolver.Name(ip)
+	require.False(t, ok)
+
+	require.Eventually(t, func() bool {
+		name, ok := resolver.Name(ip)
+		return ok && name == "one.one.one.one"
+	}, time.Second*5, time.Millisecond*10)
+
+	seen := queries.Load()
+
+	// Cached names do not trigger new queries
+	_, ok = resolver.Name(ip)
+	require.True(t, ok)
+	require.Equal(t, seen, queries.Load())
+}
+
+func TestResolverNegativeCache(t *testing.T) {
+	var queries atomic.Int32
+
+	resolver := NewResolver(stubServer(t, &queries), 1, time.Minute)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go resolver.Run(ctx)
+
+	ip := netip.MustParseAddr("2.2.2.2")
+
+	resolver.Name(ip)
+
+	require.Eventually(t, func() bool {
+		resolver.mu.Lock()
+		defer resolver.mu.Unlock()
+
+		element, ok := resolver.cache[ip]
+		if !ok {
+			return false
+		}
+
+		cached := element.Value.(*entry)
+		return !cached.pending && !cached.expires.IsZero()
+	}, time.Second*5, time.Millisecond*10)
+
+	seen := queries.Load()
+
+	_, ok := resolver.Name(ip)
+	require.False(t, ok)
+	require.Equal(t, seen, queries.Load())
+}
+
+func TestResolverEviction(t *testing.T) {
+	resolver := NewResolver("", 1, time.Minute)
+	resolver.maxEntries = 2
+
+	first := netip.MustParseAddr("10.0.0.1")
+	second := netip.MustParseAddr("10.0.0.2")
+	third := netip.MustParseAddr("10.0.0.3")
+
+	resolver.Name(first)
+	resolver.Name(second)
+
+	// Using the first address makes the second the least recently used
+	resolver.Name(first)
+	resolver.Name(third)
+
+	require.Equal(t, 2, resolver.lru.Len())
+	require.Contains(t, resolver.cache, first)
+	require.Contains(t, resolver.cache, third)
+	require.NotContains(t, resolver.cache, second)
+}
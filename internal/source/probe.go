@@ -0,0 +1,177 @@
+package source
+
+import (
+	"log"
+
+	"github.com/pouriyajamshidi/flat/clsact"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+//go:generate go run github.com/cilium/ebpf/cmd/bpf2go probe ../../bpf/flat.c - -O2  -Wall -Werror -Wno-address-of-packed-member
+
+const tenMegaBytes = 1024 * 1024 * 10
+const twentyMegaBytes = tenMegaBytes * 2
+const fortyMegaBytes = twentyMegaBytes * 2
+
+type probe struct {
+	iface      netlink.Link
+	handle     *netlink.Handle
+	qdisc      *clsact.ClsAct
+	bpfObjects *probeObjects
+	filters    []*netlink.BpfFilter
+	logger     *log.Logger
+}
+
+func setRlimit(logger *log.Logger) error {
+	logger.Printf("Setting rlimit - soft: %v | hard: %v\n", twentyMegaBytes, fortyMegaBytes)
+
+	return unix.Setrlimit(unix.RLIMIT_MEMLOCK, &unix.Rlimit{
+		Cur: tenMegaBytes,
+		Max: twentyMegaBytes,
+	})
+}
+
+func (p *probe) loadObjects() error {
+	p.logger.Printf("Loading probe object into kernel")
+
+	objs := probeObjects{}
+
+	if err := loadProbeObjects(&objs, nil); err != nil {
+		return err
+	}
+
+	p.bpfObjects = &objs
+
+	return nil
+}
+
+func (p *probe) createQdisc() error {
+	p.logger.Printf("Creating clsact qdisc")
+
+	p.qdisc = clsact.NewClsAct(&netlink.QdiscAttrs{
+		LinkIndex: p.iface.Attrs().Index,
+		Handle:    netlink.MakeHandle(0xffff, 0),
+		Parent:    netlink.HANDLE_CLSACT,
+	})
+
+	if err := p.handle.QdiscAdd(p.qdisc); err != nil {
+		if err := p.handle.QdiscReplace(p.qdisc); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *probe) createFilters() error {
+	p.logger.Printf("Creating qdisc ingress/egress filters")
+
+	addFilter := func(attrs netlink.FilterAttrs) {
+		p.filters = append(p.filters, &netlink.BpfFilter{
+			FilterAttrs:  attrs,
+			Fd:           p.bpfObjects.probePrograms.Flat.FD(),
+			DirectAction: true,
+		})
+	}
+
+	addFilter(netlink.FilterAttrs{
+		LinkIndex: p.iface.Attrs().Index,
+		Handle:    netlink.MakeHandle(0xffff, 0),
+		Parent:    netlink.HANDLE_MIN_INGRESS,
+		Protocol:  unix.ETH_P_IP,
+	})
+
+	addFilter(netlink.FilterAttrs{
+		LinkIndex: p.iface.Attrs().Index,
+		Handle:    netlink.MakeHandle(0xffff, 0),
+		Parent:    netlink.HANDLE_MIN_EGRESS,
+		Protocol:  unix.ETH_P_IP,
+	})
+
+	addFilter(netlink.FilterAttrs{
+		LinkIndex: p.iface.Attrs().Index,
+		Handle:    netlink.MakeHandle(0xffff, 0),
+		Parent:    netlink.HANDLE_MIN_INGRESS,
+		Protocol:  unix.ETH_P_IPV6,
+	})
+
+	addFilter(netlink.FilterAttrs{
+		LinkIndex: p.iface.Attrs().Index,
+		Handle:    netlink.MakeHandle(0xffff, 0),
+		Parent:    netlink.HANDLE_MIN_EGRESS,
+		Protocol:  unix.ETH_P_IPV6,
+	})
+
+	for _, filter := range p.filters {
+		if err := p.handle.FilterAdd(filter); err != nil {
+			if err := p.handle.FilterReplace(filter); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func newProbe(iface netlink.Link, logger *log.Logger) (*probe, error) {
+	logger.Println("Creating a new probe")
+
+	handle, err := netlink.NewHandle(unix.NETLINK_ROUTE)
+
+	if err != nil {
+		logger.Printf("Failed getting netlink handle: %v", err)
+		return nil, err
+	}
+
+	prbe := probe{
+		iface:  iface,
+		handle: handle,
+		logger: logger,
+	}
+
+	if err := prbe.loadObjects(); err != nil {
+		logger.Printf("Failed loading probe objects: %v", err)
+		return nil, err
+	}
+
+	if err := prbe.createQdisc(); err != nil {
+		logger.Printf("Failed creating qdisc: %v", err)
+		return nil, err
+	}
+
+	if err := prbe.createFilters(); err != nil {
+		logger.Printf("Failed creating qdisc filters: %v", err)
+		return nil, err
+	}
+
+	return &prbe, nil
+}
+
+func (p *probe) Close() error {
+	p.logger.Println("Removing qdisc")
+	if err := p.handle.QdiscDel(p.qdisc); err != nil {
+		p.logger.Println("Failed deleting qdisc")
+		return err
+	}
+
+	// log.Println("Removing qdisc filters")
+
+	// for _, filter := range p.filters {
+	// 	if err := p.handle.FilterDel(filter); err != nil {
+	// 		log.Println("Failed deleting qdisc filters")
+	// 		return err
+	// 	}
+	// }
+
+	p.logger.Println("Deleting handle")
+	p.handle.Delete()
+
+	p.logger.Println("Closing eBPF object")
+	if err := p.bpfObjects.Close(); err != nil {
+		p.logger.Println("Failed closing eBPF object")
+		return err
+	}
+
+	return nil
+}
@@ -1,7 +1,7 @@
 // Code generated by bpf2go; DO NOT EDIT.
 //go:build 386 || amd64 || arm || arm64 || loong64 || mips64le || mipsle || ppc64le || riscv64 || wasm
 
-package probe
+package source
 
 import (
 	"bytes"
@@ -61,7 +61,8 @@ type probeProgramSpecs struct {
 //
 // It can be passed ebpf.CollectionSpec.Assign.
 type probeMapSpecs struct {
-	Pipe *ebpf.MapSpec `ebpf:"pipe"`
+	Drops *ebpf.MapSpec `ebpf:"drops"`
+	Pipe  *ebpf.MapSpec `ebpf:"pipe"`
 }
 
 // probeVariableSpecs contains global variables before they are loaded into the kernel.
@@ -90,11 +91,13 @@ func (o *probeObjects) Close() error {
 //
 // It can be passed to loadProbeObjects or ebpf.CollectionSpec.LoadAndAssign.
 type probeMaps struct {
-	Pipe *ebpf.Map `ebpf:"pipe"`
+	Drops *ebpf.Map `ebpf:"drops"`
+	Pipe  *ebpf.Map `ebpf:"pipe"`
 }
 
 func (m *probeMaps) Close() error {
 	return _ProbeClose(
+		m.Drops,
 		m.Pipe,
 	)
 }
@@ -0,0 +1,79 @@
+package source
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/cilium/ebpf/ringbuf"
+	"github.com/vishvananda/netlink"
+)
+
+// Source is the eBPF program attached to an interface along with the
+// ring buffer it sends a packet_t to for every TCP SYN and UDP datagram
+type Source struct {
+	probe  *probe
+	reader *ringbuf.Reader
+}
+
+// Attach loads the eBPF program and attaches it to the ingress and
+// egress of an interface, describing every step to logger
+func Attach(iface netlink.Link, logger *log.Logger) (*Source, error) {
+	if err := setRlimit(logger); err != nil {
+		logger.Printf("Failed setting rlimit: %v", err)
+		return nil, err
+	}
+
+	probe, err := newProbe(iface, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := ringbuf.NewReader(probe.bpfObjects.probeMaps.Pipe)
+	if err != nil {
+		probe.Close()
+		return nil, fmt.Errorf("opening ringbuf reader: %w", err)
+	}
+
+	return &Source{probe: probe, reader: reader}, nil
+}
+
+// Read blocks until the next raw packet_t. It returns
+// ringbuf.ErrClosed once the Source has been closed
+func (s *Source) Read() ([]byte, error) {
+	record, err := s.reader.Read()
+	return record.RawSample, err
+}
+
+// AvailableBytes returns the number of bytes waiting to be read
+func (s *Source) AvailableBytes() int {
+	return s.reader.AvailableBytes()
+}
+
+// BufferSize returns the size of the ring buffer
+func (s *Source) BufferSize() int {
+	return s.reader.BufferSize()
+}
+
+// Dropped returns the number of packets the eBPF program
+// dropped because the ring buffer was full
+func (s *Source) Dropped() (uint64, error) {
+	var perCPU []uint64
+
+	if err := s.probe.bpfObjects.probeMaps.Drops.Lookup(uint32(0), &perCPU); err != nil {
+		return 0, fmt.Errorf("looking up dropped packets: %w", err)
+	}
+
+	var dropped uint64
+
+	for _, count := range perCPU {
+		dropped += count
+	}
+
+	return dropped, nil
+}
+
+// Close stops reading and detaches the eBPF program
+func (s *Source) Close() error {
+	s.reader.Close()
+	return s.probe.Close()
+}
@@ -0,0 +1,71 @@
+package source
+
+import (
+	"log"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/pouriyajamshidi/flat/internal/packets"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTCPv4SYNPacket(t *testing.T) {
+	prbe := probe{logger: log.Default()}
+	err := prbe.loadObjects()
+	require.NoError(t, err)
+
+	in := packets.TCPv4SYN()
+	res, out, err := prbe.bpfObjects.Flat.Test(in)
+
+	require.NoError(t, err)
+	require.Equal(t, uint32(0), res)
+	require.Equal(t, in, out)
+}
+
+func TestTCPv4ACKPacket(t *testing.T) {
+	prbe := probe{logger: log.Default()}
+	err := prbe.loadObjects()
+	require.NoError(t, err)
+
+	in := packets.TCPv4ACK()
+	res, out, err := prbe.bpfObjects.Flat.Test(in)
+
+	require.NoError(t, err)
+	require.Equal(t, uint32(0), res)
+	require.Equal(t, in, out)
+}
+
+func TestTCPv4SYNACKPacket(t *testing.T) {
+	prbe := probe{logger: log.Default()}
+	err := prbe.loadObjects()
+	require.NoError(t, err)
+
+	in := packets.TCPv4SYNACK()
+	res, out, err := prbe.bpfObjects.Flat.Test(in)
+
+	require.NoError(t, err)
+	require.Equal(t, uint32(0), res)
+	require.Equal(t, in, out)
+}
+
+func TestRingbufDrops(t *testing.T) {
+	prbe := probe{logger: log.Default()}
+	err := prbe.loadObjects()
+	require.NoError(t, err)
+	defer prbe.bpfObjects.Close()
+
+	// The test packets are sent to a multicast MAC, which the program ignores
+	in := packets.TCPv4SYN()
+	in[0] &^= 1
+
+	// Nothing reads the ring buffer, so it fills up and the rest is dropped
+	_, err = prbe.bpfObjects.Flat.Run(&ebpf.RunOptions{Data: in, Repeat: 20_000})
+	require.NoError(t, err)
+
+	source := Source{probe: &prbe}
+
+	dropped, err := source.Dropped()
+	require.NoError(t, err)
+	require.Greater(t, dropped, uint64(5_000))
+	require.Less(t, dropped, uint64(20_000))
+}
@@ -0,0 +1,244 @@
+package stats
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+)
+
+// DefaultRelativeAccuracy bounds the relative error of reported quantiles
+const DefaultRelativeAccuracy = 0.01
+
+// Sketch is a streaming histogram with logarithmically sized buckets in the
+// style of DDSketch. Quantiles are accurate to within the relative accuracy
+// it was created with, and memory only grows with the range of the values
+type Sketch struct {
+	gamma    float64
+	logGamma float64
+	bins     map[int]uint64
+	zeros    uint64
+
+	count uint64
+	min   float64
+	max   float64
+	mean  float64
+	m2    float64 // sum of squared differences from the mean (Welford)
+}
+
+// NewSketch constructs a new Sketch with the given relative accuracy
+func NewSketch(relativeAccuracy float64) *Sketch {
+	gamma := (1 + relativeAccuracy) / (1 - relativeAccuracy)
+
+	return &Sketch{
+		gamma:    gamma,
+		logGamma: math.Log(gamma),
+		bins:     make(map[int]uint64),
+	}
+}
+
+func (sketch *Sketch) index(value float64) int {
+	return int(math.Ceil(math.Log(value) / sketch.logGamma))
+}
+
+func (sketch *Sketch) value(index int) float64 {
+	return 2 * math.Pow(sketch.gamma, float64(index)) / (sketch.gamma + 1)
+}
+
+// Add records a value
+func (sketch *Sketch) Add(value float64) {
+	if value <= 0 {
+		sketch.zeros++
+	} else {
+		sketch.bins[sketch.index(value)]++
+	}
+
+	if sketch.count == 0 || value < sketch.min {
+		sketch.min = value
+	}
+
+	if sketch.count == 0 || value > sketch.max {
+		sketch.max = value
+	}
+
+	sketch.count++
+
+	delta := value - sketch.mean
+	sketch.mean += delta / float64(sketch.count)
+	sketch.m2 += delta * (value - sketch.mean)
+}
+
+// Merge adds all values recorded in other, which must have the same accuracy
+func (sketch *Sketch) Merge(other *Sketch) {
+	if other.count == 0 {
+		return
+	}
+
+	for index, count := range other.bins {
+		sketch.bins[index] += count
+	}
+
+	sketch.zeros += other.zeros
+
+	if sketch.count == 0 || other.min < sketch.min {
+		sketch.min = other.min
+	}
+
+	if sketch.count == 0 || other.max > sketch.max {
+		sketch.max = other.max
+	}
+
+	// Chan et al. parallel variance
+	total := sketch.count + other.count
+	delta := other.mean - sketch.mean
+
+	sketch.m2 += other.m2 + delta*delta*float64(sketch.count)*float64(other.count)/float64(total)
+	sketch.mean += delta * float64(other.count) / float64(total)
+	sketch.count = total
+}
+
+// Quantile returns the value at quantile q, which must be between 0 and 1
+func (sketch *Sketch) Quantile(q float64) float64 {
+	if sketch.count == 0 {
+		return 0
+	}
+
+	rank := uint64(q * float64(sketch.count-1))
+
+	if rank < sketch.zeros {
+		return 0
+	}
+
+	indexes := make([]int, 0, len(sketch.bins))
+
+	for index := range sketch.bins {
+		indexes = append(indexes, index)
+	}
+
+	slices.Sort(indexes)
+
+	seen := sketch.zeros
+
+	for _, index := range indexes {
+		seen += sketch.bins[index]
+
+		if seen > rank {
+			return math.Min(math.Max(sketch.value(index), sketch.min), sketch.max)
+		}
+	}
+
+	return sketch.max
+}
+
+// Count returns the number of recorded values
+func (sketch *Sketch) Count() uint64 {
+	return sketch.count
+}
+
+// Min returns the smallest recorded value
+func (sketch *Sketch) Min() float64 {
+	return sketch.min
+}
+
+// Max returns the largest recorded value
+func (sketch *Sketch) Max() float64 {
+	return sketch.max
+}
+
+// Mean returns the arithmetic mean of the recorded values
+func (sketch *Sketch) Mean() float64 {
+	return sketch.mean
+}
+
+// StdDev returns the population standard deviation of the recorded values
+func (sketch *Sketch) StdDev() float64 {
+	if sketch.count == 0 {
+		return 0
+	}
+	return math.Sqrt(sketch.m2 / float64(sketch.count))
+}
+
+// sketchEncoding is bumped whenever the layout of MarshalBinary changes
+const sketchEncoding = 1
+
+// MarshalBinary encodes the sketch so that it can be stored and merged later
+func (sketch *Sketch) MarshalBinary() ([]byte, error) {
+	b := []byte{sketchEncoding}
+
+	for _, f := range []float64{sketch.gamma, sketch.min, sketch.max, sketch.mean, sketch.m2} {
+		b = binary.BigEndian.AppendUint64(b, math.Float64bits(f))
+	}
+
+	b = binary.AppendUvarint(b, sketch.count)
+	b = binary.AppendUvarint(b, sketch.zeros)
+	b = binary.AppendUvarint(b, uint64(len(sketch.bins)))
+
+	for index, count := range sketch.bins {
+		b = binary.AppendVarint(b, int64(index))
+		b = binary.AppendUvarint(b, count)
+	}
+
+	return b, nil
+}
+
+// UnmarshalBinary decodes a sketch encoded by MarshalBinary
+func (sketch *Sketch) UnmarshalBinary(b []byte) error {
+	if len(b) < 1+5*8 || b[0] != sketchEncoding {
+		return errors.New("unknown sketch encoding")
+	}
+
+	var floats [5]float64
+
+	for i := range floats {
+		floats[i] = math.Float64frombits(binary.BigEndian.Uint64(b[1+i*8:]))
+	}
+
+	r := bytes.NewReader(b[1+5*8:])
+
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return fmt.Errorf("decoding count: %w", err)
+	}
+
+	zeros, err := binary.ReadUvarint(r)
+	if err != nil {
+		return fmt.Errorf("decoding zeros: %w", err)
+	}
+
+	n, err := binary.ReadUvarint(r)
+	if err != nil || n > uint64(r.Len()) {
+		return errors.New("decoding bins: truncated")
+	}
+
+	bins := make(map[int]uint64, n)
+
+	for range n {
+		index, err := binary.ReadVarint(r)
+		if err != nil {
+			return fmt.Errorf("decoding bins: %w", err)
+		}
+
+		binCount, err := binary.ReadUvarint(r)
+		if err != nil {
+			return fmt.Errorf("decoding bins: %w", err)
+		}
+
+		bins[int(index)] = binCount
+	}
+
+	*sketch = Sketch{
+		gamma:    floats[0],
+		logGamma: math.Log(floats[0]),
+		bins:     bins,
+		zeros:    zeros,
+		count:    count,
+		min:      floats[1],
+		max:      floats[2],
+		mean:     floats[3],
+		m2:       floats[4],
+	}
+
+	return nil
+}
@@ -0,0 +1,322 @@
+package stats
+
+import (
+	"cmp"
+	"container/list"
+	"fmt"
+	"io"
+	"net/netip"
+	"slices"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Destination identifies the remote end of a measured flow
+type Destination struct {
+	IP       netip.Addr
+	Port     uint16
+	Protocol uint8
+}
+
+// String formats the destination as ip:port
+func (dest Destination) String() string {
+	return netip.AddrPortFrom(dest.IP.Unmap(), dest.Port).String()
+}
+
+// Summary holds the aggregated latency statistics of a destination
+type Summary struct {
+	Count  uint64
+	Min    time.Duration
+	Max    time.Duration
+	Mean   time.Duration
+	StdDev time.Duration
+	P50    time.Duration
+	P90    time.Duration
+	P99    time.Duration
+	P999   time.Duration
+}
+
+// Summarize computes the Summary of a Sketch holding nanosecond values
+func Summarize(sketch *Sketch) Summary {
+	return Summary{
+		Count:  sketch.Count(),
+		Min:    time.Duration(sketch.Min()),
+		Max:    time.Duration(sketch.Max()),
+		Mean:   time.Duration(sketch.Mean()),
+		StdDev: time.Duration(sketch.StdDev()),
+		P50:    time.Duration(sketch.Quantile(0.50)),
+		P90:    time.Duration(sketch.Quantile(0.90)),
+		P99:    time.Duration(sketch.Quantile(0.99)),
+		P999:   time.Duration(sketch.Quantile(0.999)),
+	}
+}
+
+// Entry pairs an aggregation key with its Summary
+type Entry[K comparable] struct {
+	Key     K
+	Summary Summary
+}
+
+// Config holds how much a Stats keeps
+type Config struct {
+	// Window is how far back the recent statistics go
+	Window time.Duration
+	// Slots is the number of sub-sketches the window rotates through.
+	// The recent statistics cover the window to within one slot
+	Slots int
+	// MaxKeys bounds the number of keys, the least recently
+	// recorded one is evicted once it is reached
+	MaxKeys int
+}
+
+// DefaultConfig returns the default Stats configuration
+func DefaultConfig() Config {
+	return Config{
+		Window:  time.Minute,
+		Slots:   6,
+		MaxKeys: 4096,
+	}
+}
+
+// series holds the samples of a key, since it was first
+// recorded and in the slots of the recent window
+type series[K comparable] struct {
+	key     K
+	total   *Sketch
+	slots   []*Sketch
+	periods []int64 // of the samples in each slot
+}
+
+// Stats aggregates latency samples per key into streaming histograms,
+// both since start and over a rotating window of recent samples
+type Stats[K comparable] struct {
+	config Config
+	width  int64 // of a slot in nanoseconds
+	now    func() time.Time
+
+	mu        sync.Mutex
+	series    map[K]*list.Element
+	lru       *list.List // of *series, most recently recorded first
+	evictions uint64
+}
+
+// New constructs a new Stats
+func New[K comparable](config Config) *Stats[K] {
+	defaults := DefaultConfig()
+
+	if config.Window <= 0 {
+		config.Window = defaults.Window
+	}
+
+	if config.Slots < 1 {
+		config.Slots = defaults.Slots
+	}
+
+	if config.MaxKeys < 1 {
+		config.MaxKeys = defaults.MaxKeys
+	}
+
+	return &Stats[K]{
+		config: config,
+		width:  max(int64(config.Window)/int64(config.Slots), 1),
+		now:    time.Now,
+		series: make(map[K]*list.Element),
+		lru:    list.New(),
+	}
+}
+
+// period returns the slot period of the current time
+func (s *Stats[K]) period() int64 {
+	return s.now().UnixNano() / s.width
+}
+
+// Record adds a latency sample for key
+func (s *Stats[K]) Record(key K, latency time.Duration) {
+	period := s.period()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var samples *series[K]
+
+	if element, ok := s.series[key]; ok {
+		s.lru.MoveToFront(element)
+		samples = element.Value.(*series[K])
+	} else {
+		if s.lru.Len() >= s.config.MaxKeys {
+			oldest := s.lru.Back()
+			s.lru.Remove(oldest)
+			delete(s.series, oldest.Value.(*series[K]).key)
+			s.evictions++
+		}
+
+		samples = &series[K]{
+			key:     key,
+			total:   NewSketch(DefaultRelativeAccuracy),
+			slots:   make([]*Sketch, s.config.Slots),
+			periods: make([]int64, s.config.Slots),
+		}
+
+		s.series[key] = s.lru.PushFront(samples)
+	}
+
+	samples.total.Add(float64(latency))
+
+	slot := period % int64(s.config.Slots)
+
+	if samples.slots[slot] == nil || samples.periods[slot] != period {
+		samples.slots[slot] = NewSketch(DefaultRelativeAccuracy)
+		samples.periods[slot] = period
+	}
+
+	samples.slots[slot].Add(float64(latency))
+}
+
+// recent merges the slots of a series that are within the window
+func (s *Stats[K]) recent(samples *series[K], period int64) *Sketch {
+	sketch := NewSketch(DefaultRelativeAccuracy)
+
+	for i, slot := range samples.slots {
+		if slot != nil && period-samples.periods[i] < int64(s.config.Slots) {
+			sketch.Merge(slot)
+		}
+	}
+
+	return sketch
+}
+
+// Summary returns the statistics of a single key since it was first recorded
+func (s *Stats[K]) Summary(key K) (Summary, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	element, ok := s.series[key]
+
+	if !ok {
+		return Summary{}, false
+	}
+
+	return Summarize(element.Value.(*series[K]).total), true
+}
+
+// RecentSummary returns the statistics of a single key over the window
+func (s *Stats[K]) RecentSummary(key K) (Summary, bool) {
+	period := s.period()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	element, ok := s.series[key]
+
+	if !ok {
+		return Summary{}, false
+	}
+
+	summary := Summarize(s.recent(element.Value.(*series[K]), period))
+
+	return summary, summary.Count > 0
+}
+
+// Entries returns the statistics of every key since it was first recorded, busiest first
+func (s *Stats[K]) Entries() []Entry[K] {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := make([]Entry[K], 0, len(s.series))
+
+	for key, element := range s.series {
+		entries = append(entries, Entry[K]{Key: key, Summary: Summarize(element.Value.(*series[K]).total)})
+	}
+
+	return sortEntries(entries)
+}
+
+// Recent returns the statistics over the window of every key
+// that has samples in it, busiest first
+func (s *Stats[K]) Recent() []Entry[K] {
+	period := s.period()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var entries []Entry[K]
+
+	for key, element := range s.series {
+		summary := Summarize(s.recent(element.Value.(*series[K]), period))
+
+		if summary.Count > 0 {
+			entries = append(entries, Entry[K]{Key: key, Summary: summary})
+		}
+	}
+
+	return sortEntries(entries)
+}
+
+func sortEntries[K comparable](entries []Entry[K]) []Entry[K] {
+	slices.SortStableFunc(entries, func(a, b Entry[K]) int {
+		return cmp.Compare(b.Summary.Count, a.Summary.Count)
+	})
+
+	return entries
+}
+
+// Window returns how far back the recent statistics go
+func (s *Stats[K]) Window() time.Duration {
+	return s.config.Window
+}
+
+// Len returns the number of keys with samples
+func (s *Stats[K]) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.series)
+}
+
+// Evictions returns how many keys were evicted to stay within MaxKeys
+func (s *Stats[K]) Evictions() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.evictions
+}
+
+// Reset discards all samples
+func (s *Stats[K]) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.series = make(map[K]*list.Element)
+	s.lru.Init()
+}
+
+func millis(d time.Duration) string {
+	return fmt.Sprintf("%.3f", float64(d)/float64(time.Millisecond))
+}
+
+// WriteTable writes entries as an aligned table with latencies in milliseconds
+func WriteTable[K comparable](w io.Writer, entries []Entry[K], name func(K) string) error {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+
+	fmt.Fprintln(table, "destination\tcount\tmin\tmean\tstddev\tp50\tp90\tp99\tp999\tmax\t")
+
+	for _, entry := range entries {
+		summary := entry.Summary
+
+		fmt.Fprintf(table, "%v\t%d\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t\n",
+			name(entry.Key),
+			summary.Count,
+			millis(summary.Min),
+			millis(summary.Mean),
+			millis(summary.StdDev),
+			millis(summary.P50),
+			millis(summary.P90),
+			millis(summary.P99),
+			millis(summary.P999),
+			millis(summary.Max),
+		)
+	}
+
+	return table.Flush()
+}
@@ -0,0 +1,174 @@
+package stats
+
+import (
+	"math"
+	"math/rand"
+	"net/netip"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSketchQuantileAccuracy(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	sketch := NewSketch(DefaultRelativeAccuracy)
+
+	values := make([]float64, 100_000)
+
+	for i := range values {
+		// Log-normal latencies around 1ms
+		values[i] = math.Exp(rng.NormFloat64()) * float64(time.Millisecond)
+		sketch.Add(values[i])
+	}
+
+	slices.Sort(values)
+
+	for _, q := range []float64{0.5, 0.9, 0.99, 0.999} {
+		exact := values[int(q*float64(len(values)-1))]
+		require.InEpsilon(t, exact, sketch.Quantile(q), DefaultRelativeAccuracy*1.01, "quantile %v", q)
+	}
+
+	require.Equal(t, values[0], sketch.Min())
+	require.Equal(t, values[len(values)-1], sketch.Max())
+	require.Equal(t, uint64(len(values)), sketch.Count())
+}
+
+func TestSketchMeanStdDevMerge(t *testing.T) {
+	first := NewSketch(DefaultRelativeAccuracy)
+	second := NewSketch(DefaultRelativeAccuracy)
+
+	for _, value := range []float64{2, 4, 4, 4} {
+		first.Add(value)
+	}
+
+	for _, value := range []float64{5, 5, 7, 9} {
+		second.Add(value)
+	}
+
+	first.Merge(second)
+
+	require.Equal(t, uint64(8), first.Count())
+	require.InDelta(t, 5, first.Mean(), 1e-9)
+	require.InDelta(t, 2, first.StdDev(), 1e-9)
+	require.Equal(t, 2.0, first.Min())
+	require.Equal(t, 9.0, first.Max())
+}
+
+func TestSketchMarshalBinary(t *testing.T) {
+	sketch := NewSketch(DefaultRelativeAccuracy)
+
+	for _, value := range []float64{0, 1500, 2000, 2000, 90000} {
+		sketch.Add(value)
+	}
+
+	b, err := sketch.MarshalBinary()
+	require.NoError(t, err)
+
+	var decoded Sketch
+	require.NoError(t, decoded.UnmarshalBinary(b))
+	require.Equal(t, sketch, &decoded)
+
+	// A decoded sketch keeps recording and merging
+	decoded.Add(3000)
+	decoded.Merge(sketch)
+	require.Equal(t, uint64(11), decoded.Count())
+
+	require.Error(t, decoded.UnmarshalBinary(b[:10]))
+	require.Error(t, decoded.UnmarshalBinary(append([]byte{9}, b[1:]...)))
+}
+
+func TestStatsPerDestination(t *testing.T) {
+	statistics := New[Destination](DefaultConfig())
+
+	db := Destination{IP: netip.MustParseAddr("10.0.0.5"), Port: 5432, Protocol: 6}
+	dns := Destination{IP: netip.MustParseAddr("1.1.1.1"), Port: 53, Protocol: 17}
+
+	for i := 1; i <= 100; i++ {
+		statistics.Record(db, time.Duration(i)*time.Millisecond)
+	}
+
+	statistics.Record(dns, time.Millisecond*20)
+
+	summary, ok := statistics.Summary(db)
+	require.True(t, ok)
+	require.Equal(t, uint64(100), summary.Count)
+	require.Equal(t, time.Millisecond, summary.Min)
+	require.Equal(t, time.Millisecond*100, summary.Max)
+	require.InEpsilon(t, float64(time.Millisecond*50), float64(summary.P50), 0.03)
+	require.InEpsilon(t, float64(time.Millisecond*99), float64(summary.P99), 0.03)
+
+	entries := statistics.Entries()
+	require.Len(t, entries, 2)
+	require.Equal(t, db, entries[0].Key)
+
+	var out strings.Builder
+	require.NoError(t, WriteTable(&out, entries, Destination.String))
+	require.Contains(t, out.String(), "10.0.0.5:5432")
+	require.Contains(t, out.String(), "1.1.1.1:53")
+
+	statistics.Reset()
+	require.Equal(t, 0, statistics.Len())
+}
+
+func TestStatsWindow(t *testing.T) {
+	statistics := New[Destination](Config{Window: time.Minute, Slots: 6, MaxKeys: 2})
+
+	now := time.Unix(1700000000, 0)
+	statistics.now = func() time.Time { return now }
+
+	db := Destination{IP: netip.MustParseAddr("10.0.0.5"), Port: 5432, Protocol: 6}
+
+	statistics.Record(db, time.Millisecond*100)
+
+	// A minute later the slow sample has left the window, but not the totals
+	now = now.Add(time.Minute)
+	statistics.Record(db, time.Millisecond)
+
+	recent, ok := statistics.RecentSummary(db)
+	require.True(t, ok)
+	require.Equal(t, uint64(1), recent.Count)
+	require.Equal(t, time.Millisecond, recent.Max)
+
+	total, ok := statistics.Summary(db)
+	require.True(t, ok)
+	require.Equal(t, uint64(2), total.Count)
+
+	// Samples within the window are kept as the slots rotate
+	now = now.Add(time.Second * 30)
+	statistics.Record(db, time.Millisecond*2)
+
+	recent, _ = statistics.RecentSummary(db)
+	require.Equal(t, uint64(2), recent.Count)
+
+	now = now.Add(time.Minute * 5)
+
+	_, ok = statistics.RecentSummary(db)
+	require.False(t, ok)
+	require.Empty(t, statistics.Recent())
+	require.Len(t, statistics.Entries(), 1)
+}
+
+func TestStatsMaxKeys(t *testing.T) {
+	statistics := New[Destination](Config{MaxKeys: 2})
+
+	first := Destination{IP: netip.MustParseAddr("10.0.0.1"), Port: 443, Protocol: 6}
+	second := Destination{IP: netip.MustParseAddr("10.0.0.2"), Port: 443, Protocol: 6}
+	third := Destination{IP: netip.MustParseAddr("10.0.0.3"), Port: 443, Protocol: 6}
+
+	statistics.Record(first, time.Millisecond)
+	statistics.Record(second, time.Millisecond)
+	statistics.Record(first, time.Millisecond)
+	statistics.Record(third, time.Millisecond)
+
+	require.Equal(t, 2, statistics.Len())
+	require.Equal(t, uint64(1), statistics.Evictions())
+
+	_, ok := statistics.Summary(second)
+	require.False(t, ok)
+
+	_, ok = statistics.Summary(first)
+	require.True(t, ok)
+}
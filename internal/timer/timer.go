@@ -1,21 +1,24 @@
 package timer
 
 import (
-	"log"
 	"time"
 
 	"golang.org/x/sys/unix"
 )
 
-// GetNanosecSinceBoot returns the nanoseconds since system boot time
+// GetNanosecSinceBoot returns the nanoseconds since system boot time,
+// or 0 in the unlikely event that the monotonic clock cannot be read
 func GetNanosecSinceBoot() uint64 {
 	var ts unix.Timespec
 
-	err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts)
-
-	if err != nil {
-		log.Println("Could not get MONOTONIC Clock time ", err)
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts); err != nil {
 		return 0
 	}
 	return uint64(ts.Nsec + ts.Sec*int64(time.Second))
 }
+
+// BootTime returns the wall clock time at which the monotonic clock,
+// and therefore every eBPF timestamp, started counting
+func BootTime() time.Time {
+	return time.Now().Add(-time.Duration(GetNanosecSinceBoot()))
+}
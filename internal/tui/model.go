@@ -0,0 +1,328 @@
+package tui
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pouriyajamshidi/flat/internal/packet"
+	"github.com/pouriyajamshidi/flat/internal/stats"
+)
+
+// trendLength is the number of one second buckets kept for sparklines
+const trendLength = 30
+
+// maxIdle is the number of seconds without a sample after which
+// a destination is removed from the table
+const maxIdle = 300
+
+var sparks = []rune("▁▂▃▄▅▆▇█")
+
+// sortOrders are cycled through with the s key
+var sortOrders = []string{"p99", "count", "mean", "name"}
+
+// tabs are cycled through with the tab key
+var tabs = []struct {
+	name     string
+	protocol uint8
+}{
+	{"All", 0},
+	{"TCP", 6},
+	{"UDP", 17},
+}
+
+type destination struct {
+	name   string
+	sketch *stats.Sketch
+	trend  []float64 // mean latency of each second, oldest first
+	sum    float64   // latencies of the current second
+	count  int
+	idle   int // seconds since the last sample
+}
+
+// Row is a rendered line of the flow table
+type Row struct {
+	Name     string
+	Protocol uint8
+	Summary  stats.Summary
+	Trend    []float64
+}
+
+// Model holds the state of the interactive view
+type Model struct {
+	mu           sync.Mutex
+	destinations map[stats.Destination]*destination
+	sortOrder    int
+	tab          int
+	filter       string
+	editing      bool
+	paused       bool
+	frozen       []Row
+}
+
+// NewModel constructs a new Model
+func NewModel() *Model {
+	return &Model{destinations: make(map[stats.Destination]*destination)}
+}
+
+// Record adds a latency sample for a destination displayed as name
+func (m *Model) Record(dest stats.Destination, name string, latency time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current, ok := m.destinations[dest]
+
+	if !ok {
+		current = &destination{sketch: stats.NewSketch(stats.DefaultRelativeAccuracy)}
+		m.destinations[dest] = current
+	}
+
+	current.name = name
+	current.sketch.Add(float64(latency))
+	current.sum += float64(latency)
+	current.count++
+}
+
+// Tick closes the current second of every sparkline and removes
+// the destinations that have not been seen for maxIdle seconds
+func (m *Model) Tick() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for dest, current := range m.destinations {
+		var mean float64
+
+		if current.count > 0 {
+			mean = current.sum / float64(current.count)
+			current.idle = 0
+		} else if current.idle++; current.idle >= maxIdle {
+			delete(m.destinations, dest)
+			continue
+		}
+
+		current.trend = append(current.trend, mean)
+
+		if len(current.trend) > trendLength {
+			current.trend = current.trend[len(current.trend)-trendLength:]
+		}
+
+		current.sum = 0
+		current.count = 0
+	}
+}
+
+// HandleKey applies a key press and reports whether the user asked to quit
+func (m *Model) HandleKey(key byte) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.editing {
+		switch key {
+		case '\r', '\n':
+			m.editing = false
+		case 0x1b: // escape
+			m.editing = false
+			m.filter = ""
+		case 0x7f, 0x08: // backspace
+			if len(m.filter) > 0 {
+				m.filter = m.filter[:len(m.filter)-1]
+			}
+		default:
+			if key >= ' ' && key < 0x7f {
+				m.filter += string(key)
+			}
+		}
+
+		return false
+	}
+
+	switch key {
+	case 'q':
+		return true
+	case 's':
+		m.sortOrder = (m.sortOrder + 1) % len(sortOrders)
+	case '\t':
+		m.tab = (m.tab + 1) % len(tabs)
+	case '/':
+		m.editing = true
+	case 0x1b:
+		m.filter = ""
+	case ' ', 'p':
+		m.paused = !m.paused
+
+		if m.paused {
+			m.frozen = m.rows()
+		}
+	case 'r':
+		m.destinations = make(map[stats.Destination]*destination)
+	}
+
+	return false
+}
+
+// rows returns the visible rows in the selected order. Callers must hold the lock
+func (m *Model) rows() []Row {
+	rows := make([]Row, 0, len(m.destinations))
+	protocol := tabs[m.tab].protocol
+
+	for dest, current := range m.destinations {
+		if protocol != 0 && dest.Protocol != protocol {
+			continue
+		}
+
+		if m.filter != "" && !strings.Contains(current.name, m.filter) {
+			continue
+		}
+
+		rows = append(rows, Row{
+			Name:     current.name,
+			Protocol: dest.Protocol,
+			Summary:  stats.Summarize(current.sketch),
+			Trend:    slices.Clone(current.trend),
+		})
+	}
+
+	slices.SortFunc(rows, func(a, b Row) int {
+		var order int
+
+		switch sortOrders[m.sortOrder] {
+		case "p99":
+			order = cmp.Compare(b.Summary.P99, a.Summary.P99)
+		case "count":
+			order = cmp.Compare(b.Summary.Count, a.Summary.Count)
+		case "mean":
+			order = cmp.Compare(b.Summary.Mean, a.Summary.Mean)
+		}
+
+		return cmp.Or(order, cmp.Compare(a.Name, b.Name))
+	})
+
+	return rows
+}
+
+// Rows returns the visible rows, or the frozen ones while paused
+func (m *Model) Rows() []Row {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.paused {
+		return m.frozen
+	}
+
+	return m.rows()
+}
+
+// sparkline draws values scaled to the largest one
+func sparkline(values []float64) string {
+	var peak float64
+
+	for _, value := range values {
+		peak = max(peak, value)
+	}
+
+	var line strings.Builder
+
+	for _, value := range values {
+		if value == 0 || peak == 0 {
+			line.WriteRune(' ')
+			continue
+		}
+
+		line.WriteRune(sparks[int(value/peak*float64(len(sparks)-1))])
+	}
+
+	return line.String()
+}
+
+func millis(d time.Duration) string {
+	return fmt.Sprintf("%.3f", float64(d)/float64(time.Millisecond))
+}
+
+func truncate(value string, width int) string {
+	runes := []rune(value)
+
+	if len(runes) <= width {
+		return value
+	}
+
+	if width < 1 {
+		return ""
+	}
+
+	return string(runes[:width-1]) + "…"
+}
+
+// Render draws the whole screen for a terminal of the given size
+func (m *Model) Render(width, height int) string {
+	rows := m.Rows()
+
+	m.mu.Lock()
+	sortOrder := sortOrders[m.sortOrder]
+	tab := m.tab
+	filter := m.filter
+	editing := m.editing
+	paused := m.paused
+	m.mu.Unlock()
+
+	var screen strings.Builder
+
+	var tabNames []string
+
+	for i, t := range tabs {
+		if i == tab {
+			tabNames = append(tabNames, "["+t.name+"]")
+		} else {
+			tabNames = append(tabNames, " "+t.name+" ")
+		}
+	}
+
+	status := fmt.Sprintf("flat | %d destinations | sort: %v | %v", len(rows), sortOrder, strings.Join(tabNames, ""))
+
+	if filter != "" || editing {
+		status += " | filter: " + filter
+
+		if editing {
+			status += "_"
+		}
+	}
+
+	if paused {
+		status += " | PAUSED"
+	}
+
+	screen.WriteString(truncate(status, width) + "\r\n")
+
+	const numbers = "%6s %8s %10s %10s %10s %10s "
+
+	nameWidth := max(width-len(fmt.Sprintf(numbers, "", "", "", "", "", ""))-trendLength-1, 10)
+
+	header := fmt.Sprintf("%-*s "+numbers+"%v", nameWidth, "DESTINATION", "PROTO", "COUNT", "P50 ms", "P99 ms", "MEAN ms", "MAX ms", "TREND")
+	screen.WriteString(truncate(header, width) + "\r\n")
+
+	// Leave room for the status, header and help lines
+	for i, row := range rows {
+		if i >= height-3 {
+			break
+		}
+
+		line := fmt.Sprintf("%-*s "+numbers+"%v",
+			nameWidth, truncate(row.Name, nameWidth),
+			packet.ProtocolName(row.Protocol),
+			fmt.Sprint(row.Summary.Count),
+			millis(row.Summary.P50),
+			millis(row.Summary.P99),
+			millis(row.Summary.Mean),
+			millis(row.Summary.Max),
+			sparkline(row.Trend),
+		)
+
+		screen.WriteString(truncate(line, width) + "\r\n")
+	}
+
+	screen.WriteString(truncate("s: sort  tab: protocol  /: filter  esc: clear filter  space: pause  r: reset  q: quit", width))
+
+	return screen.String()
+}
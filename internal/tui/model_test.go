@@ -0,0 +1,117 @@
+package tui
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pouriyajamshidi/flat/internal/stats"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	database = stats.Destination{IP: netip.MustParseAddr("10.0.0.5"), Port: 5432, Protocol: 6}
+	resolver = stats.Destination{IP: netip.MustParseAddr("1.1.1.1"), Port: 53, Protocol: 17}
+)
+
+func newTestModel() *Model {
+	model := NewModel()
+
+	for i := 0; i < 10; i++ {
+		model.Record(database, "db (10.0.0.5:5432)", time.Millisecond)
+	}
+
+	model.Record(resolver, "1.1.1.1:53", time.Millisecond*40)
+
+	return model
+}
+
+func names(rows []Row) []string {
+	var result []string
+
+	for _, row := range rows {
+		result = append(result, row.Name)
+	}
+
+	return result
+}
+
+func TestSortAndTabs(t *testing.T) {
+	model := newTestModel()
+
+	// p99 is the default order
+	require.Equal(t, []string{"1.1.1.1:53", "db (10.0.0.5:5432)"}, names(model.Rows()))
+
+	model.HandleKey('s')
+	require.Equal(t, []string{"db (10.0.0.5:5432)", "1.1.1.1:53"}, names(model.Rows()))
+
+	model.HandleKey('\t')
+	require.Equal(t, []string{"db (10.0.0.5:5432)"}, names(model.Rows()))
+
+	model.HandleKey('\t')
+	require.Equal(t, []string{"1.1.1.1:53"}, names(model.Rows()))
+}
+
+func TestFilter(t *testing.T) {
+	model := newTestModel()
+
+	for _, key := range []byte("/db\r") {
+		require.False(t, model.HandleKey(key))
+	}
+
+	require.Equal(t, []string{"db (10.0.0.5:5432)"}, names(model.Rows()))
+
+	model.HandleKey(0x1b)
+	require.Len(t, model.Rows(), 2)
+
+	// q is part of the filter while editing
+	model.HandleKey('/')
+	require.False(t, model.HandleKey('q'))
+	model.HandleKey('\r')
+	require.True(t, model.HandleKey('q'))
+}
+
+func TestPause(t *testing.T) {
+	model := newTestModel()
+
+	model.HandleKey(' ')
+	model.Record(stats.Destination{IP: netip.MustParseAddr("8.8.8.8"), Port: 53, Protocol: 17}, "8.8.8.8:53", time.Millisecond)
+	require.Len(t, model.Rows(), 2)
+
+	model.HandleKey(' ')
+	require.Len(t, model.Rows(), 3)
+}
+
+func TestTickRemovesIdle(t *testing.T) {
+	model := newTestModel()
+
+	for range maxIdle {
+		model.Record(database, "db (10.0.0.5:5432)", time.Millisecond)
+		model.Tick()
+	}
+
+	require.Len(t, model.Rows(), 2)
+
+	// The resolver was last seen in the first second
+	model.Tick()
+	require.Equal(t, []string{"db (10.0.0.5:5432)"}, names(model.Rows()))
+}
+
+func TestRenderSparkline(t *testing.T) {
+	model := newTestModel()
+
+	model.Tick()
+	model.Record(database, "db (10.0.0.5:5432)", time.Millisecond*8)
+	model.Tick()
+
+	screen := model.Render(160, 10)
+
+	require.Contains(t, screen, "sort: p99")
+	require.Contains(t, screen, "db (10.0.0.5:5432)")
+	require.Contains(t, screen, "▁█")
+
+	for _, line := range strings.Split(screen, "\r\n") {
+		require.LessOrEqual(t, len([]rune(line)), 160)
+	}
+}
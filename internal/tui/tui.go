@@ -0,0 +1,125 @@
+package tui
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	redrawInterval = time.Millisecond * 500
+
+	enterAltScreen = "\x1b[?1049h\x1b[?25l"
+	exitAltScreen  = "\x1b[?25h\x1b[?1049l"
+	clearScreen    = "\x1b[H\x1b[2J"
+)
+
+// makeRaw disables line buffering and echo on the terminal
+// and returns the previous settings so they can be restored
+func makeRaw(fd int) (*unix.Termios, error) {
+	termios, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+
+	if err != nil {
+		return nil, err
+	}
+
+	raw := *termios
+	raw.Iflag &^= unix.ICRNL | unix.IXON
+	raw.Lflag &^= unix.ECHO | unix.ICANON // keep ISIG so that Ctrl+C still works
+	raw.Cc[unix.VMIN] = 1
+	raw.Cc[unix.VTIME] = 0
+
+	if err := unix.IoctlSetTermios(fd, unix.TCSETS, &raw); err != nil {
+		return nil, err
+	}
+
+	return termios, nil
+}
+
+func terminalSize(fd int) (int, int) {
+	size, err := unix.IoctlGetWinsize(fd, unix.TIOCGWINSZ)
+
+	if err != nil || size.Col == 0 || size.Row == 0 {
+		return 120, 40
+	}
+
+	return int(size.Col), int(size.Row)
+}
+
+// Run draws the model full-screen and handles key presses until ctx
+// is cancelled or the user quits, in which case quit is called.
+// Log output is suppressed while the view is on screen
+func Run(ctx context.Context, model *Model, quit context.CancelFunc) error {
+	stdin := int(os.Stdin.Fd())
+	stdout := int(os.Stdout.Fd())
+
+	termios, err := makeRaw(stdin)
+
+	if err != nil {
+		return err
+	}
+
+	logOutput := log.Writer()
+	log.SetOutput(io.Discard)
+
+	os.Stdout.WriteString(enterAltScreen)
+
+	defer func() {
+		os.Stdout.WriteString(exitAltScreen)
+		unix.IoctlSetTermios(stdin, unix.TCSETS, termios)
+		log.SetOutput(logOutput)
+	}()
+
+	keys := make(chan byte)
+
+	go func() {
+		buf := make([]byte, 1)
+
+		for {
+			if _, err := os.Stdin.Read(buf); err != nil {
+				return
+			}
+
+			select {
+			case keys <- buf[0]:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	redraw := time.NewTicker(redrawInterval)
+	defer redraw.Stop()
+
+	trend := time.NewTicker(time.Second)
+	defer trend.Stop()
+
+	draw := func() {
+		width, height := terminalSize(stdout)
+		os.Stdout.WriteString(clearScreen + model.Render(width, height))
+	}
+
+	draw()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-trend.C:
+			model.Tick()
+		case <-redraw.C:
+			draw()
+		case key := <-keys:
+			if model.HandleKey(key) {
+				quit()
+				return nil
+			}
+
+			draw()
+		}
+	}
+}
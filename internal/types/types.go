@@ -2,13 +2,79 @@ package types
 
 import (
 	"net/netip"
+	"time"
 
+	"github.com/pouriyajamshidi/flat/internal/api"
+	"github.com/pouriyajamshidi/flat/internal/baseline"
+	"github.com/pouriyajamshidi/flat/internal/flowtable"
+	"github.com/pouriyajamshidi/flat/internal/history"
+	"github.com/pouriyajamshidi/flat/internal/ipfix"
+	"github.com/pouriyajamshidi/flat/internal/metrics"
+	"github.com/pouriyajamshidi/flat/internal/otlp"
+	"github.com/pouriyajamshidi/flat/internal/stats"
 	"github.com/vishvananda/netlink"
 )
 
 // UserInput holds the information provided through flags
 type UserInput struct {
 	Interface netlink.Link
-	IP        netip.Addr
-	Port      uint16
+	IPs       []netip.Addr // filters that can be changed at runtime
+	Ports     []uint16
+	Process   bool
+	Stats     bool
+	FlowTable flowtable.Config
+
+	Statistics stats.Config
+
+	Interval    time.Duration
+	SummaryOnly bool
+	GroupBy     string
+	TUI         bool
+	Output      string
+	JSONFile    string
+
+	KubeSnapshot string
+	KubeRefresh  time.Duration
+	KubePod      string
+	KubeService  string
+
+	Resolve       bool
+	ResolveServer string
+	ResolveTTL    time.Duration
+	DNSSnoop      bool
+
+	GeoIPDatabase string
+	ASNDatabase   string
+	Country       string
+	ASN           uint32
+
+	AlertRules string
+
+	MetricsAddr string
+	Metrics     metrics.Config
+
+	OTLP otlp.Config
+
+	API api.Config
+
+	ControlSocket string
+
+	StatsDAddr   string
+	StatsDPrefix string
+
+	IPFIX ipfix.Config
+
+	History history.Config
+
+	PcapFile    string
+	PcapMaxSize int64
+	PcapMaxAge  time.Duration
+
+	Anomaly      bool
+	Baseline     baseline.Config
+	BaselineFile string
+
+	RecordFile string
+	ReadFile   string // pcap, pcapng or recording to read instead of an interface
+	Realtime   bool   // replay a recording at the pace it was recorded
 }